@@ -17,9 +17,11 @@ GRANT ALL PRIVILEGES ON bastion.* TO 'bastion'@'%';
 CREATE TABLE users (
     pk INT UNSIGNED NOT NULL AUTO_INCREMENT,
     name CHAR(128) NOT NULL,
+    login CHAR(128),
     last_login TIMESTAMP,
     PRIMARY KEY (pk),
-    KEY `users_name_index` (`name`)
+    KEY `users_name_index` (`name`),
+    UNIQUE KEY `users_login_uindex` (`login`)
 ) ENGINE INNODB;
 
 --
@@ -31,7 +33,7 @@ CREATE TABLE protocols (
     default_port INT UNSIGNED NOT NULL,
     PRIMARY KEY (pk)
 ) ENGINE INNODB;
-INSERT INTO protocols(pk, name, default_port) VALUES (1, 'SSH', 22), (2, 'Telnet', 23);
+INSERT INTO protocols(pk, name, default_port) VALUES (1, 'SSH', 22), (2, 'Telnet', 23), (3, 'Raw', 4001), (4, 'RFC2217', 5001);
 
 --
 -- Таблица содержит учётные данные для доступа
@@ -41,6 +43,7 @@ CREATE TABLE target_credentials (
     target_login CHAR(128) NOT NULL,
     target_password CHAR(128),
     target_private_key VARCHAR(8192),
+    target_enable_password CHAR(128),
     PRIMARY KEY (pk)
 ) ENGINE INNODB;
 
@@ -63,6 +66,8 @@ CREATE TABLE mandates (
     name CHAR(128) NOT NULL,
     network_id INT UNSIGNED NOT NULL,
     target_credentials_id INT UNSIGNED NOT NULL,
+    requires_mfa BOOL NOT NULL DEFAULT FALSE,
+    read_only BOOL NOT NULL DEFAULT FALSE,
     PRIMARY KEY (pk)
 ) ENGINE INNODB;
 ALTER TABLE mandates ADD CONSTRAINT mandates_target_credentials_fk
@@ -138,3 +143,156 @@ CREATE TABLE session_templates (
     CONSTRAINT `session_templates_mandates_fk` FOREIGN KEY (`mandate_id`) REFERENCES `mandates` (`pk`),
     CONSTRAINT `session_templates_networks_fk` FOREIGN KEY (`custom_target_network_id`) REFERENCES `networks` (`pk`)
 ) ENGINE INNODB;
+
+--
+-- Таблица содержит секреты TOTP (второй фактор) пользователей
+--
+CREATE TABLE user_totp (
+    user_id INT UNSIGNED NOT NULL,
+    secret CHAR(64) NOT NULL,
+    enabled BOOL NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at timestamp NOT NULL DEFAULT current_timestamp(),
+    PRIMARY KEY (user_id),
+    CONSTRAINT `user_totp_users_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`pk`)
+) ENGINE INNODB;
+
+--
+-- Таблица содержит хэши одноразовых кодов восстановления второго фактора
+--
+CREATE TABLE user_recovery_codes (
+    pk INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    user_id INT UNSIGNED NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at timestamp NULL DEFAULT NULL,
+    PRIMARY KEY (pk),
+    KEY `user_recovery_codes_users_fk` (`user_id`),
+    CONSTRAINT `user_recovery_codes_users_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`pk`)
+) ENGINE INNODB;
+
+--
+-- Таблица содержит открытые SSH-ключи пользователей для прямого подключения к прокси (без web-интерфейса)
+--
+CREATE TABLE user_ssh_keys (
+    pk INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    user_id INT UNSIGNED NOT NULL,
+    name CHAR(128) NOT NULL,
+    fingerprint CHAR(128) NOT NULL,
+    public_key VARCHAR(8192) NOT NULL,
+    created_at timestamp NOT NULL DEFAULT current_timestamp(),
+    PRIMARY KEY (pk),
+    UNIQUE KEY `user_ssh_keys_fingerprint_uindex` (`user_id`, `fingerprint`),
+    CONSTRAINT `user_ssh_keys_users_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`pk`)
+) ENGINE INNODB;
+
+--
+-- Таблица содержит разрешённые для проброса TCP-портов (direct-tcpip) цели по каждому мандату
+--
+CREATE TABLE mandate_forward_targets (
+    pk INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    mandate_id INT UNSIGNED NOT NULL,
+    target_host CHAR(128) NOT NULL,
+    target_port INT UNSIGNED NOT NULL,
+    PRIMARY KEY (pk),
+    UNIQUE KEY `mandate_forward_targets_uindex` (`mandate_id`, `target_host`, `target_port`),
+    CONSTRAINT `mandate_forward_targets_mandates_fk` FOREIGN KEY (`mandate_id`) REFERENCES `mandates` (`pk`)
+) ENGINE INNODB;
+
+--
+-- Таблица содержит параметры последовательных портов консольных серверов (протокол RFC2217)
+-- parity: none, odd, even, mark, space; stop_bits: 1, 2 или 15 (1,5 стоп-бита)
+--
+CREATE TABLE serial_port_settings (
+    pk INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    network_id INT UNSIGNED NOT NULL,
+    target_host CHAR(128) NOT NULL,
+    target_port INT UNSIGNED NOT NULL,
+    baud_rate INT UNSIGNED NOT NULL DEFAULT 9600,
+    data_bits TINYINT UNSIGNED NOT NULL DEFAULT 8,
+    parity CHAR(5) NOT NULL DEFAULT 'none',
+    stop_bits TINYINT UNSIGNED NOT NULL DEFAULT 1,
+    PRIMARY KEY (pk),
+    UNIQUE KEY `serial_port_settings_uindex` (`network_id`, `target_host`, `target_port`),
+    CONSTRAINT `serial_port_settings_networks_fk` FOREIGN KEY (`network_id`) REFERENCES `networks` (`pk`)
+) ENGINE INNODB;
+
+--
+-- Таблица содержит профили автоматического входа на устройства (приглашения, переход в привилегированный режим,
+-- команды после входа, признаки неудачного входа). Профиль выбирается по сети и протоколу цели, более конкретный
+-- (с заданной сетью, затем с заданным протоколом) имеет приоритет. Регулярные выражения в синтаксисе Go (RE2),
+-- post_login_commands и failure_patterns содержат по одному значению в строке
+--
+CREATE TABLE login_profiles (
+    pk INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name CHAR(128) NOT NULL,
+    network_id INT UNSIGNED,
+    protocol_id INT UNSIGNED,
+    username_prompt VARCHAR(256),
+    password_prompt VARCHAR(256) NOT NULL,
+    command_prompt VARCHAR(256),
+    enable_command CHAR(64),
+    enable_prompt VARCHAR(256),
+    post_login_commands VARCHAR(2048),
+    failure_patterns VARCHAR(2048),
+    timeout_sec INT UNSIGNED NOT NULL DEFAULT 5,
+    PRIMARY KEY (pk),
+    KEY `login_profiles_networks_fk` (`network_id`),
+    KEY `login_profiles_protocols_fk` (`protocol_id`),
+    CONSTRAINT `login_profiles_networks_fk` FOREIGN KEY (`network_id`) REFERENCES `networks` (`pk`),
+    CONSTRAINT `login_profiles_protocols_fk` FOREIGN KEY (`protocol_id`) REFERENCES `protocols` (`pk`)
+) ENGINE INNODB;
+INSERT INTO login_profiles(name, protocol_id, username_prompt, password_prompt, failure_patterns)
+VALUES ('Telnet по умолчанию', 2, 'ogin: ', 'assword: ', '(?i)(login incorrect|authentication failed)');
+
+--
+-- Таблица содержит правила фильтрации команд интерактивных сессий по мандату. Правила проверяются в порядке pk,
+-- действие первого совпавшего правила (allow, deny, confirm) применяется к команде. Если у мандата есть правила allow,
+-- команды, не совпавшие ни с одним правилом, запрещаются, иначе разрешаются
+--
+CREATE TABLE mandate_command_rules (
+    pk INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    mandate_id INT UNSIGNED NOT NULL,
+    action ENUM('allow', 'deny', 'confirm') NOT NULL,
+    pattern VARCHAR(512) NOT NULL,
+    PRIMARY KEY (pk),
+    KEY `mandate_command_rules_mandates_fk` (`mandate_id`),
+    CONSTRAINT `mandate_command_rules_mandates_fk` FOREIGN KEY (`mandate_id`) REFERENCES `mandates` (`pk`)
+) ENGINE INNODB;
+
+--
+-- История сессий: запись создаётся при выдаче токена и дополняется при получении сессии прокси
+-- и по сообщениям прокси о подключении к цели, ошибках и завершении сессии. В отличие от sessions, записи не удаляются
+--
+CREATE TABLE session_history (
+    pk INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    token CHAR(128) NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    origin_ip CHAR(40) NOT NULL,
+    network_id INT UNSIGNED NOT NULL,
+    target_proto_id INT UNSIGNED NOT NULL,
+    target_host CHAR(128) NOT NULL,
+    target_port INT UNSIGNED NOT NULL,
+    mandate_id INT UNSIGNED,
+    auth_method CHAR(32),
+    proxy_client_id CHAR(128),
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp(),
+    redeemed_at TIMESTAMP NULL,
+    started_at TIMESTAMP NULL,
+    ended_at TIMESTAMP NULL,
+    end_reason CHAR(64),
+    exit_status INT,
+    error VARCHAR(1024),
+    bytes_in BIGINT UNSIGNED,
+    bytes_out BIGINT UNSIGNED,
+    duration_ms BIGINT UNSIGNED,
+    PRIMARY KEY (pk),
+    UNIQUE KEY `session_history_token_uindex` (`token`),
+    KEY `session_history_created_at_index` (`created_at`),
+    KEY `session_history_target_host_index` (`target_host`),
+    KEY `session_history_users_fk` (`user_id`),
+    KEY `session_history_networks_fk` (`network_id`),
+    KEY `session_history_protocols_fk` (`target_proto_id`),
+    CONSTRAINT `session_history_users_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`pk`),
+    CONSTRAINT `session_history_networks_fk` FOREIGN KEY (`network_id`) REFERENCES `networks` (`pk`),
+    CONSTRAINT `session_history_protocols_fk` FOREIGN KEY (`target_proto_id`) REFERENCES `protocols` (`pk`)
+) ENGINE INNODB;
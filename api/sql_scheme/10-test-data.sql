@@ -1,6 +1,6 @@
 USE bastion;
 
-INSERT INTO users(pk, name, last_login) VALUES (1, 'S-1-5-21-2382012410-1563639239-1097593746-5019', current_timestamp());
+INSERT INTO users(pk, name, login, last_login) VALUES (1, 'S-1-5-21-2382012410-1563639239-1097593746-5019', 'test.user', current_timestamp());
 
 INSERT INTO target_credentials(pk, target_login, target_password, target_private_key) VALUES (1, 'sshtest', 'sshtest', null);
 INSERT INTO target_credentials(pk, target_login, target_password, target_private_key) VALUES (2, 'teltest', 'teltestPassw0rd', null);
@@ -21,3 +21,14 @@ INSERT INTO session_templates(pk, name, user_id, target_proto_id, target_host, t
 VALUES (2, 'Telnet Test server', 1, 2, '10.73.0.3', 23, 2);
 INSERT INTO session_templates(pk, name, user_id, target_proto_id, target_host, target_port, mandate_id, custom_target_network_id, custom_target_login, custom_target_password)
 VALUES (3, 'SSH Test server with custom credentials', 1, 1, '10.73.0.2', 22, null, 3, 'sshtest', 'sshtest');
+
+INSERT INTO mandate_forward_targets(mandate_id, target_host, target_port) VALUES (1, '10.73.0.2', 443), (1, '10.73.0.2', 830);
+
+INSERT INTO serial_port_settings(network_id, target_host, target_port, baud_rate, data_bits, parity, stop_bits)
+VALUES (3, '10.73.0.4', 5001, 115200, 8, 'none', 1);
+
+INSERT INTO login_profiles(name, network_id, protocol_id, username_prompt, password_prompt, command_prompt, enable_command, enable_prompt, post_login_commands, failure_patterns, timeout_sec)
+VALUES ('Cisco IOS в NT3', 3, 2, 'Username: ', 'Password: ', '[>#]\\s*$', 'enable', 'Password: ', 'terminal length 0', '(?i)% (login invalid|authentication failed|bad passwords)', 10);
+
+INSERT INTO mandate_command_rules(mandate_id, action, pattern)
+VALUES (1, 'deny', '^rm\\s+-[a-z]*r[a-z]*f?\\s+/\\s*$'), (2, 'deny', '^(reload|erase\\s+startup-config)'), (2, 'confirm', '^(conf(igure)?\\s+t(erminal)?|write)');
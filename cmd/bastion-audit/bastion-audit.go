@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bastion/internal/log"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+var Version string
+
+const usage = `Usage: bastion-audit verify [--public-key FILE] [FILE...]
+
+Verifies hash chain and checkpoint signatures of Bastion audit log files
+(standard input if no files given). Exported ranges of records are accepted.
+With --public-key every record must be covered by a signed checkpoint,
+otherwise verification fails.
+`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "verify" {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+	flags := pflag.NewFlagSet("verify", pflag.ContinueOnError)
+	publicKeyFile := flags.String("public-key", "", "Ed25519 public key (PEM) to verify checkpoint signatures with")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+	var publicKey ed25519.PublicKey
+	if *publicKeyFile != "" {
+		var err error
+		publicKey, err = log.LoadVerificationKey(*publicKeyFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	ok := true
+	for _, name := range files {
+		if !verify(name, publicKey) {
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func verify(name string, publicKey ed25519.PublicKey) bool {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Printf("%s: %s\n", name, err)
+			return false
+		}
+		defer f.Close()
+		r = f
+	}
+	v, err := log.VerifyChain(r, publicKey)
+	if err != nil {
+		fmt.Printf("%s: FAILED: %s\n", name, err)
+		return false
+	}
+	if v.LastSeq == 0 {
+		fmt.Printf("%s: empty\n", name)
+		return true
+	}
+	fmt.Printf("%s: OK, records %d-%d (%d events, %d checkpoints)\n", name, v.FirstSeq, v.LastSeq, v.Records, v.Checkpoints)
+	// Записи, не покрытые подписанной контрольной точкой, можно изменить, пересчитав хеши, поэтому при проверке
+	// подписей они считаются ошибкой
+	switch {
+	case publicKey == nil:
+		fmt.Printf("%s: WARNING: checkpoint signatures not verified (no public key given)\n", name)
+	case v.Checkpoints == 0:
+		fmt.Printf("%s: FAILED: no signed checkpoints\n", name)
+		return false
+	case v.LastCheckpoint != v.LastSeq:
+		fmt.Printf("%s: FAILED: records after %d are not covered by a signed checkpoint\n", name, v.LastCheckpoint)
+		return false
+	}
+	return true
+}
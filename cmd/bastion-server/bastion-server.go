@@ -3,6 +3,7 @@ package main
 import (
 	"bastion/internal/server"
 	"fmt"
+	"os"
 )
 
 var Version string
@@ -10,6 +11,9 @@ var Version string
 func main() {
 	fmt.Printf("Bastion server version %s starting...\n\n", Version)
 	app := server.New()
-	app.Run()
+	err := app.Run()
 	app.Shutdown()
+	if err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"bastion/internal/cli"
+	"os"
+)
+
+var Version string
+
+func main() {
+	app := cli.New()
+	os.Exit(app.Run())
+}
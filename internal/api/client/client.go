@@ -3,15 +3,19 @@ package client
 import (
 	"bastion/internal/api"
 	"bastion/internal/auth"
+	"bastion/internal/tracing"
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 )
@@ -43,26 +47,12 @@ func New(c APIClientConfig) (APIClient, error) {
 	apiClient.logger = c.Logger
 	apiClient.oidcClient = authClient
 
-	rootCAs, err := x509.SystemCertPool()
-	if err != nil {
-		c.Logger.Error(err.Error())
-		return apiClient, err
-	}
 	c.Logger.Debug("Loading certificate", zap.String("cert_file", c.CertificateFile))
-	certs, err := os.ReadFile(c.CertificateFile)
+	transport, err := newTLSTransport(c.CertificateFile)
 	if err != nil {
 		c.Logger.Error(err.Error())
 		return apiClient, err
 	}
-	if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
-		c.Logger.Error("appending certificate to pool failed")
-		return apiClient, err
-	}
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: false,
-		RootCAs:            rootCAs,
-	}
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
 	baseHTTPClient := http.Client{
 		Transport: transport,
 	}
@@ -75,9 +65,46 @@ func New(c APIClientConfig) (APIClient, error) {
 	return apiClient, nil
 }
 
-func (a APIClient) GetSession(token string) (api.ReadSessionDTO, error) {
+// newTLSTransport создаёт транспорт, доверяющий системным корневым сертификатам и сертификату из файла
+// certificateFile (если задан)
+func newTLSTransport(certificateFile string) (*http.Transport, error) {
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, err
+	}
+	if certificateFile != "" {
+		certs, err := os.ReadFile(certificateFile)
+		if err != nil {
+			return nil, err
+		}
+		if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
+			return nil, errors.New("appending certificate to pool failed")
+		}
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+		RootCAs:            rootCAs,
+	}
+	return &http.Transport{TLSClientConfig: tlsConfig}, nil
+}
+
+// GetSession получает у сервера данные сессии по одноразовому токену. Контекст трассировки из ctx передаётся
+// серверу в заголовках запроса
+func (a APIClient) GetSession(ctx context.Context, token string) (api.ReadSessionDTO, error) {
+	ctx, span := tracing.StartKind(ctx, "GetSession", trace.SpanKindClient)
+	sess, err := a.getSession(ctx, token)
+	tracing.End(span, err)
+	return sess, err
+}
+
+func (a APIClient) getSession(ctx context.Context, token string) (api.ReadSessionDTO, error) {
 	sess := api.ReadSessionDTO{}
-	sessionsResponse, err := a.httpClient.Get(fmt.Sprintf("%s/api/sessions/%s", a.apiURL, token))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/sessions/%s", a.apiURL, token), nil)
+	if err != nil {
+		return sess, err
+	}
+	tracing.Inject(ctx, req.Header)
+	sessionsResponse, err := a.httpClient.Do(req)
 	if err != nil {
 		return sess, err
 	}
@@ -93,3 +120,99 @@ func (a APIClient) GetSession(token string) (api.ReadSessionDTO, error) {
 	err = sessionsResponse.Body.Close()
 	return sess, err
 }
+
+// CheckServer проверяет, что сервер бастиона доступен. Запрос выполняется с токеном доступа, поэтому
+// проверяется и его получение у сервера авторизации
+func (a APIClient) CheckServer(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.apiURL+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	response, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+var (
+	// ErrSecondFactorRequired возвращается, если для создания сессии сервер требует одноразовый код второго фактора
+	ErrSecondFactorRequired = errors.New("second factor required")
+	ErrForbidden            = errors.New("access denied")
+	ErrUnauthorized         = errors.New("not authenticated")
+	// ErrRejected возвращается, если сервер отклонил запрос и его повтор не имеет смысла
+	ErrRejected = errors.New("request rejected")
+)
+
+// CreateDirectSession создаёт на сервере сессию для пользователя, аутентифицированного непосредственно на прокси,
+// и возвращает её данные (аналогично GetSession)
+func (a APIClient) CreateDirectSession(ctx context.Context, req api.CreateDirectSessionDTO) (api.ReadSessionDTO, error) {
+	ctx, span := tracing.StartKind(ctx, "CreateDirectSession", trace.SpanKindClient)
+	sess := api.ReadSessionDTO{}
+	err := a.postJSON(ctx, "/api/directsessions", req, &sess)
+	tracing.End(span, err)
+	return sess, err
+}
+
+// AuthenticatePublicKey проверяет, зарегистрирован ли открытый ключ (в формате authorized_keys) у пользователя
+// с коротким именем login
+func (a APIClient) AuthenticatePublicKey(ctx context.Context, login, publicKey string) (api.User, error) {
+	user := api.User{}
+	err := a.postJSON(ctx, "/api/keyauth", api.PublicKeyAuthDTO{UserLogin: login, PublicKey: publicKey}, &user)
+	return user, err
+}
+
+// ForwardingPolicy запрашивает у сервера цели, к которым пользователю разрешён проброс TCP-портов по мандату
+func (a APIClient) ForwardingPolicy(ctx context.Context, req api.ForwardingPolicyRequestDTO) (api.ForwardingPolicyDTO, error) {
+	policy := api.ForwardingPolicyDTO{}
+	err := a.postJSON(ctx, "/api/forwardingpolicies", req, &policy)
+	return policy, err
+}
+
+// ReportSessionEvent сообщает серверу о событии сессии
+func (a APIClient) ReportSessionEvent(ctx context.Context, event api.SessionEventDTO) error {
+	return a.postJSON(ctx, "/api/sessionevents", event, nil)
+}
+
+// postJSON отправляет req и декодирует ответ в resp (если resp не nil)
+func (a APIClient) postJSON(ctx context.Context, path string, req interface{}, resp interface{}) error {
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, a.apiURL+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, request.Header)
+	response, err := a.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusPreconditionRequired:
+		return ErrSecondFactorRequired
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return fmt.Errorf("server responded with status %d", response.StatusCode)
+	default:
+		if response.StatusCode >= 400 && response.StatusCode < 500 {
+			return fmt.Errorf("%w: server responded with status %d", ErrRejected, response.StatusCode)
+		}
+		return fmt.Errorf("server responded with status %d", response.StatusCode)
+	}
+	if resp == nil {
+		return nil
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, resp)
+}
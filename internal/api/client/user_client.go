@@ -0,0 +1,113 @@
+package client
+
+import (
+	"bastion/internal/api"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+type UserAPIClientConfig struct {
+	Endpoint        string
+	CertificateFile string
+	IDToken         string
+}
+
+// UserAPIClient обращается к API сервера от имени пользователя, предъявляя его ID Token
+// (используется командной строкой бастиона)
+type UserAPIClient struct {
+	apiURL     string
+	httpClient *http.Client
+}
+
+func NewUserClient(c UserAPIClientConfig) (UserAPIClient, error) {
+	apiClient := UserAPIClient{apiURL: strings.TrimRight(c.Endpoint, "/")}
+	transport, err := newTLSTransport(c.CertificateFile)
+	if err != nil {
+		return apiClient, err
+	}
+	apiClient.httpClient = &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.IDToken, TokenType: "Bearer"}),
+			Base:   transport,
+		},
+		// Перенаправление означает, что сервер не принял токен и отправляет на страницу входа
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return apiClient, nil
+}
+
+func (a UserAPIClient) UserData() (api.ReadUserDTO, error) {
+	data := api.ReadUserDTO{}
+	resp, err := a.httpClient.Get(a.apiURL + "/api/userdata")
+	if err != nil {
+		return data, err
+	}
+	err = decodeResponse(resp, &data)
+	return data, err
+}
+
+// CreateSession создаёт сессию; params содержит те же поля формы, что отправляет web-интерфейс
+func (a UserAPIClient) CreateSession(params url.Values) (api.SessionLocatorDTO, error) {
+	locator := api.SessionLocatorDTO{}
+	resp, err := a.httpClient.PostForm(a.apiURL+"/api/sessions", params)
+	if err != nil {
+		return locator, err
+	}
+	err = decodeResponse(resp, &locator)
+	return locator, err
+}
+
+// CreateSSHCertificate запрашивает SSH-сертификат для открытого ключа publicKey (в формате authorized_keys).
+// Мандаты, требующие второй фактор, включаются в сертификат только при непустом mfaCode
+func (a UserAPIClient) CreateSSHCertificate(publicKey, mfaCode string) (api.SSHCertificateDTO, error) {
+	cert := api.SSHCertificateDTO{}
+	params := url.Values{}
+	params.Set("public_key", publicKey)
+	params.Set("mfa_code", mfaCode)
+	resp, err := a.httpClient.PostForm(a.apiURL+"/api/sshcerts", params)
+	if err != nil {
+		return cert, err
+	}
+	err = decodeResponse(resp, &cert)
+	return cert, err
+}
+
+// SSHConfig возвращает фрагмент конфигурации OpenSSH с псевдонимами для шаблонов сессий пользователя
+func (a UserAPIClient) SSHConfig(params url.Values) (string, error) {
+	resp, err := a.httpClient.Get(a.apiURL + "/api/sshconfig?" + params.Encode())
+	if err != nil {
+		return "", err
+	}
+	body, err := readResponse(resp)
+	return string(body), err
+}
+
+func decodeResponse(resp *http.Response, v interface{}) error {
+	body, err := readResponse(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return nil, ErrForbidden
+	case http.StatusUnauthorized, http.StatusFound:
+		return nil, ErrUnauthorized
+	default:
+		return nil, fmt.Errorf("server responded with status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -29,22 +29,76 @@ type SessionLocatorDTO struct {
 }
 
 type ReadSessionDTO struct {
-	OriginIP       string `json:"origin_ip"`
-	TargetNetwork  string `json:"target_network"`
-	TargetProtocol string `json:"target_protocol"`
-	TargetHost     string `json:"target_host"`
-	TargetPort     string `json:"target_port"`
-	TargetLogin    string `json:"target-login"`
-	TargetPassword string `json:"target-password"`
-	TargetPrivKey  string `json:"target-priv-key"`
+	Token                string          `json:"token,omitempty"`     // Идентифицирует сессию в истории при сообщении о её завершении
+	UserName             string          `json:"user_name,omitempty"` // Пользователь и мандат сессии, используются прокси в событиях аудита
+	MandateID            int             `json:"mandate_id,omitempty"`
+	OriginIP             string          `json:"origin_ip"`
+	TargetNetwork        string          `json:"target_network"`
+	TargetProtocol       string          `json:"target_protocol"`
+	TargetHost           string          `json:"target_host"`
+	TargetPort           string          `json:"target_port"`
+	TargetLogin          string          `json:"target-login"`
+	TargetPassword       string          `json:"target-password"`
+	TargetPrivKey        string          `json:"target-priv-key"`
+	TargetEnablePassword string          `json:"target-enable-password,omitempty"` // Пароль привилегированного режима, используется только прокси
+	ReadOnly             bool            `json:"read_only"`                        // Мандат не разрешает изменять конфигурацию цели (учитывается для NETCONF)
+	Serial               *SerialSettings `json:"serial,omitempty"`
+	LoginProfile         *LoginProfile   `json:"login_profile,omitempty"`
+	CommandRules         []CommandRule   `json:"command_rules,omitempty"`
+}
+
+const (
+	CommandActionAllow   = "allow"
+	CommandActionDeny    = "deny"
+	CommandActionConfirm = "confirm"
+)
+
+// CommandRule - правило фильтрации команд, вводимых пользователем в интерактивной сессии
+type CommandRule struct {
+	Action  string `json:"action"`
+	Pattern string `json:"pattern"`
+}
+
+// LoginProfile описывает автоматический вход на устройство: регулярные выражения приглашений, переход
+// в привилегированный режим, команды после входа и признаки неудачного входа
+type LoginProfile struct {
+	Name              string   `json:"name"`
+	UsernamePrompt    string   `json:"username_prompt,omitempty"`
+	PasswordPrompt    string   `json:"password_prompt"`
+	CommandPrompt     string   `json:"command_prompt,omitempty"`
+	EnableCommand     string   `json:"enable_command,omitempty"`
+	EnablePrompt      string   `json:"enable_prompt,omitempty"`
+	PostLoginCommands []string `json:"post_login_commands,omitempty"`
+	FailurePatterns   []string `json:"failure_patterns,omitempty"`
+	TimeoutSec        int      `json:"timeout_sec"`
+}
+
+// SerialSettings - параметры последовательного порта цели, устанавливаемые через RFC 2217 (Telnet COM Port Control)
+type SerialSettings struct {
+	BaudRate int    `json:"baud_rate"`
+	DataBits int    `json:"data_bits"`
+	Parity   string `json:"parity"`    // none, odd, even, mark, space
+	StopBits int    `json:"stop_bits"` // 1, 2 или 15 (1,5 стоп-бита)
 }
 
 type ReadUserDTO struct {
 	User             User              `json:"user"`
+	MFAEnabled       bool              `json:"mfa_enabled"`
+	SSHKeys          []SSHKey          `json:"ssh_keys"`
+	Protocols        []Protocol        `json:"protocols"`
 	Mandates         []Mandate         `json:"mandates"`
 	SessionTemplates []SessionTemplate `json:"session_templates"`
 }
 
+type TOTPEnrollmentDTO struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type RecoveryCodesDTO struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
 type Protocol struct {
 	ID          int    `json:"id"`
 	Name        string `json:"name"`
@@ -52,13 +106,15 @@ type Protocol struct {
 }
 
 type Mandate struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	RequiresMFA bool   `json:"requires_mfa"`
 }
 
 type User struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Login string `json:"login,omitempty"`
 }
 
 type Network struct {
@@ -81,3 +137,162 @@ type SessionTemplate struct {
 	CustomTargetPassword  string     `json:"custom_target_password,omitempty"`
 	CustomTargetPrivKey   string     `json:"custom_target_priv_key,omitempty"`
 }
+
+type SSHCertificateDTO struct {
+	Certificate string `json:"certificate"`
+	ValidBefore int64  `json:"valid_before"`
+}
+
+const (
+	AuthMethodCertificate = "certificate"
+	AuthMethodPublicKey   = "publickey"
+	AuthMethodDeviceCode  = "device-code"
+)
+
+// CreateDirectSessionDTO передаётся прокси серверу для создания сессии пользователя, аутентифицированного
+// непосредственно на прокси (по SSH-сертификату, открытому ключу или через Device Authorization Grant), без предварительного обращения к web-интерфейсу.
+// Если TargetPort равен 0, используется протокол SSH и его порт по умолчанию
+type CreateDirectSessionDTO struct {
+	OriginIP   string `json:"origin_ip"`
+	UserName   string `json:"user_name"`
+	AuthMethod string `json:"auth_method"`
+	MandateID  int    `json:"mandate_id"`
+	TargetHost string `json:"target_host"`
+	TargetPort int    `json:"target_port"`
+	MFACode    string `json:"mfa_code,omitempty"`
+}
+
+// ForwardingPolicyRequestDTO передаётся прокси серверу для получения правил проброса TCP-портов пользователя,
+// аутентифицированного непосредственно на прокси
+type ForwardingPolicyRequestDTO struct {
+	UserName   string `json:"user_name"`
+	AuthMethod string `json:"auth_method"`
+	MandateID  int    `json:"mandate_id"`
+}
+
+// ForwardingPolicyDTO содержит сеть мандата и разрешённые для проброса цели в виде host:port
+type ForwardingPolicyDTO struct {
+	TargetNetwork string   `json:"target_network"`
+	Targets       []string `json:"targets"`
+}
+
+const (
+	SessionEventStarted = "started" // Соединение с целью установлено
+	SessionEventEnded   = "ended"
+	SessionEventError   = "error"
+)
+
+// SessionEventDTO передаётся прокси серверу для сообщения о событии сессии с токеном Token.
+// Сообщения могут быть доставлены с задержкой и повторно, поэтому время события OccurredAt (секунды Unix)
+// задаёт прокси, а повторное сообщение о начале или завершении сессии сервер игнорирует
+type SessionEventDTO struct {
+	Token      string `json:"token"`
+	Event      string `json:"event"`
+	OccurredAt int64  `json:"occurred_at"`
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+	BytesIn    int64  `json:"bytes_in,omitempty"`
+	BytesOut   int64  `json:"bytes_out,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	ExitStatus *int   `json:"exit_status,omitempty"`
+}
+
+// SessionHistoryRecord - запись истории сессий. Время задаётся в секундах Unix, 0 - событие ещё не произошло
+type SessionHistoryRecord struct {
+	ID             int    `json:"id"`
+	UserName       string `json:"user_name"`
+	OriginIP       string `json:"origin_ip"`
+	TargetNetwork  string `json:"target_network"`
+	TargetProtocol string `json:"target_protocol"`
+	TargetHost     string `json:"target_host"`
+	TargetPort     int    `json:"target_port"`
+	MandateID      int    `json:"mandate_id,omitempty"`
+	AuthMethod     string `json:"auth_method,omitempty"`
+	ProxyClientID  string `json:"proxy_client_id,omitempty"`
+	CreatedAt      int64  `json:"created_at"`
+	RedeemedAt     int64  `json:"redeemed_at,omitempty"`
+	StartedAt      int64  `json:"started_at,omitempty"`
+	EndedAt        int64  `json:"ended_at,omitempty"`
+	EndReason      string `json:"end_reason,omitempty"`
+	ExitStatus     *int   `json:"exit_status,omitempty"`
+	Error          string `json:"error,omitempty"`
+	BytesIn        int64  `json:"bytes_in,omitempty"`
+	BytesOut       int64  `json:"bytes_out,omitempty"`
+	DurationMs     int64  `json:"duration_ms,omitempty"`
+}
+
+// SessionLine - строка потока сессии из журнала. Time - время записи в миллисекундах Unix
+type SessionLine struct {
+	Time     int64  `json:"time"`
+	StreamID string `json:"stream_id"`
+	Message  string `json:"message"`
+}
+
+// SessionSearchMatch - найденная строка сессии с соседними строками. В Highlight найденный текст
+// обрамлён символами SearchHighlightStart и SearchHighlightEnd
+type SessionSearchMatch struct {
+	SessionLine
+	Highlight string        `json:"highlight"`
+	Before    []SessionLine `json:"before"`
+	After     []SessionLine `json:"after"`
+}
+
+// SessionSearchResult - найденные строки одной сессии. Session не заполняется, если сессии нет в истории
+type SessionSearchResult struct {
+	Token   string                `json:"token"`
+	Session *SessionHistoryRecord `json:"session,omitempty"`
+	Matches []SessionSearchMatch  `json:"matches"`
+}
+
+// SessionSearchQuery задаёт поиск по тексту сессий. From и To - границы времени в секундах Unix (0 - без границы),
+// Stream - поток сессии: пусто (все), SearchStreamInput или SearchStreamOutput
+type SessionSearchQuery struct {
+	Text    string
+	Stream  string
+	From    int64
+	To      int64
+	Limit   int
+	Context int // Число соседних строк до и после найденной
+}
+
+const (
+	SearchStreamInput  = "input"
+	SearchStreamOutput = "output"
+
+	SearchHighlightStart = "\u0001"
+	SearchHighlightEnd   = "\u0002"
+)
+
+// SessionHistoryFilter - условия выборки истории сессий, пустые условия не применяются
+type SessionHistoryFilter struct {
+	UserName      string
+	TargetNetwork string
+	TargetHost    string
+	From          int64 // Секунды Unix, включительно
+	To            int64 // Секунды Unix, не включительно
+	Limit         int
+}
+
+type PublicKeyAuthDTO struct {
+	UserLogin string `json:"user_login"`
+	PublicKey string `json:"public_key"`
+}
+
+type SSHKey struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+}
+
+// HealthDTO - результат проверки готовности сервиса: общий статус и результаты отдельных проверок
+// (HealthOK или текст ошибки)
+type HealthDTO struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
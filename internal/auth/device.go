@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// DeviceAuthorization содержит ответ сервера авторизации на запрос Device Authorization Grant (RFC 8628)
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+const (
+	deviceCodeGrantType   = "urn:ietf:params:oauth:grant-type:device_code"
+	defaultPollInterval   = 5 * time.Second
+	slowDownPollIncrement = 5 * time.Second
+)
+
+var ErrDeviceFlowUnsupported = errors.New("authorization server does not support device authorization grant")
+
+// StartDeviceAuthorization запрашивает у сервера авторизации код устройства и код пользователя.
+// Пользователь должен открыть VerificationURI на любом устройстве с браузером и ввести UserCode
+func (client *OIDCClient) StartDeviceAuthorization(ctx context.Context) (*DeviceAuthorization, error) {
+	if client.deviceAuthURL == "" {
+		return nil, ErrDeviceFlowUnsupported
+	}
+	form := url.Values{}
+	form.Set("client_id", client.acgConfig.ClientID)
+	form.Set("scope", strings.Join(client.acgConfig.Scopes, " "))
+	body, err := client.postForm(ctx, client.deviceAuthURL, form)
+	if err != nil {
+		return nil, err
+	}
+	da := &DeviceAuthorization{}
+	if err := json.Unmarshal(body, da); err != nil {
+		return nil, err
+	}
+	if da.DeviceCode == "" || da.UserCode == "" || da.VerificationURI == "" {
+		return nil, errors.New("malformed device authorization response")
+	}
+	return da, nil
+}
+
+// PollDeviceToken опрашивает сервер авторизации до тех пор, пока пользователь не подтвердит вход,
+// не откажет в нём, либо не истечёт срок действия кода устройства или контекст ctx
+func (client *OIDCClient) PollDeviceToken(ctx context.Context, da *DeviceAuthorization) (*oauth2.Token, error) {
+	interval := time.Duration(da.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	if da.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(da.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	form := url.Values{}
+	form.Set("grant_type", deviceCodeGrantType)
+	form.Set("device_code", da.DeviceCode)
+	form.Set("client_id", client.acgConfig.ClientID)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		body, err := client.postForm(ctx, client.acgConfig.Endpoint.TokenURL, form)
+		if err != nil && body == nil {
+			return nil, err
+		}
+		var tr deviceTokenResponse
+		if err := json.Unmarshal(body, &tr); err != nil {
+			return nil, err
+		}
+		switch tr.Error {
+		case "":
+			token := &oauth2.Token{
+				AccessToken:  tr.AccessToken,
+				TokenType:    tr.TokenType,
+				RefreshToken: tr.RefreshToken,
+			}
+			if tr.ExpiresIn > 0 {
+				token.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+			}
+			return token.WithExtra(map[string]interface{}{"id_token": tr.IDToken}), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownPollIncrement
+		default:
+			return nil, fmt.Errorf("device authorization failed: %s %s", tr.Error, tr.ErrorDescription)
+		}
+	}
+}
+
+// postForm отправляет форму от имени клиента. При ответе с ошибкой возвращает и тело ответа,
+// так как сервер авторизации передаёт в нём код ошибки (RFC 6749, п. 5.2)
+func (client *OIDCClient) postForm(ctx context.Context, endpoint string, form url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if client.acgConfig.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(client.acgConfig.ClientID), url.QueryEscape(client.acgConfig.ClientSecret))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, fmt.Errorf("authorization server responded with status %d", resp.StatusCode)
+	}
+	return body, nil
+}
@@ -1,7 +1,13 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc"
@@ -12,16 +18,19 @@ import (
 )
 
 type OIDCClient struct {
-	provider  oidc.Provider
-	oidc      oidc.Config
-	acgConfig oauth2.Config            // Config for 'Authorization Code Grant'
-	ccgConfig clientcredentials.Config // Config for 'Client Credentials Grant'
+	issuer        string
+	provider      oidc.Provider
+	oidc          oidc.Config
+	acgConfig     oauth2.Config            // Config for 'Authorization Code Grant'
+	ccgConfig     clientcredentials.Config // Config for 'Client Credentials Grant'
+	deviceAuthURL string                   // Endpoint for 'Device Authorization Grant', empty if unsupported by provider
 }
 
 const contextTimeout = time.Second * 15
 
 func New(issuer, clientID, clientSecret, redirectURL string, scopes []string) (*OIDCClient, error) {
 	c := &OIDCClient{
+		issuer:    issuer,
 		provider:  oidc.Provider{},
 		oidc:      oidc.Config{ClientID: clientID},
 		acgConfig: oauth2.Config{},
@@ -33,6 +42,12 @@ func New(issuer, clientID, clientSecret, redirectURL string, scopes []string) (*
 		return c, err
 	}
 	c.provider = *provider
+	var providerClaims struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	}
+	if err := provider.Claims(&providerClaims); err == nil {
+		c.deviceAuthURL = providerClaims.DeviceAuthorizationEndpoint
+	}
 
 	s := scopes
 	s = append(s, oidc.ScopeOpenID)
@@ -51,18 +66,36 @@ func New(issuer, clientID, clientSecret, redirectURL string, scopes []string) (*
 	return c, nil
 }
 
+// CheckProvider проверяет, что сервер авторизации доступен и отдаёт документ OpenID Provider Configuration
+func (client *OIDCClient) CheckProvider(ctx context.Context) error {
+	url := strings.TrimSuffix(client.issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("OIDC provider responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
 func (client *OIDCClient) HTTPClientWithAccessToken(ctx context.Context) *http.Client {
 	return client.ccgConfig.Client(ctx)
 }
 
-func (client *OIDCClient) AuthCodeURL(state string) string {
-	return client.acgConfig.AuthCodeURL(state)
+func (client *OIDCClient) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return client.acgConfig.AuthCodeURL(state, opts...)
 }
 
-func (client *OIDCClient) FetchToken(code string, logger *zap.Logger) (*oauth2.Token, error) {
+func (client *OIDCClient) FetchToken(code string, logger *zap.Logger, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
 	defer cancel()
-	token, err := client.acgConfig.Exchange(ctx, code)
+	token, err := client.acgConfig.Exchange(ctx, code, opts...)
 	if err != nil {
 		logger.Error(err.Error())
 		return token, err
@@ -70,6 +103,30 @@ func (client *OIDCClient) FetchToken(code string, logger *zap.Logger) (*oauth2.T
 	return token, nil
 }
 
+// NewPKCEVerifier создаёт случайный code_verifier для PKCE (RFC 7636), которым публичный клиент подтверждает,
+// что код авторизации получен именно им
+func NewPKCEVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge возвращает параметры запроса авторизации с code_challenge, вычисленным из verifier методом S256
+func PKCEChallenge(verifier string) []oauth2.AuthCodeOption {
+	sum := sha256.Sum256([]byte(verifier))
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+// PKCEVerifier возвращает параметр обмена кода авторизации на токен с code_verifier
+func PKCEVerifier(verifier string) oauth2.AuthCodeOption {
+	return oauth2.SetAuthURLParam("code_verifier", verifier)
+}
+
 func (client *OIDCClient) VerifyIDToken(rawIDToken string) (*oidc.IDToken, error) {
 	verifier := client.provider.Verifier(&client.oidc)
 	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
@@ -80,3 +137,31 @@ func (client *OIDCClient) VerifyIDToken(rawIDToken string) (*oidc.IDToken, error
 	}
 	return idToken, nil
 }
+
+// VerifyIDTokenForClients проверяет ID Token, выпущенный для одного из клиентов clientIDs (например, для
+// командной строки бастиона, предъявляющей ID Token в заголовке Authorization)
+func (client *OIDCClient) VerifyIDTokenForClients(rawIDToken string, clientIDs []string) (*oidc.IDToken, error) {
+	verifier := client.provider.Verifier(&oidc.Config{SkipClientIDCheck: true})
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	for _, aud := range idToken.Audience {
+		for _, id := range clientIDs {
+			if aud == id {
+				return idToken, nil
+			}
+		}
+	}
+	return nil, errors.New("ID token was issued for unknown client")
+}
+
+// RefreshToken получает новый набор токенов по Refresh Token
+func (client *OIDCClient) RefreshToken(token *oauth2.Token) (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+	expired := &oauth2.Token{RefreshToken: token.RefreshToken}
+	return client.acgConfig.TokenSource(ctx, expired).Token()
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TokenPreface начинает строку "BASTION-TOKEN <токен>\n", которую командная строка бастиона, работая как ProxyCommand
+// без SSH-сертификатов, отправляет прокси перед идентификационной строкой SSH. Прокси использует токен сессии
+// из преамбулы вместо имени пользователя SSH
+const TokenPreface = "BASTION-TOKEN "
+
+const (
+	mandatePrincipalPrefix = "mandate-"
+	certClockSkew          = time.Minute // Сертификат действителен чуть раньше момента выпуска на случай расхождения часов
+)
+
+// SSHCertificateAuthority выпускает короткоживущие пользовательские SSH-сертификаты.
+// Каждый доступный пользователю мандат записывается в сертификат отдельным принципалом (см. MandatePrincipal),
+// идентификатор ключа (KeyId) содержит SID пользователя
+type SSHCertificateAuthority struct {
+	signer ssh.Signer
+	ttl    time.Duration
+}
+
+func NewSSHCertificateAuthority(keyFile string, ttl time.Duration) (*SSHCertificateAuthority, error) {
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, err
+	}
+	return &SSHCertificateAuthority{signer: signer, ttl: ttl}, nil
+}
+
+// PublicKey возвращает открытый ключ УЦ в формате authorized_keys (для TrustedUserCAKeys и конфигурации прокси)
+func (ca *SSHCertificateAuthority) PublicKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.signer.PublicKey())))
+}
+
+// IssueUserCertificate подписывает открытый ключ пользователя userSID сертификатом, разрешающим доступ
+// по мандатам mandateIDs
+func (ca *SSHCertificateAuthority) IssueUserCertificate(key ssh.PublicKey, userSID string, mandateIDs []int) (*ssh.Certificate, error) {
+	if len(mandateIDs) == 0 {
+		return nil, fmt.Errorf("user %s has no mandates", userSID)
+	}
+	principals := make([]string, 0, len(mandateIDs))
+	for _, id := range mandateIDs {
+		principals = append(principals, MandatePrincipal(id))
+	}
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           userSID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-certClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(ca.ttl).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// MandatePrincipal возвращает имя принципала SSH-сертификата, соответствующего мандату
+func MandatePrincipal(mandateID int) string {
+	return mandatePrincipalPrefix + strconv.Itoa(mandateID)
+}
+
+// ParseCertTarget разбирает имя пользователя SSH вида "<ID мандата>+<хост>[:<порт>]", которое
+// предъявляется прокси вместе с сертификатом. Если порт не указан, возвращается 0
+func ParseCertTarget(user string) (int, string, int, error) {
+	parts := strings.SplitN(user, "+", 2)
+	if len(parts) != 2 {
+		return 0, "", 0, fmt.Errorf("malformed target '%s', expect <mandate>+<host>[:<port>]", user)
+	}
+	return parseMandateTarget(parts[0], parts[1])
+}
+
+// ParseDirectTarget разбирает имя пользователя SSH вида "<пользователь>%<ID мандата>%<хост>[:<порт>]",
+// которое предъявляется прокси при прямом подключении по открытому ключу. Если порт не указан, возвращается 0
+func ParseDirectTarget(user string) (string, int, string, int, error) {
+	parts := strings.SplitN(user, "%", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return "", 0, "", 0, fmt.Errorf("malformed target '%s', expect <user>%%<mandate>%%<host>[:<port>]", user)
+	}
+	mandateID, host, port, err := parseMandateTarget(parts[1], parts[2])
+	return parts[0], mandateID, host, port, err
+}
+
+// IsDirectTarget возвращает true, если имя пользователя SSH задаёт цель прямого подключения (см. ParseDirectTarget)
+func IsDirectTarget(user string) bool {
+	return strings.Count(user, "%") >= 2
+}
+
+func parseMandateTarget(mandate, target string) (int, string, int, error) {
+	mandateID, err := strconv.Atoi(mandate)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("malformed mandate ID '%s'", mandate)
+	}
+	host := target
+	port := 0
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		port, err = strconv.Atoi(host[i+1:])
+		if err != nil || port <= 0 || port > 65535 {
+			return 0, "", 0, fmt.Errorf("malformed port '%s'", host[i+1:])
+		}
+		host = host[:i]
+	}
+	if host == "" {
+		return 0, "", 0, fmt.Errorf("empty target host in '%s'", target)
+	}
+	return mandateID, host, port, nil
+}
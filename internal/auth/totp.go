@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // RFC 6238 по умолчанию использует HMAC-SHA1, его понимают все приложения-аутентификаторы
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod          = 30 // Длительность шага TOTP, секунд
+	totpDigits          = 6
+	totpSkewSteps       = 1 // Допустимое расхождение часов клиента и сервера, шагов
+	totpSecretSize      = 20
+	recoveryCodeSize    = 5
+	RecoveryCodesNumber = 10
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret генерирует новый секрет TOTP в кодировке base32 (без выравнивания)
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI возвращает URI формата otpauth:// для импорта секрета в приложение-аутентификатор
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// ValidateTOTP проверяет одноразовый код code для секрета secret на момент времени t.
+// Возвращает номер шага, которому соответствует код: вызывающая сторона должна запоминать его
+// и отвергать коды с номером шага не больше последнего использованного (защита от повтора)
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return 0, false
+	}
+	step := t.Unix() / totpPeriod
+	for i := -totpSkewSteps; i <= totpSkewSteps; i++ {
+		s := step + int64(i)
+		expected := totpCode(key, s)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode вычисляет код HOTP (RFC 4226) для счётчика counter
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// NewRecoveryCodes генерирует набор одноразовых кодов восстановления доступа.
+// Пользователю показываются сами коды, в хранилище сохраняются только их хэши (см. HashRecoveryCode)
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodesNumber)
+	for i := 0; i < RecoveryCodesNumber; i++ {
+		b := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := hex.EncodeToString(b)
+		codes = append(codes, c[:5]+"-"+c[5:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode возвращает хэш кода восстановления, пригодный для хранения в БД
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
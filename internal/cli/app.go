@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/api/client"
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type BastionCLI struct {
+	config ConfigStruct
+	args   []string
+}
+
+func New() *BastionCLI {
+	config, args := LoadConfiguration()
+	return &BastionCLI{config: config, args: args}
+}
+
+// Run выполняет команду, заданную в командной строке, и возвращает код завершения процесса
+func (cli *BastionCLI) Run() int {
+	if len(cli.args) == 0 {
+		usage()
+		return 2
+	}
+	var err error
+	exitCode := 0
+	switch cli.args[0] {
+	case "login":
+		_, err = cli.login()
+	case "logout":
+		err = removeToken()
+	case "mandates":
+		err = cli.listMandates()
+	case "templates":
+		err = cli.listTemplates()
+	case "connect":
+		exitCode, err = cli.connect(cli.args[1:])
+	case "ssh-config":
+		err = cli.sshConfig()
+	case "proxy-command":
+		err = cli.proxyCommand(cli.args[1:])
+	case "proxy-token":
+		err = cli.proxyToken(cli.args[1:])
+	default:
+		usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err.Error())
+		return 1
+	}
+	return exitCode
+}
+
+func (cli *BastionCLI) apiClient() (client.UserAPIClient, error) {
+	idToken, err := cli.idToken()
+	if err != nil {
+		return client.UserAPIClient{}, err
+	}
+	return client.NewUserClient(client.UserAPIClientConfig{
+		Endpoint:        cli.config.Server.URL,
+		CertificateFile: cli.config.Server.CertificateFile,
+		IDToken:         idToken,
+	})
+}
+
+func (cli *BastionCLI) userData() (api.ReadUserDTO, error) {
+	c, err := cli.apiClient()
+	if err != nil {
+		return api.ReadUserDTO{}, err
+	}
+	return c.UserData()
+}
+
+func (cli *BastionCLI) listMandates() error {
+	data, err := cli.userData()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMFA\tNAME")
+	for _, m := range data.Mandates {
+		mfa := ""
+		if m.RequiresMFA {
+			mfa = "yes"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.ID, mfa, m.Name)
+	}
+	return w.Flush()
+}
+
+func (cli *BastionCLI) listTemplates() error {
+	data, err := cli.userData()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPROTOCOL\tTARGET\tACCESS\tNAME")
+	for _, st := range data.SessionTemplates {
+		access := string(st.AccessType)
+		if st.MandateID != 0 {
+			access = fmt.Sprintf("mandate %d", st.MandateID)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", st.ID, protocolName(data.Protocols, st.TargetProtocolID),
+			net.JoinHostPort(st.TargetHost, strconv.Itoa(st.TargetPort)), access, st.Name)
+	}
+	return w.Flush()
+}
+
+// connect создаёт сессию по шаблону (если передан его идентификатор или имя) либо по параметрам --mandate/--host,
+// после чего запускает SSH-клиент с одноразовым токеном сессии в качестве имени пользователя
+func (cli *BastionCLI) connect(args []string) (int, error) {
+	c, err := cli.apiClient()
+	if err != nil {
+		return 1, err
+	}
+	data, err := c.UserData()
+	if err != nil {
+		return 1, err
+	}
+	var params url.Values
+	var requiresMFA bool
+	if len(args) > 0 {
+		params, requiresMFA, err = templateSessionParams(data, strings.Join(args, " "))
+	} else {
+		params, requiresMFA, err = cli.mandateSessionParams(data)
+	}
+	if err != nil {
+		return 1, err
+	}
+	locator, err := cli.createSession(c, params, requiresMFA, prompt)
+	if err != nil {
+		return 1, err
+	}
+	return cli.execSSH(locator)
+}
+
+// createSession создаёт сессию с параметрами params, запрашивая через ask код второго фактора, если мандат
+// его требует, а код не задан параметром --mfa-code
+func (cli *BastionCLI) createSession(c client.UserAPIClient, params url.Values, requiresMFA bool, ask func(string) (string, error)) (api.SessionLocatorDTO, error) {
+	var err error
+	mfaCode := cli.config.Connect.MFACode
+	if requiresMFA && mfaCode == "" {
+		mfaCode, err = ask("Мандат требует второй фактор. Одноразовый код: ")
+		if err != nil {
+			return api.SessionLocatorDTO{}, err
+		}
+	}
+	params.Set("mfa_code", mfaCode)
+	locator, err := c.CreateSession(params)
+	if errors.Is(err, client.ErrForbidden) && requiresMFA {
+		// Код мог устареть, пока пользователь вводил его, даём одну повторную попытку
+		mfaCode, err = ask("Код не принят. Одноразовый код: ")
+		if err != nil {
+			return api.SessionLocatorDTO{}, err
+		}
+		params.Set("mfa_code", mfaCode)
+		locator, err = c.CreateSession(params)
+	}
+	return locator, err
+}
+
+func templateSessionParams(data api.ReadUserDTO, nameOrID string) (url.Values, bool, error) {
+	for _, st := range data.SessionTemplates {
+		if st.Name != nameOrID && strconv.Itoa(st.ID) != nameOrID {
+			continue
+		}
+		params := url.Values{}
+		params.Set("hostname", st.TargetHost)
+		params.Set("port", strconv.Itoa(st.TargetPort))
+		params.Set("protocol_id", strconv.Itoa(st.TargetProtocolID))
+		if st.MandateID != 0 {
+			params.Set("access_type", api.AccessTypeMandate)
+			params.Set("mandate_id", strconv.Itoa(st.MandateID))
+		} else {
+			params.Set("access_type", api.AccessTypeCustom)
+			params.Set("custom_network_id", strconv.Itoa(st.CustomTargetNetworkID))
+			params.Set("custom_login", st.CustomTargetLogin)
+			params.Set("custom_password", st.CustomTargetPassword)
+			params.Set("custom_key", st.CustomTargetPrivKey)
+		}
+		return params, mandateRequiresMFA(data, st.MandateID), nil
+	}
+	return nil, false, fmt.Errorf("session template '%s' not found", nameOrID)
+}
+
+func (cli *BastionCLI) mandateSessionParams(data api.ReadUserDTO) (url.Values, bool, error) {
+	cc := cli.config.Connect
+	if cc.MandateID == 0 || cc.Host == "" {
+		return nil, false, errors.New("either session template or --mandate and --host must be given")
+	}
+	var protocol *api.Protocol
+	for i, p := range data.Protocols {
+		if strings.EqualFold(p.Name, cc.Protocol) {
+			protocol = &data.Protocols[i]
+		}
+	}
+	if protocol == nil {
+		return nil, false, fmt.Errorf("unknown protocol '%s'", cc.Protocol)
+	}
+	port := cc.Port
+	if port == 0 {
+		port = protocol.DefaultPort
+	}
+	params := url.Values{}
+	params.Set("hostname", cc.Host)
+	params.Set("port", strconv.Itoa(port))
+	params.Set("protocol_id", strconv.Itoa(protocol.ID))
+	params.Set("access_type", api.AccessTypeMandate)
+	params.Set("mandate_id", strconv.Itoa(cc.MandateID))
+	return params, mandateRequiresMFA(data, cc.MandateID), nil
+}
+
+func (cli *BastionCLI) execSSH(locator api.SessionLocatorDTO) (int, error) {
+	address := locator.Endpoint
+	if cli.config.SSH.UseServicepoint || address == "" {
+		address = locator.Servicepoint
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return 1, err
+	}
+	fmt.Fprintf(os.Stderr, "Подключение к %s через %s...\n", locator.NetworkName, address)
+	cmd := exec.Command(cli.config.SSH.Command, "-t", "-p", port, locator.Token+"@"+host)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func mandateRequiresMFA(data api.ReadUserDTO, mandateID int) bool {
+	for _, m := range data.Mandates {
+		if m.ID == mandateID {
+			return m.RequiresMFA
+		}
+	}
+	return false
+}
+
+func protocolName(protocols []api.Protocol, id int) string {
+	for _, p := range protocols {
+		if p.ID == id {
+			return p.Name
+		}
+	}
+	return strconv.Itoa(id)
+}
+
+func prompt(text string) (string, error) {
+	fmt.Fprint(os.Stderr, text)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+type ConfigStruct struct {
+	Server struct {
+		URL             string `yaml:"url"`
+		CertificateFile string `yaml:"certificateFile"`
+	}
+	OIDC struct {
+		Issuer       string `yaml:"issuer"`
+		ClientID     string `yaml:"clientID"`
+		ClientSecret string `yaml:"clientSecret"`
+		DeviceFlow   bool   `yaml:"deviceFlow"`
+	}
+	SSH struct {
+		Command         string `yaml:"command"`
+		IdentityFile    string `yaml:"identityFile"`
+		UseServicepoint bool   `yaml:"useServicepoint"`
+	}
+	Connect struct {
+		MandateID int
+		Host      string
+		Port      int
+		Protocol  string
+		MFACode   string
+	}
+}
+
+// configDir возвращает каталог с конфигурацией и кэшем токенов командной строки
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bastion"), nil
+}
+
+// LoadConfiguration разбирает аргументы командной строки и файл конфигурации. Если имя файла не задано,
+// используется файл cli.yml в каталоге пользовательской конфигурации (при наличии).
+// Возвращает конфигурацию и оставшиеся позиционные аргументы (команду и её параметры)
+func LoadConfiguration() (ConfigStruct, []string) {
+	var config ConfigStruct
+	var needHelp bool
+	var configFileName string
+
+	pflag.StringVar(&configFileName, "config", "", "Configuration file name (parameters in configuration file have priority over command line args)")
+	pflag.BoolVar(&needHelp, "help", false, "Show available configuration options")
+
+	pflag.StringVar(&config.Server.URL, "server-url", "", "Bastion server URL (mandatory)")
+	pflag.StringVar(&config.Server.CertificateFile, "server-cert", "", "Certificate file name used for connection to Bastion server")
+
+	pflag.StringVar(&config.OIDC.Issuer, "oidc-issuer", "", "OIDC authorization server URL (mandatory)")
+	pflag.StringVar(&config.OIDC.ClientID, "oidc-client-id", "bastion-cli", "OIDC client ID")
+	pflag.StringVar(&config.OIDC.ClientSecret, "oidc-client-secret", "", "OIDC client secret (only if client is registered as confidential)")
+	pflag.BoolVar(&config.OIDC.DeviceFlow, "device", false, "Log in via device authorization grant instead of opening a browser (default false)")
+
+	pflag.StringVar(&config.SSH.Command, "ssh-command", "ssh", "SSH client executable")
+	pflag.StringVar(&config.SSH.IdentityFile, "identity-file", "~/.ssh/id_ed25519", "Private key used with SSH certificates issued by Bastion ('ssh-config' and 'proxy-command' commands)")
+	pflag.BoolVar(&config.SSH.UseServicepoint, "servicepoint", false, "Connect to network's external service point instead of proxy endpoint (default false)")
+
+	pflag.IntVar(&config.Connect.MandateID, "mandate", 0, "Mandate ID for 'connect' command")
+	pflag.StringVar(&config.Connect.Host, "host", "", "Target host for 'connect' command")
+	pflag.IntVar(&config.Connect.Port, "port", 0, "Target port for 'connect' command (protocol's default port if omitted)")
+	pflag.StringVar(&config.Connect.Protocol, "protocol", "SSH", "Target protocol for 'connect' command")
+	pflag.StringVar(&config.Connect.MFACode, "mfa-code", "", "One-time second factor code for mandates requiring it")
+
+	pflag.Usage = usage
+	pflag.Parse()
+	err := viper.BindPFlags(pflag.CommandLine)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if needHelp {
+		pflag.Usage()
+		os.Exit(0)
+	}
+	if configFileName == "" {
+		if dir, err := configDir(); err == nil {
+			if _, err := os.Stat(filepath.Join(dir, "cli.yml")); err == nil {
+				configFileName = filepath.Join(dir, "cli.yml")
+			}
+		}
+	}
+	if configFileName != "" {
+		viper.SetConfigFile(configFileName)
+		viper.SetConfigType("yaml")
+		err := viper.ReadInConfig()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		err = viper.Unmarshal(&config)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+	if config.Server.URL == "" || config.OIDC.Issuer == "" || config.OIDC.ClientID == "" {
+		fmt.Println("Missing mandatory argument(s)")
+		pflag.Usage()
+		os.Exit(1)
+	}
+	return config, pflag.Args()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: bastion [options] <command> [arguments]
+
+Commands:
+  login                 Log in to Bastion server
+  logout                Forget saved credentials
+  mandates              List available mandates
+  templates             List saved session templates
+  connect <template>    Connect using saved session template (name or ID)
+  connect --mandate <ID> --host <host> [--port <port>] [--protocol <name>]
+                        Connect to arbitrary host using mandate
+  ssh-config            Print OpenSSH config snippet with aliases for session templates
+  proxy-command <host> <port> <mandate ID>
+                        Renew SSH certificate and connect to proxy (used as ssh ProxyCommand)
+  proxy-token <host> <port> <template ID>
+                        Create session and pass its token to proxy (used as ssh ProxyCommand)
+
+Options:
+`)
+	pflag.PrintDefaults()
+}
@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"bastion/internal/api/client"
+	"bastion/internal/auth"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+)
+
+// certRenewMargin - сертификат обновляется заранее, чтобы он не истёк во время установления соединения
+const certRenewMargin = time.Minute
+
+// Параметры, относящиеся к отдельному вызову, не передаются в ProxyCommand
+var localFlags = map[string]bool{"help": true, "mandate": true, "host": true, "port": true, "protocol": true, "mfa-code": true}
+
+// sshConfig выводит фрагмент ~/.ssh/config, сформированный сервером по шаблонам сессий пользователя
+func (cli *BastionCLI) sshConfig() error {
+	c, err := cli.apiClient()
+	if err != nil {
+		return err
+	}
+	command, err := proxyCommandWords()
+	if err != nil {
+		return err
+	}
+	identityFile, err := cli.identityFile()
+	if err != nil {
+		return err
+	}
+	certificateFile, err := certificateFile(identityFile)
+	if err != nil {
+		return err
+	}
+	params := url.Values{"command": command}
+	params.Set("identity_file", identityFile)
+	params.Set("certificate_file", certificateFile)
+	if cli.config.SSH.UseServicepoint {
+		params.Set("servicepoint", "true")
+	}
+	config, err := c.SSHConfig(params)
+	if err != nil {
+		return err
+	}
+	fmt.Print(config)
+	return nil
+}
+
+// proxyCommand вызывается ssh в качестве ProxyCommand: обновляет SSH-сертификат пользователя, если он отсутствует,
+// истекает или не содержит мандата mandateID, после чего соединяет стандартные ввод и вывод с прокси host:port.
+// Стандартный вывод занят протоколом SSH, поэтому все сообщения выводятся в stderr, а код второго фактора
+// запрашивается через терминал
+func (cli *BastionCLI) proxyCommand(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: proxy-command <host> <port> <mandate ID>")
+	}
+	mandateID, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("malformed mandate ID '%s'", args[2])
+	}
+	if err := cli.ensureCertificate(mandateID); err != nil {
+		return err
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(args[0], args[1]))
+	if err != nil {
+		return err
+	}
+	return relay(conn)
+}
+
+// proxyToken вызывается ssh в качестве ProxyCommand, если сервер работает без SSH-сертификатов: создаёт сессию
+// по шаблону templateID, соединяется с прокси host:port и передаёт ему одноразовый токен сессии в преамбуле
+// перед данными ssh. Как и в proxyCommand, сообщения выводятся в stderr, а код второго фактора запрашивается
+// через терминал
+func (cli *BastionCLI) proxyToken(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: proxy-token <host> <port> <template ID>")
+	}
+	c, err := cli.apiClient()
+	if err != nil {
+		return err
+	}
+	data, err := c.UserData()
+	if err != nil {
+		return err
+	}
+	params, requiresMFA, err := templateSessionParams(data, args[2])
+	if err != nil {
+		return err
+	}
+	locator, err := cli.createSession(c, params, requiresMFA, ttyPrompt)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(args[0], args[1]))
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, auth.TokenPreface+locator.Token+"\n"); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return relay(conn)
+}
+
+// relay соединяет стандартные ввод и вывод с conn до закрытия соединения и закрывает его
+func relay(conn net.Conn) error {
+	defer conn.Close()
+	go func() {
+		_, _ = io.Copy(conn, os.Stdin)
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			_ = tcpConn.CloseWrite()
+		}
+	}()
+	_, err := io.Copy(os.Stdout, conn)
+	return err
+}
+
+func (cli *BastionCLI) ensureCertificate(mandateID int) error {
+	identityFile, err := cli.identityFile()
+	if err != nil {
+		return err
+	}
+	certFile, err := certificateFile(identityFile)
+	if err != nil {
+		return err
+	}
+	if certificateValid(certFile, mandateID) {
+		return nil
+	}
+	publicKey, err := os.ReadFile(identityFile + ".pub")
+	if err != nil {
+		return err
+	}
+	c, err := cli.apiClient()
+	if err != nil {
+		return err
+	}
+	cert, err := c.CreateSSHCertificate(string(publicKey), cli.config.Connect.MFACode)
+	if err == nil && !hasPrincipal(cert.Certificate, mandateID) {
+		// Мандат требует второй фактор
+		var mfaCode string
+		mfaCode, err = ttyPrompt("Мандат требует второй фактор. Одноразовый код: ")
+		if err != nil {
+			return err
+		}
+		cert, err = c.CreateSSHCertificate(string(publicKey), mfaCode)
+	}
+	if errors.Is(err, client.ErrForbidden) {
+		return errors.New("second factor code is not accepted")
+	}
+	if err != nil {
+		return err
+	}
+	if !hasPrincipal(cert.Certificate, mandateID) {
+		return fmt.Errorf("mandate %d is not available", mandateID)
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, []byte(cert.Certificate+"\n"), 0600)
+}
+
+func certificateValid(certFile string, mandateID int) bool {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return false
+	}
+	cert, ok := parseCertificate(string(data))
+	if !ok || time.Now().Add(certRenewMargin).Unix() >= int64(cert.ValidBefore) {
+		return false
+	}
+	return hasPrincipal(string(data), mandateID)
+}
+
+func hasPrincipal(authorizedKey string, mandateID int) bool {
+	cert, ok := parseCertificate(authorizedKey)
+	if !ok {
+		return false
+	}
+	principal := auth.MandatePrincipal(mandateID)
+	for _, p := range cert.ValidPrincipals {
+		if p == principal {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCertificate(authorizedKey string) (*ssh.Certificate, bool) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, false
+	}
+	cert, ok := key.(*ssh.Certificate)
+	return cert, ok
+}
+
+func (cli *BastionCLI) identityFile() (string, error) {
+	path := cli.config.SSH.IdentityFile
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[2:])
+	}
+	return filepath.Abs(path)
+}
+
+// certificateFile возвращает путь к сертификату бастиона для ключа identityFile. Сертификат хранится в каталоге
+// конфигурации, чтобы не перезаписать сертификаты других УЦ, лежащие рядом с ключом
+func certificateFile(identityFile string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(identityFile)+"-cert.pub"), nil
+}
+
+// proxyCommandWords возвращает команду запуска текущего исполняемого файла с параметрами, заданными
+// пользователем явно, чтобы ProxyCommand использовал ту же конфигурацию. Слова передаются серверу по отдельности,
+// в кавычки оболочки их берёт сервер
+func proxyCommandWords() ([]string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	words := []string{executable}
+	pflag.Visit(func(f *pflag.Flag) {
+		if !localFlags[f.Name] {
+			words = append(words, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	return words, nil
+}
+
+func ttyPrompt(text string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("unable to ask for second factor code: %w", err)
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, text)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
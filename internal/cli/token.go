@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"bastion/internal/auth"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenCacheFileName = "token.json"
+	loginTimeout       = time.Minute * 5
+)
+
+var cliScopes = []string{"profile", "offline_access"}
+
+type cachedToken struct {
+	IDToken      string    `json:"id_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+func tokenCachePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, tokenCacheFileName), nil
+}
+
+func loadToken() (cachedToken, error) {
+	var t cachedToken
+	path, err := tokenCachePath()
+	if err != nil {
+		return t, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return t, err
+	}
+	err = json.Unmarshal(data, &t)
+	return t, err
+}
+
+func saveToken(token *oauth2.Token) (cachedToken, error) {
+	idToken, ok := token.Extra("id_token").(string)
+	if !ok || idToken == "" {
+		return cachedToken{}, errors.New("no id_token in token response")
+	}
+	t := cachedToken{IDToken: idToken, RefreshToken: token.RefreshToken, Expiry: token.Expiry}
+	path, err := tokenCachePath()
+	if err != nil {
+		return t, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return t, err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return t, err
+	}
+	return t, os.WriteFile(path, data, 0600)
+}
+
+func removeToken() error {
+	path, err := tokenCachePath()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// idToken возвращает действующий ID Token пользователя: из кэша, обновлённый по Refresh Token,
+// либо полученный в результате нового входа
+func (cli *BastionCLI) idToken() (string, error) {
+	oidcClient, err := auth.New(cli.config.OIDC.Issuer, cli.config.OIDC.ClientID, cli.config.OIDC.ClientSecret, "", cliScopes)
+	if err != nil {
+		return "", err
+	}
+	cached, err := loadToken()
+	if err == nil {
+		if _, err := oidcClient.VerifyIDToken(cached.IDToken); err == nil {
+			return cached.IDToken, nil
+		}
+		if cached.RefreshToken != "" {
+			token, err := oidcClient.RefreshToken(&oauth2.Token{RefreshToken: cached.RefreshToken})
+			if err == nil {
+				if t, err := saveToken(token); err == nil {
+					return t.IDToken, nil
+				}
+			}
+		}
+	}
+	t, err := cli.login()
+	if err != nil {
+		return "", err
+	}
+	return t.IDToken, nil
+}
+
+// login выполняет вход пользователя через браузер (Authorization Code Grant с перенаправлением
+// на локальный адрес) или через Device Authorization Grant
+func (cli *BastionCLI) login() (cachedToken, error) {
+	var token *oauth2.Token
+	var err error
+	if cli.config.OIDC.DeviceFlow {
+		token, err = cli.deviceLogin()
+	} else {
+		token, err = cli.browserLogin()
+	}
+	if err != nil {
+		return cachedToken{}, err
+	}
+	return saveToken(token)
+}
+
+func (cli *BastionCLI) deviceLogin() (*oauth2.Token, error) {
+	oidcClient, err := auth.New(cli.config.OIDC.Issuer, cli.config.OIDC.ClientID, cli.config.OIDC.ClientSecret, "", cliScopes)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+	da, err := oidcClient.StartDeviceAuthorization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Для входа откройте в браузере %s и введите код %s\n", da.VerificationURI, da.UserCode)
+	return oidcClient.PollDeviceToken(ctx, da)
+}
+
+func (cli *BastionCLI) browserLogin() (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	redirectURL := fmt.Sprintf("http://%s/callback", listener.Addr().String())
+	oidcClient, err := auth.New(cli.config.OIDC.Issuer, cli.config.OIDC.ClientID, cli.config.OIDC.ClientSecret, redirectURL, cliScopes)
+	if err != nil {
+		return nil, err
+	}
+	state := make([]byte, 32)
+	if _, err := rand.Read(state); err != nil {
+		return nil, err
+	}
+	stateToken := hex.EncodeToString(state)
+	// CLI - публичный клиент без секрета, поэтому перехваченный на loopback-адресе код защищается PKCE
+	verifier, err := auth.NewPKCEVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	resultCh := make(chan loginResult, 1)
+	server := &http.Server{
+		ReadHeaderTimeout: time.Second * 10,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/callback" {
+				http.NotFound(w, r)
+				return
+			}
+			if r.FormValue("state") != stateToken {
+				http.Error(w, "state verification failed", http.StatusBadRequest)
+				notify(resultCh, loginResult{err: errors.New("state verification failed")})
+				return
+			}
+			if e := r.FormValue("error"); e != "" {
+				http.Error(w, e, http.StatusUnauthorized)
+				notify(resultCh, loginResult{err: fmt.Errorf("authorization failed: %s %s", e, r.FormValue("error_description"))})
+				return
+			}
+			_, _ = fmt.Fprintln(w, "Вход выполнен, окно можно закрыть")
+			notify(resultCh, loginResult{code: r.FormValue("code")})
+		}),
+	}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	authURL := oidcClient.AuthCodeURL(stateToken, auth.PKCEChallenge(verifier)...)
+	fmt.Fprintf(os.Stderr, "Для входа откройте в браузере:\n%s\n", authURL)
+	_ = openBrowser(authURL)
+
+	select {
+	case result := <-resultCh:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return oidcClient.FetchToken(result.code, zap.NewNop(), auth.PKCEVerifier(verifier))
+	case <-time.After(loginTimeout):
+		return nil, errors.New("login timed out")
+	}
+}
+
+type loginResult struct {
+	code string
+	err  error
+}
+
+// notify отправляет результат входа в канал, не блокируясь, если получатель уже получил результат
+func notify(ch chan loginResult, v loginResult) {
+	select {
+	case ch <- v:
+	default:
+	}
+}
+
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
@@ -0,0 +1,33 @@
+package datastore
+
+import (
+	"bastion/internal/api"
+	"context"
+)
+
+// MandateCommandRules возвращает правила фильтрации команд мандата в порядке их применения
+func MandateCommandRules(ctx context.Context, mandateID int) ([]api.CommandRule, error) {
+	ctx, end := startQuery(ctx, "MandateCommandRules")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return nil, err
+	}
+	var rules []api.CommandRule
+	rows, err := storage.mandateCommandRulesStmt.QueryContext(ctx, mandateID)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rule api.CommandRule
+		err := rows.Scan(&rule.Action, &rule.Pattern)
+		if err != nil {
+			config.Logger.Error(err.Error())
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
@@ -0,0 +1,35 @@
+package datastore
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// MandateForwardTargets возвращает список адресов вида host:port, к которым по мандату разрешён проброс TCP-портов
+func MandateForwardTargets(ctx context.Context, mandateID int) ([]string, error) {
+	ctx, end := startQuery(ctx, "MandateForwardTargets")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return nil, err
+	}
+	targets := []string{}
+	rows, err := storage.mandateForwardTargetsStmt.QueryContext(ctx, mandateID)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var host string
+		var port int
+		err := rows.Scan(&host, &port)
+		if err != nil {
+			config.Logger.Error(err.Error())
+			return nil, err
+		}
+		targets = append(targets, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return targets, nil
+}
@@ -0,0 +1,203 @@
+package datastore
+
+import (
+	"bastion/internal/api"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+const maxHistoryErrorLength = 1024
+
+// sessionHistorySelect - общая часть запросов записей истории сессий, столбцы читает scanSessionHistory
+const sessionHistorySelect = "SELECT h.pk, u.name, h.origin_ip, n.name, p.name, " +
+	"h.target_host, h.target_port, h.mandate_id, h.auth_method, h.proxy_client_id, " +
+	"UNIX_TIMESTAMP(h.created_at), UNIX_TIMESTAMP(h.redeemed_at), UNIX_TIMESTAMP(h.started_at), UNIX_TIMESTAMP(h.ended_at), " +
+	"h.end_reason, h.bytes_in, h.bytes_out, h.duration_ms, h.exit_status, h.error " +
+	"FROM session_history h " +
+	"JOIN users u ON u.pk=h.user_id " +
+	"JOIN networks n ON n.pk=h.network_id " +
+	"JOIN protocols p ON p.pk=h.target_proto_id "
+
+// CreateSessionHistory создаёт запись истории для сессии, выданной с токеном sessionToken
+func CreateSessionHistory(ctx context.Context, sessionToken string, sess api.CreateSessionDTO, networkID int, authMethod string) error {
+	ctx, end := startQuery(ctx, "CreateSessionHistory")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	user, err := User(ctx, sess.UserName)
+	if err != nil {
+		return err
+	}
+	mandateID := sql.NullInt32{Int32: int32(sess.MandateID), Valid: sess.MandateID != 0}
+	method := sql.NullString{String: authMethod, Valid: authMethod != ""}
+	_, err = storage.createSessionHistoryStmt.ExecContext(ctx, sessionToken, user.ID, sess.OriginIP, networkID, sess.TargetProtocolID,
+		sess.TargetHost, sess.TargetPort, mandateID, method)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// RedeemSessionHistory отмечает получение сессии прокси с идентификатором клиента proxyClientID
+func RedeemSessionHistory(ctx context.Context, sessionToken, proxyClientID string) error {
+	ctx, end := startQuery(ctx, "RedeemSessionHistory")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	clientID := sql.NullString{String: proxyClientID, Valid: proxyClientID != ""}
+	_, err = storage.redeemSessionHistoryStmt.ExecContext(ctx, clientID, sessionToken)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// UpdateSessionHistory сохраняет событие сессии, о котором сообщил прокси с идентификатором клиента proxyClientID.
+// Повторные сообщения о начале и завершении сессии не изменяют запись. Если сессия не была выдана этому прокси,
+// возвращается ошибка sql.ErrNoRows
+func UpdateSessionHistory(ctx context.Context, proxyClientID string, event api.SessionEventDTO) error {
+	ctx, end := startQuery(ctx, "UpdateSessionHistory")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	var result sql.Result
+	switch event.Event {
+	case api.SessionEventStarted:
+		result, err = storage.startSessionHistoryStmt.ExecContext(ctx, event.OccurredAt, event.Token, proxyClientID)
+	case api.SessionEventEnded:
+		exitStatus := sql.NullInt32{}
+		if event.ExitStatus != nil {
+			exitStatus = sql.NullInt32{Int32: int32(*event.ExitStatus), Valid: true}
+		}
+		result, err = storage.endSessionHistoryStmt.ExecContext(ctx, event.OccurredAt, event.Reason, event.BytesIn, event.BytesOut,
+			event.DurationMs, exitStatus, event.Token, proxyClientID)
+	case api.SessionEventError:
+		result, err = storage.sessionErrorHistoryStmt.ExecContext(ctx, truncateUTF8(event.Error, maxHistoryErrorLength),
+			event.Token, proxyClientID)
+	default:
+		err = fmt.Errorf("unknown session event '%s'", event.Event)
+	}
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// Запись не изменена: событие повторное либо сессия выдана другому прокси
+	var count int
+	if err := storage.sessionHistoryOwnedStmt.QueryRowContext(ctx, event.Token, proxyClientID).Scan(&count); err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// truncateUTF8 обрезает строку до n байт, не разрезая многобайтовые символы
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// SessionHistory возвращает записи истории сессий, удовлетворяющие фильтру, начиная с самых новых
+func SessionHistory(ctx context.Context, f api.SessionHistoryFilter) ([]api.SessionHistoryRecord, error) {
+	ctx, end := startQuery(ctx, "SessionHistory")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := storage.sessionHistoryStmt.QueryContext(ctx,
+		f.UserName, f.UserName,
+		f.TargetNetwork, f.TargetNetwork,
+		f.TargetHost, f.TargetHost,
+		f.From,
+		f.To, f.To,
+		f.Limit)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	records := []api.SessionHistoryRecord{}
+	for rows.Next() {
+		r, err := scanSessionHistory(rows)
+		if err != nil {
+			config.Logger.Error(err.Error())
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// SessionHistoryByToken возвращает запись истории сессии с токеном sessionToken.
+// Если такой сессии нет, возвращается ошибка sql.ErrNoRows
+func SessionHistoryByToken(ctx context.Context, sessionToken string) (api.SessionHistoryRecord, error) {
+	ctx, end := startQuery(ctx, "SessionHistoryByToken")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return api.SessionHistoryRecord{}, err
+	}
+	r, err := scanSessionHistory(storage.sessionHistoryByTokenStmt.QueryRowContext(ctx, sessionToken))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		config.Logger.Error(err.Error())
+	}
+	return r, err
+}
+
+// rowScanner - *sql.Row или *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSessionHistory(row rowScanner) (api.SessionHistoryRecord, error) {
+	var r api.SessionHistoryRecord
+	var mandateID sql.NullInt64
+	var authMethod, proxyClientID, endReason sql.NullString
+	var redeemedAt, startedAt, endedAt, bytesIn, bytesOut, durationMs, exitStatus sql.NullInt64
+	var historyError sql.NullString
+	err := row.Scan(&r.ID, &r.UserName, &r.OriginIP, &r.TargetNetwork, &r.TargetProtocol,
+		&r.TargetHost, &r.TargetPort, &mandateID, &authMethod, &proxyClientID,
+		&r.CreatedAt, &redeemedAt, &startedAt, &endedAt,
+		&endReason, &bytesIn, &bytesOut, &durationMs, &exitStatus, &historyError)
+	if err != nil {
+		return r, err
+	}
+	r.MandateID = int(mandateID.Int64)
+	r.AuthMethod = authMethod.String
+	r.ProxyClientID = proxyClientID.String
+	r.RedeemedAt = redeemedAt.Int64
+	r.StartedAt = startedAt.Int64
+	r.EndedAt = endedAt.Int64
+	r.EndReason = endReason.String
+	r.BytesIn = bytesIn.Int64
+	r.BytesOut = bytesOut.Int64
+	r.DurationMs = durationMs.Int64
+	if exitStatus.Valid {
+		status := int(exitStatus.Int64)
+		r.ExitStatus = &status
+	}
+	r.Error = historyError.String
+	return r, nil
+}
@@ -0,0 +1,22 @@
+package datastore
+
+import "testing"
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"connection refused", 64, "connection refused"},
+		{"connection refused", 10, "connection"},
+		{"Ошибка", 12, "Ошибка"},
+		{"Ошибка", 5, "Ош"},
+		{"Ошибка", 1, ""},
+	}
+	for _, tt := range tests {
+		if got := truncateUTF8(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
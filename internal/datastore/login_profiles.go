@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"bastion/internal/api"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// LoginProfile возвращает наиболее подходящий профиль автоматического входа для сети networkID и протокола protocol.
+// Если подходящего профиля нет, возвращается nil без ошибки
+func LoginProfile(ctx context.Context, networkID int, protocol string) (*api.LoginProfile, error) {
+	ctx, end := startQuery(ctx, "LoginProfile")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return nil, err
+	}
+	var profile api.LoginProfile
+	var usernamePrompt, commandPrompt, enableCommand, enablePrompt, postLoginCommands, failurePatterns sql.NullString
+	row := storage.loginProfileStmt.QueryRowContext(ctx, networkID, protocol)
+	err = row.Scan(
+		&profile.Name,
+		&usernamePrompt,
+		&profile.PasswordPrompt,
+		&commandPrompt,
+		&enableCommand,
+		&enablePrompt,
+		&postLoginCommands,
+		&failurePatterns,
+		&profile.TimeoutSec)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return nil, err
+	}
+	profile.UsernamePrompt = usernamePrompt.String
+	profile.CommandPrompt = commandPrompt.String
+	profile.EnableCommand = enableCommand.String
+	profile.EnablePrompt = enablePrompt.String
+	profile.PostLoginCommands = splitLines(postLoginCommands.String)
+	profile.FailurePatterns = splitLines(failurePatterns.String)
+	return &profile, nil
+}
+
+// splitLines разбивает многострочное значение на непустые строки
+func splitLines(value string) []string {
+	var result []string
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
@@ -0,0 +1,35 @@
+package datastore
+
+import (
+	"bastion/internal/tracing"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "bastion_server_db_query_duration_seconds",
+	Help:    "Duration of datastore calls, including all queries made by the call",
+	Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"query"})
+
+// startQuery начинает span трассировки и измерение длительности обращения к хранилищу. Возвращает контекст
+// для запросов к БД и функцию, которая завершает измерение: ctx, end := startQuery(ctx, "User"); defer end()
+func startQuery(ctx context.Context, name string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "datastore."+name, semconv.DBSystemMySQL, semconv.DBOperation(name))
+	return ctx, func() {
+		span.End()
+		queryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// registerDBStats публикует статистику пула соединений с БД (метрики go_sql_*)
+func registerDBStats(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "bastion"))
+}
@@ -0,0 +1,207 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+func MandateRequiresMFA(ctx context.Context, mandateID int) (bool, error) {
+	ctx, end := startQuery(ctx, "MandateRequiresMFA")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return false, err
+	}
+	var requiresMFA bool
+	row := storage.mandateRequiresMFAStmt.QueryRowContext(ctx, mandateID)
+	err = row.Scan(&requiresMFA)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return false, err
+	}
+	return requiresMFA, nil
+}
+
+// UserTOTP возвращает секрет TOTP пользователя и признак завершённой регистрации второго фактора.
+// Если пользователь не начинал регистрацию, возвращается пустой секрет без ошибки
+func UserTOTP(ctx context.Context, userName string) (string, bool, error) {
+	ctx, end := startQuery(ctx, "UserTOTP")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return "", false, err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return "", false, err
+	}
+	var secret string
+	var enabled bool
+	row := storage.userTOTPStmt.QueryRowContext(ctx, user.ID)
+	err = row.Scan(&secret, &enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return "", false, err
+	}
+	return secret, enabled, nil
+}
+
+// StartTOTPEnrollment сохраняет новый (ещё не подтверждённый) секрет TOTP пользователя
+func StartTOTPEnrollment(ctx context.Context, userName, secret string) error {
+	ctx, end := startQuery(ctx, "StartTOTPEnrollment")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return err
+	}
+	_, err = storage.saveUserTOTPStmt.ExecContext(ctx, user.ID, secret)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// ConfirmTOTPEnrollment включает второй фактор пользователя и заменяет его коды восстановления
+// новыми (передаются хэши кодов)
+func ConfirmTOTPEnrollment(ctx context.Context, userName string, recoveryCodeHashes []string) error {
+	ctx, end := startQuery(ctx, "ConfirmTOTPEnrollment")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return err
+	}
+	tx, err := storage.db.BeginTx(ctx, nil)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	_, err = tx.Stmt(storage.enableUserTOTPStmt).ExecContext(ctx, user.ID)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		_ = tx.Rollback()
+		return err
+	}
+	_, err = tx.Stmt(storage.deleteRecoveryCodesStmt).ExecContext(ctx, user.ID)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		_ = tx.Rollback()
+		return err
+	}
+	for _, h := range recoveryCodeHashes {
+		_, err = tx.Stmt(storage.createRecoveryCodeStmt).ExecContext(ctx, user.ID, h)
+		if err != nil {
+			config.Logger.Error(err.Error())
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// UseTOTPStep отмечает шаг TOTP как использованный. Возвращает false, если этот или более поздний шаг
+// уже был использован ранее (повторное предъявление кода)
+func UseTOTPStep(ctx context.Context, userName string, step int64) (bool, error) {
+	ctx, end := startQuery(ctx, "UseTOTPStep")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return false, err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return false, err
+	}
+	result, err := storage.useTOTPStepStmt.ExecContext(ctx, step, user.ID, step)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode погашает код восстановления с хэшем codeHash. Возвращает false, если такого
+// неиспользованного кода у пользователя нет
+func UseRecoveryCode(ctx context.Context, userName, codeHash string) (bool, error) {
+	ctx, end := startQuery(ctx, "UseRecoveryCode")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return false, err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return false, err
+	}
+	result, err := storage.useRecoveryCodeStmt.ExecContext(ctx, user.ID, codeHash)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ResetMFA удаляет секрет TOTP и коды восстановления пользователя. После сброса пользователь
+// должен заново пройти регистрацию второго фактора
+func ResetMFA(ctx context.Context, userName string) error {
+	ctx, end := startQuery(ctx, "ResetMFA")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return err
+	}
+	tx, err := storage.db.BeginTx(ctx, nil)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	_, err = tx.Stmt(storage.deleteRecoveryCodesStmt).ExecContext(ctx, user.ID)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		_ = tx.Rollback()
+		return err
+	}
+	_, err = tx.Stmt(storage.deleteUserTOTPStmt).ExecContext(ctx, user.ID)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		_ = tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
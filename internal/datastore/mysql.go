@@ -2,10 +2,12 @@ package datastore
 
 import (
 	"bastion/internal/api"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql" // use MySQL implementation of database/sql interface
+	"strings"
 	"sync"
 )
 
@@ -24,6 +26,34 @@ type mysqlDriver struct {
 	createSessionStmt         *sql.Stmt
 	sessionStmt               *sql.Stmt
 	deleteSessionStmt         *sql.Stmt
+	mandateRequiresMFAStmt    *sql.Stmt
+	mandateReadOnlyStmt       *sql.Stmt
+	userTOTPStmt              *sql.Stmt
+	saveUserTOTPStmt          *sql.Stmt
+	enableUserTOTPStmt        *sql.Stmt
+	useTOTPStepStmt           *sql.Stmt
+	deleteUserTOTPStmt        *sql.Stmt
+	createRecoveryCodeStmt    *sql.Stmt
+	useRecoveryCodeStmt       *sql.Stmt
+	deleteRecoveryCodesStmt   *sql.Stmt
+	updateUserLoginStmt       *sql.Stmt
+	userByLoginStmt           *sql.Stmt
+	userSSHKeysStmt           *sql.Stmt
+	createUserSSHKeyStmt      *sql.Stmt
+	deleteUserSSHKeyStmt      *sql.Stmt
+	userSSHKeyExistsStmt      *sql.Stmt
+	mandateForwardTargetsStmt *sql.Stmt
+	serialPortSettingsStmt    *sql.Stmt
+	loginProfileStmt          *sql.Stmt
+	mandateCommandRulesStmt   *sql.Stmt
+	createSessionHistoryStmt  *sql.Stmt
+	redeemSessionHistoryStmt  *sql.Stmt
+	startSessionHistoryStmt   *sql.Stmt
+	endSessionHistoryStmt     *sql.Stmt
+	sessionErrorHistoryStmt   *sql.Stmt
+	sessionHistoryOwnedStmt   *sql.Stmt
+	sessionHistoryStmt        *sql.Stmt
+	sessionHistoryByTokenStmt *sql.Stmt
 }
 
 var openDbOnce sync.Once
@@ -40,6 +70,7 @@ func storageInstance() (*mysqlDriver, error) {
 			openDbOnceError = err
 			return
 		}
+		registerDBStats(instance.db)
 		err = instance.db.Ping()
 		if err != nil {
 			config.Logger.Error(err.Error())
@@ -89,7 +120,7 @@ func prepareStatements() error {
 		return err
 	}
 
-	instance.userStmt, err = instance.db.Prepare("SELECT pk, name " +
+	instance.userStmt, err = instance.db.Prepare("SELECT pk, name, login " +
 		"FROM users " +
 		"WHERE name=?")
 	if err != nil {
@@ -98,7 +129,7 @@ func prepareStatements() error {
 	}
 
 	instance.mandatesStmt, err = instance.db.Prepare("SELECT m.pk, " +
-		"m.name " +
+		"m.name, m.requires_mfa " +
 		"FROM users_mandates as um " +
 		"INNER JOIN mandates as m ON um.mandate_id = m.pk " +
 		"WHERE um.user_id IN (SELECT pk FROM users WHERE name=?)")
@@ -108,7 +139,7 @@ func prepareStatements() error {
 	}
 
 	instance.credentialsStmt, err = instance.db.Prepare("SELECT " +
-		"target_login, target_password, target_private_key " +
+		"target_login, target_password, target_private_key, target_enable_password " +
 		"FROM target_credentials " +
 		"WHERE pk=?")
 	if err != nil {
@@ -153,9 +184,10 @@ func prepareStatements() error {
 	}
 
 	instance.sessionStmt, err = instance.db.Prepare("SELECT " +
-		"s.origin_ip, p.name, s.target_host, s.target_port, s.mandate_id, " +
+		"s.origin_ip, u.name, p.name, s.target_host, s.target_port, s.mandate_id, " +
 		"s.custom_target_network_id, s.custom_target_login, s.custom_target_password, s.custom_target_private_key " +
 		"FROM sessions as s " +
+		"LEFT JOIN users u on s.user_id = u.pk " +
 		"LEFT JOIN protocols p on s.target_proto_id = p.pk " +
 		"WHERE s.token=?")
 	if err != nil {
@@ -171,6 +203,240 @@ func prepareStatements() error {
 		return err
 	}
 
+	instance.mandateRequiresMFAStmt, err = instance.db.Prepare("SELECT requires_mfa " +
+		"FROM mandates " +
+		"WHERE pk=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.mandateReadOnlyStmt, err = instance.db.Prepare("SELECT read_only " +
+		"FROM mandates " +
+		"WHERE pk=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.userTOTPStmt, err = instance.db.Prepare("SELECT secret, enabled " +
+		"FROM user_totp " +
+		"WHERE user_id=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.saveUserTOTPStmt, err = instance.db.Prepare("INSERT INTO user_totp " +
+		"(user_id, secret, enabled, last_used_step) " +
+		"VALUES (?, ?, FALSE, 0) " +
+		"ON DUPLICATE KEY UPDATE secret=VALUES(secret), enabled=FALSE, last_used_step=0")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.enableUserTOTPStmt, err = instance.db.Prepare("UPDATE user_totp " +
+		"SET enabled=TRUE " +
+		"WHERE user_id=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	// Шаг TOTP принимается, только если он больше последнего использованного: так один и тот же код
+	// нельзя предъявить дважды даже при параллельных запросах
+	instance.useTOTPStepStmt, err = instance.db.Prepare("UPDATE user_totp " +
+		"SET last_used_step=? " +
+		"WHERE user_id=? AND enabled=TRUE AND last_used_step<?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.deleteUserTOTPStmt, err = instance.db.Prepare("DELETE " +
+		"FROM user_totp " +
+		"WHERE user_id=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.createRecoveryCodeStmt, err = instance.db.Prepare("INSERT INTO user_recovery_codes " +
+		"(user_id, code_hash) " +
+		"VALUES (?, ?)")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.useRecoveryCodeStmt, err = instance.db.Prepare("UPDATE user_recovery_codes " +
+		"SET used_at=current_timestamp() " +
+		"WHERE user_id=? AND code_hash=? AND used_at IS NULL")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.deleteRecoveryCodesStmt, err = instance.db.Prepare("DELETE " +
+		"FROM user_recovery_codes " +
+		"WHERE user_id=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.updateUserLoginStmt, err = instance.db.Prepare("UPDATE users " +
+		"SET login=?, last_login=current_timestamp() " +
+		"WHERE name=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.userByLoginStmt, err = instance.db.Prepare("SELECT pk, name, login " +
+		"FROM users " +
+		"WHERE login=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.userSSHKeysStmt, err = instance.db.Prepare("SELECT pk, name, fingerprint, public_key " +
+		"FROM user_ssh_keys " +
+		"WHERE user_id=? " +
+		"ORDER BY name")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.createUserSSHKeyStmt, err = instance.db.Prepare("INSERT INTO user_ssh_keys " +
+		"(user_id, name, fingerprint, public_key) " +
+		"VALUES (?, ?, ?, ?)")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.deleteUserSSHKeyStmt, err = instance.db.Prepare("DELETE " +
+		"FROM user_ssh_keys " +
+		"WHERE user_id=? AND pk=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.userSSHKeyExistsStmt, err = instance.db.Prepare("SELECT COUNT(*) " +
+		"FROM user_ssh_keys " +
+		"WHERE user_id=? AND fingerprint=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.mandateForwardTargetsStmt, err = instance.db.Prepare("SELECT target_host, target_port " +
+		"FROM mandate_forward_targets " +
+		"WHERE mandate_id=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.serialPortSettingsStmt, err = instance.db.Prepare("SELECT baud_rate, data_bits, parity, stop_bits " +
+		"FROM serial_port_settings " +
+		"WHERE network_id=? AND target_host=? AND target_port=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.loginProfileStmt, err = instance.db.Prepare("SELECT lp.name, lp.username_prompt, lp.password_prompt, " +
+		"lp.command_prompt, lp.enable_command, lp.enable_prompt, lp.post_login_commands, lp.failure_patterns, lp.timeout_sec " +
+		"FROM login_profiles as lp " +
+		"LEFT JOIN protocols as p ON lp.protocol_id = p.pk " +
+		"WHERE (lp.network_id=? OR lp.network_id IS NULL) AND (p.name=? OR lp.protocol_id IS NULL) " +
+		"ORDER BY lp.network_id IS NULL, lp.protocol_id IS NULL, lp.pk " +
+		"LIMIT 1")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.mandateCommandRulesStmt, err = instance.db.Prepare("SELECT action, pattern " +
+		"FROM mandate_command_rules " +
+		"WHERE mandate_id=? " +
+		"ORDER BY pk")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.createSessionHistoryStmt, err = instance.db.Prepare("INSERT INTO session_history " +
+		"(token, user_id, origin_ip, network_id, target_proto_id, target_host, target_port, mandate_id, auth_method) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.redeemSessionHistoryStmt, err = instance.db.Prepare("UPDATE session_history " +
+		"SET redeemed_at=current_timestamp(), proxy_client_id=? " +
+		"WHERE token=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.startSessionHistoryStmt, err = instance.db.Prepare("UPDATE session_history " +
+		"SET started_at=FROM_UNIXTIME(?) " +
+		"WHERE token=? AND proxy_client_id=? AND started_at IS NULL")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.endSessionHistoryStmt, err = instance.db.Prepare("UPDATE session_history " +
+		"SET ended_at=FROM_UNIXTIME(?), end_reason=?, bytes_in=?, bytes_out=?, duration_ms=?, exit_status=? " +
+		"WHERE token=? AND proxy_client_id=? AND ended_at IS NULL")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.sessionErrorHistoryStmt, err = instance.db.Prepare("UPDATE session_history " +
+		"SET error=? " +
+		"WHERE token=? AND proxy_client_id=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.sessionHistoryOwnedStmt, err = instance.db.Prepare("SELECT COUNT(*) " +
+		"FROM session_history " +
+		"WHERE token=? AND proxy_client_id=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.sessionHistoryStmt, err = instance.db.Prepare(sessionHistorySelect +
+		"WHERE (?='' OR u.name=?) AND (?='' OR n.name=?) AND (?='' OR h.target_host=?) " +
+		"AND h.created_at >= FROM_UNIXTIME(?) AND (?=0 OR h.created_at < FROM_UNIXTIME(?)) " +
+		"ORDER BY h.created_at DESC, h.pk DESC " +
+		"LIMIT ?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
+	instance.sessionHistoryByTokenStmt, err = instance.db.Prepare(sessionHistorySelect +
+		"WHERE h.token=?")
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+
 	return nil
 }
 
@@ -182,6 +448,15 @@ func Open() error {
 	return nil
 }
 
+// Ping проверяет соединение с БД. Время проверки ограничивается контекстом ctx
+func Ping(ctx context.Context) error {
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	return storage.db.PingContext(ctx)
+}
+
 func Close() error {
 	storage, err := storageInstance()
 	if err != nil {
@@ -247,34 +522,180 @@ func Close() error {
 		config.Logger.Error(err.Error())
 		return err
 	}
+	err = storage.mandateRequiresMFAStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.mandateReadOnlyStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.userTOTPStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.saveUserTOTPStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.enableUserTOTPStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.useTOTPStepStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.deleteUserTOTPStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.createRecoveryCodeStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.useRecoveryCodeStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.deleteRecoveryCodesStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.updateUserLoginStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.userByLoginStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.userSSHKeysStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.createUserSSHKeyStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.deleteUserSSHKeyStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.userSSHKeyExistsStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.mandateForwardTargetsStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.serialPortSettingsStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.loginProfileStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.mandateCommandRulesStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.createSessionHistoryStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.redeemSessionHistoryStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.startSessionHistoryStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.endSessionHistoryStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.sessionErrorHistoryStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.sessionHistoryOwnedStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.sessionHistoryStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	err = storage.sessionHistoryByTokenStmt.Close()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
 	err = storage.db.Close()
 	storage.db = nil
 	return err
 }
 
-func User(userName string) (api.User, error) {
+func User(ctx context.Context, userName string) (api.User, error) {
+	ctx, end := startQuery(ctx, "User")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return api.User{}, err
 	}
 
-	row := storage.userStmt.QueryRow(userName)
+	row := storage.userStmt.QueryRowContext(ctx, userName)
 	var user api.User
-	err = row.Scan(&user.ID, &user.Name)
+	var login sql.NullString
+	err = row.Scan(&user.ID, &user.Name, &login)
 	if err != nil {
 		config.Logger.Error(err.Error())
 		return api.User{}, err
 	}
+	user.Login = login.String
 	return user, nil
 }
 
-func Protocols() ([]api.Protocol, error) {
+func Protocols(ctx context.Context) ([]api.Protocol, error) {
+	ctx, end := startQuery(ctx, "Protocols")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return nil, err
 	}
 	var protos []api.Protocol
-	rows, err := storage.protocolsStmt.Query()
+	rows, err := storage.protocolsStmt.QueryContext(ctx)
 	if err != nil {
 		config.Logger.Error(err.Error())
 		return nil, err
@@ -292,13 +713,15 @@ func Protocols() ([]api.Protocol, error) {
 	return protos, nil
 }
 
-func Networks() ([]api.Network, error) {
+func Networks(ctx context.Context) ([]api.Network, error) {
+	ctx, end := startQuery(ctx, "Networks")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return nil, err
 	}
 	var networks []api.Network
-	rows, err := storage.networksStmt.Query()
+	rows, err := storage.networksStmt.QueryContext(ctx)
 	if err != nil {
 		config.Logger.Error(err.Error())
 		return nil, err
@@ -316,9 +739,11 @@ func Networks() ([]api.Network, error) {
 	return networks, nil
 }
 
-func NetworkByID(id int) (api.Network, error) {
+func NetworkByID(ctx context.Context, id int) (api.Network, error) {
+	ctx, end := startQuery(ctx, "NetworkByID")
+	defer end()
 	result := api.Network{}
-	networks, err := Networks()
+	networks, err := Networks(ctx)
 	if err != nil {
 		return result, err
 	}
@@ -331,13 +756,15 @@ func NetworkByID(id int) (api.Network, error) {
 	return result, nil
 }
 
-func NetworkByMandateID(mandateID int) (api.Network, error) {
+func NetworkByMandateID(ctx context.Context, mandateID int) (api.Network, error) {
+	ctx, end := startQuery(ctx, "NetworkByMandateID")
+	defer end()
 	network := api.Network{}
 	storage, err := storageInstance()
 	if err != nil {
 		return network, err
 	}
-	row := storage.networkByMandateIDStmt.QueryRow(mandateID)
+	row := storage.networkByMandateIDStmt.QueryRowContext(ctx, mandateID)
 	err = row.Scan(&network.ID, &network.Name, &network.Endpoint, &network.Servicepoint)
 	if err != nil {
 		config.Logger.Error(err.Error())
@@ -346,13 +773,15 @@ func NetworkByMandateID(mandateID int) (api.Network, error) {
 	return network, nil
 }
 
-func Mandates(userName string) ([]api.Mandate, error) {
+func Mandates(ctx context.Context, userName string) ([]api.Mandate, error) {
+	ctx, end := startQuery(ctx, "Mandates")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return nil, err
 	}
 	var mandates []api.Mandate
-	rows, err := storage.mandatesStmt.Query(userName)
+	rows, err := storage.mandatesStmt.QueryContext(ctx, userName)
 	if err != nil {
 		config.Logger.Error(err.Error())
 		return nil, err
@@ -360,7 +789,7 @@ func Mandates(userName string) ([]api.Mandate, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var mandate api.Mandate
-		err := rows.Scan(&mandate.ID, &mandate.Name)
+		err := rows.Scan(&mandate.ID, &mandate.Name, &mandate.RequiresMFA)
 		if err != nil {
 			config.Logger.Error(err.Error())
 			return nil, err
@@ -370,7 +799,9 @@ func Mandates(userName string) ([]api.Mandate, error) {
 	return mandates, nil
 }
 
-func CreateSessionTemplate(userName string, st api.SessionTemplate) error {
+func CreateSessionTemplate(ctx context.Context, userName string, st api.SessionTemplate) error {
+	ctx, end := startQuery(ctx, "CreateSessionTemplate")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return err
@@ -378,7 +809,7 @@ func CreateSessionTemplate(userName string, st api.SessionTemplate) error {
 	if err != nil {
 		return err
 	}
-	user, err := User(userName)
+	user, err := User(ctx, userName)
 	if err != nil {
 		return err
 	}
@@ -400,7 +831,7 @@ func CreateSessionTemplate(userName string, st api.SessionTemplate) error {
 		config.Logger.Error(err.Error())
 		return err
 	}
-	result, err := storage.createSessionTemplateStmt.Exec(
+	result, err := storage.createSessionTemplateStmt.ExecContext(ctx,
 		st.Name,
 		user.ID,
 		st.TargetProtocolID,
@@ -428,13 +859,15 @@ func CreateSessionTemplate(userName string, st api.SessionTemplate) error {
 	return nil
 }
 
-func SessionTemlates(userName string) ([]api.SessionTemplate, error) {
+func SessionTemlates(ctx context.Context, userName string) ([]api.SessionTemplate, error) {
+	ctx, end := startQuery(ctx, "SessionTemlates")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return nil, err
 	}
 	var sessionTemplates []api.SessionTemplate
-	rows, err := storage.sessionTemplatesStmt.Query(userName)
+	rows, err := storage.sessionTemplatesStmt.QueryContext(ctx, userName)
 	if err != nil {
 		config.Logger.Error(err.Error())
 		return nil, err
@@ -476,12 +909,14 @@ func SessionTemlates(userName string) ([]api.SessionTemplate, error) {
 	return sessionTemplates, nil
 }
 
-func DeleteSessionTemplate(userName string, id int) error {
+func DeleteSessionTemplate(ctx context.Context, userName string, id int) error {
+	ctx, end := startQuery(ctx, "DeleteSessionTemplate")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return err
 	}
-	result, err := storage.deleteSessionTemplateStmt.Exec(userName, id)
+	result, err := storage.deleteSessionTemplateStmt.ExecContext(ctx, userName, id)
 	if err != nil {
 		config.Logger.Error(err.Error())
 		return err
@@ -499,12 +934,14 @@ func DeleteSessionTemplate(userName string, id int) error {
 	return nil
 }
 
-func CreateSession(sessionToken string, sess api.CreateSessionDTO) error {
+func CreateSession(ctx context.Context, sessionToken string, sess api.CreateSessionDTO) error {
+	ctx, end := startQuery(ctx, "CreateSession")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return err
 	}
-	user, err := User(sess.UserName)
+	user, err := User(ctx, sess.UserName)
 	if err != nil {
 		return err
 	}
@@ -526,7 +963,7 @@ func CreateSession(sessionToken string, sess api.CreateSessionDTO) error {
 		config.Logger.Error(err.Error())
 		return err
 	}
-	result, err := storage.createSessionStmt.Exec(
+	result, err := storage.createSessionStmt.ExecContext(ctx,
 		sessionToken,
 		sess.OriginIP,
 		user.ID,
@@ -555,7 +992,9 @@ func CreateSession(sessionToken string, sess api.CreateSessionDTO) error {
 	return nil
 }
 
-func Session(sessionToken string) (api.ReadSessionDTO, error) {
+func Session(ctx context.Context, sessionToken string) (api.ReadSessionDTO, error) {
+	ctx, end := startQuery(ctx, "Session")
+	defer end()
 	result := api.ReadSessionDTO{}
 	storage, err := storageInstance()
 	if err != nil {
@@ -563,14 +1002,18 @@ func Session(sessionToken string) (api.ReadSessionDTO, error) {
 	}
 
 	var session api.ReadSessionDTO
+	var userName sql.NullString
 	var mandateID sql.NullInt64
+	var networkID int
 	var targetNetwork sql.NullInt64
 	var targetLogin sql.NullString
 	var targetPassword sql.NullString
 	var targetPrivKey sql.NullString
-	row := storage.sessionStmt.QueryRow(sessionToken)
+	var targetEnablePassword sql.NullString
+	row := storage.sessionStmt.QueryRowContext(ctx, sessionToken)
 	err = row.Scan(
 		&session.OriginIP,
+		&userName,
 		&session.TargetProtocol,
 		&session.TargetHost,
 		&session.TargetPort,
@@ -584,18 +1027,29 @@ func Session(sessionToken string) (api.ReadSessionDTO, error) {
 		return result, err
 	}
 
+	session.UserName = userName.String
 	if mandateID.Valid {
-		network, err := NetworkByMandateID(int(mandateID.Int64))
+		session.MandateID = int(mandateID.Int64)
+		network, err := NetworkByMandateID(ctx, int(mandateID.Int64))
 		if err != nil {
 			return result, err
 		}
 		session.TargetNetwork = network.Name
+		networkID = network.ID
 
-		row = storage.credentialsStmt.QueryRow(mandateID)
+		row = storage.mandateReadOnlyStmt.QueryRowContext(ctx, mandateID)
+		err = row.Scan(&session.ReadOnly)
+		if err != nil {
+			config.Logger.Error(err.Error())
+			return api.ReadSessionDTO{}, err
+		}
+
+		row = storage.credentialsStmt.QueryRowContext(ctx, mandateID)
 		err = row.Scan(
 			&session.TargetLogin,
 			&targetPassword,
-			&targetPrivKey)
+			&targetPrivKey,
+			&targetEnablePassword)
 		if err != nil {
 			config.Logger.Error(err.Error())
 			return api.ReadSessionDTO{}, err
@@ -607,22 +1061,40 @@ func Session(sessionToken string) (api.ReadSessionDTO, error) {
 			session.TargetPassword = targetPassword.String
 			session.TargetPrivKey = ""
 		}
+		session.TargetEnablePassword = targetEnablePassword.String
+		session.CommandRules, err = MandateCommandRules(ctx, int(mandateID.Int64))
+		if err != nil {
+			return api.ReadSessionDTO{}, err
+		}
 	} else {
-		network, _ := NetworkByID(int(targetNetwork.Int64))
+		network, _ := NetworkByID(ctx, int(targetNetwork.Int64))
 		session.TargetNetwork = network.Name
+		networkID = network.ID
 		session.TargetLogin = targetLogin.String
 		session.TargetPassword = targetPassword.String
 		session.TargetPrivKey = targetPrivKey.String
 	}
+	session.LoginProfile, err = LoginProfile(ctx, networkID, session.TargetProtocol)
+	if err != nil {
+		return api.ReadSessionDTO{}, err
+	}
+	if strings.EqualFold(session.TargetProtocol, "RFC2217") {
+		session.Serial, err = SerialPortSettings(ctx, networkID, session.TargetHost, session.TargetPort)
+		if err != nil {
+			return api.ReadSessionDTO{}, err
+		}
+	}
 	return session, nil
 }
 
-func DeleteSession(token string) error {
+func DeleteSession(ctx context.Context, token string) error {
+	ctx, end := startQuery(ctx, "DeleteSession")
+	defer end()
 	storage, err := storageInstance()
 	if err != nil {
 		return err
 	}
-	_, err = storage.deleteSessionStmt.Exec(token)
+	_, err = storage.deleteSessionStmt.ExecContext(ctx, token)
 	if err != nil {
 		config.Logger.Error(err.Error())
 		return err
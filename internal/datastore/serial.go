@@ -0,0 +1,30 @@
+package datastore
+
+import (
+	"bastion/internal/api"
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SerialPortSettings возвращает параметры последовательного порта цели host:port в сети networkID.
+// Если параметры не заданы, возвращается nil без ошибки
+func SerialPortSettings(ctx context.Context, networkID int, host, port string) (*api.SerialSettings, error) {
+	ctx, end := startQuery(ctx, "SerialPortSettings")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return nil, err
+	}
+	var settings api.SerialSettings
+	row := storage.serialPortSettingsStmt.QueryRowContext(ctx, networkID, host, port)
+	err = row.Scan(&settings.BaudRate, &settings.DataBits, &settings.Parity, &settings.StopBits)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return nil, err
+	}
+	return &settings, nil
+}
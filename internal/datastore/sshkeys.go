@@ -0,0 +1,156 @@
+package datastore
+
+import (
+	"bastion/internal/api"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// UpdateUserLogin сохраняет короткое имя пользователя, под которым он может подключаться к прокси напрямую
+func UpdateUserLogin(ctx context.Context, userName, login string) error {
+	ctx, end := startQuery(ctx, "UpdateUserLogin")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	_, err = storage.updateUserLoginStmt.ExecContext(ctx, login, userName)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+func UserByLogin(ctx context.Context, login string) (api.User, error) {
+	ctx, end := startQuery(ctx, "UserByLogin")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return api.User{}, err
+	}
+	var user api.User
+	row := storage.userByLoginStmt.QueryRowContext(ctx, login)
+	err = row.Scan(&user.ID, &user.Name, &user.Login)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return api.User{}, err
+	}
+	return user, nil
+}
+
+func UserSSHKeys(ctx context.Context, userName string) ([]api.SSHKey, error) {
+	ctx, end := startQuery(ctx, "UserSSHKeys")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return nil, err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	keys := []api.SSHKey{}
+	rows, err := storage.userSSHKeysStmt.QueryContext(ctx, user.ID)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key api.SSHKey
+		err := rows.Scan(&key.ID, &key.Name, &key.Fingerprint, &key.PublicKey)
+		if err != nil {
+			config.Logger.Error(err.Error())
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func CreateUserSSHKey(ctx context.Context, userName string, key api.SSHKey) error {
+	ctx, end := startQuery(ctx, "CreateUserSSHKey")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return err
+	}
+	result, err := storage.createUserSSHKeyStmt.ExecContext(ctx, user.ID, key.Name, key.Fingerprint, key.PublicKey)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	ra, err := result.RowsAffected()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	if ra != 1 {
+		err := fmt.Errorf("unexpected number of rows (%d) was affected, expect one", ra)
+		config.Logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+func DeleteUserSSHKey(ctx context.Context, userName string, id int) error {
+	ctx, end := startQuery(ctx, "DeleteUserSSHKey")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return err
+	}
+	user, err := User(ctx, userName)
+	if err != nil {
+		return err
+	}
+	result, err := storage.deleteUserSSHKeyStmt.ExecContext(ctx, user.ID, id)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return err
+	}
+	if n != int64(1) {
+		err := errors.New("wrong number of affected rows")
+		config.Logger.Warn(err.Error())
+		return err
+	}
+	return nil
+}
+
+// UserSSHKeyExists проверяет, зарегистрирован ли у пользователя с коротким именем login ключ с отпечатком fingerprint.
+// Возвращает данные пользователя, если ключ найден
+func UserSSHKeyExists(ctx context.Context, login, fingerprint string) (api.User, bool, error) {
+	ctx, end := startQuery(ctx, "UserSSHKeyExists")
+	defer end()
+	storage, err := storageInstance()
+	if err != nil {
+		return api.User{}, false, err
+	}
+	user, err := UserByLogin(ctx, login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.User{}, false, nil
+	}
+	if err != nil {
+		return api.User{}, false, err
+	}
+	var n int
+	row := storage.userSSHKeyExistsStmt.QueryRowContext(ctx, user.ID, fingerprint)
+	err = row.Scan(&n)
+	if err != nil {
+		config.Logger.Error(err.Error())
+		return api.User{}, false, err
+	}
+	return user, n > 0, nil
+}
@@ -0,0 +1,92 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AuditSchemaVersion - версия схемы объекта "audit" в записях журнала. Увеличивается при несовместимом изменении
+// состава или смысла полей (см. configs/elasticsearch-index-mappings)
+const AuditSchemaVersion = 1
+
+// Типы событий аудита жизненного цикла сессии
+const (
+	AuditTokenIssued         = "token_issued"          // Сервер выдал токен сессии
+	AuditTokenRedeemed       = "token_redeemed"        // Прокси получил данные сессии по токену
+	AuditAuthFailed          = "auth_failed"           // Отказ в аутентификации или авторизации пользователя
+	AuditTargetConnected     = "target_connected"      // Прокси установил соединение с целью
+	AuditTargetConnectFailed = "target_connect_failed" // Соединение с целью или вход на неё не удались
+	AuditWindowResized       = "window_resized"        // Клиент изменил размер окна терминала
+	AuditSessionEnded        = "session_ended"         // Сессия завершена
+	AuditCommandBlocked      = "command_blocked"       // Команда не выполнена по правилам мандата
+	AuditCommandConfirmed    = "command_confirmed"     // Пользователь подтвердил выполнение команды
+)
+
+// AuditEvent - событие аудита. Записывается в журнал объектом "audit", пустые поля не записываются
+type AuditEvent struct {
+	Event          string
+	User           string
+	AuthMethod     string
+	ClientIP       string
+	MandateID      int
+	TargetNetwork  string
+	TargetProtocol string
+	TargetHost     string
+	TargetPort     string
+	Width          int
+	Height         int
+	BytesIn        int64 // Байты от клиента к цели
+	BytesOut       int64 // Байты от цели к клиенту
+	Duration       time.Duration
+	ExitStatus     *int // Код завершения оболочки на цели, если известен
+	Command        string
+	Rule           string // Шаблон правила мандата, применённого к команде
+	Reason         string
+	Error          string
+}
+
+func (e AuditEvent) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("schema_version", AuditSchemaVersion)
+	enc.AddString("event", e.Event)
+	fields := []struct{ key, value string }{
+		{"user", e.User},
+		{"auth_method", e.AuthMethod},
+		{"client_ip", e.ClientIP},
+		{"target_network", e.TargetNetwork},
+		{"target_protocol", e.TargetProtocol},
+		{"target_host", e.TargetHost},
+		{"target_port", e.TargetPort},
+		{"command", e.Command},
+		{"rule", e.Rule},
+		{"reason", e.Reason},
+		{"error", e.Error},
+	}
+	for _, s := range fields {
+		if s.value != "" {
+			enc.AddString(s.key, s.value)
+		}
+	}
+	if e.MandateID != 0 {
+		enc.AddInt("mandate_id", e.MandateID)
+	}
+	if e.Width != 0 || e.Height != 0 {
+		enc.AddInt("width", e.Width)
+		enc.AddInt("height", e.Height)
+	}
+	if e.Event == AuditSessionEnded {
+		enc.AddInt64("bytes_in", e.BytesIn)
+		enc.AddInt64("bytes_out", e.BytesOut)
+		enc.AddInt64("duration_ms", e.Duration.Milliseconds())
+	}
+	if e.ExitStatus != nil {
+		enc.AddInt("exit_status", *e.ExitStatus)
+	}
+	return nil
+}
+
+// Audit записывает событие аудита
+func Audit(logger *zap.Logger, e AuditEvent) {
+	logger.Info("Audit: "+e.Event, zap.Object("audit", e))
+}
@@ -0,0 +1,395 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Журнал аудита с цепочкой хешей: каждая строка файла - JSON-объект с номером записи, хешем предыдущей строки,
+// самой записью и хешем строки. Изменение, удаление или вставка строки нарушает цепочку, что выявляет
+// команда bastion-audit verify. Периодически (и при закрытии журнала) в цепочку добавляется контрольная точка,
+// подписанная ключом Ed25519: она подтверждает, что цепочка до неё сформирована владельцем ключа
+// и не была целиком пересчитана после изменения записей
+
+const (
+	chainMaxTail = 64 * 1024 // Ограничение длины последней строки при продолжении существующего журнала
+)
+
+// ChainGenesis - значение prev первой записи журнала
+var ChainGenesis = hex.EncodeToString(make([]byte, sha256.Size))
+
+// ChainLine - строка журнала аудита. Строка содержит либо запись (Record), либо подпись контрольной точки (Signature)
+type ChainLine struct {
+	Seq       uint64          `json:"seq"`
+	Prev      string          `json:"prev"`
+	Record    json.RawMessage `json:"record,omitempty"`
+	Signature string          `json:"signature,omitempty"` // Подпись Ed25519 сообщения CheckpointMessage(Seq, Prev)
+	Hash      string          `json:"hash"`
+}
+
+// ComputeHash вычисляет хеш строки журнала по всем её полям, кроме самого хеша
+func (l ChainLine) ComputeHash() string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%d\n%s\n", l.Seq, l.Prev)
+	_, _ = h.Write(l.Record)
+	_, _ = fmt.Fprintf(h, "\n%s", l.Signature)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CheckpointMessage - подписываемое контрольной точкой сообщение: номер контрольной точки и хеш предшествующей
+// ей строки (вершина цепочки)
+func CheckpointMessage(seq uint64, prev string) []byte {
+	return []byte(strconv.FormatUint(seq, 10) + "\n" + prev)
+}
+
+// ChainWriter дописывает записи в журнал аудита с цепочкой хешей. Журнал должен вестись одним процессом
+type ChainWriter struct {
+	mu                 sync.Mutex
+	file               *os.File
+	key                ed25519.PrivateKey
+	checkpointInterval int
+	seq                uint64
+	prev               string
+	sinceCheckpoint    int
+}
+
+// OpenChainWriter открывает журнал для дописывания, продолжая цепочку существующих записей.
+// Если key не nil, каждые checkpointInterval записей и при закрытии добавляется подписанная контрольная точка
+func OpenChainWriter(path string, key ed25519.PrivateKey, checkpointInterval int) (*ChainWriter, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &ChainWriter{file: file, key: key, checkpointInterval: checkpointInterval, prev: ChainGenesis}
+	last, err := lastLine(file)
+	if err == nil && last != nil {
+		var l ChainLine
+		if err = json.Unmarshal(last, &l); err == nil && l.Hash != l.ComputeHash() {
+			err = errors.New("hash mismatch")
+		}
+		if err != nil {
+			err = fmt.Errorf("audit log %s ends with a malformed record: %w", path, err)
+		}
+		w.seq, w.prev = l.Seq, l.Hash
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// lastLine возвращает последнюю строку файла без перевода строки или nil, если файл пуст
+func lastLine(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+	offset := size - chainMaxTail
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, size-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil {
+		return nil, err
+	}
+	if tail[len(tail)-1] != '\n' {
+		return nil, errors.New("audit log does not end with a line break")
+	}
+	tail = tail[:len(tail)-1]
+	i := bytes.LastIndexByte(tail, '\n')
+	if i < 0 && offset > 0 {
+		return nil, errors.New("last audit log record is too long")
+	}
+	return tail[i+1:], nil
+}
+
+// Append добавляет в журнал запись - JSON-объект
+func (w *ChainWriter) Append(record []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.writeLine(ChainLine{Record: record}); err != nil {
+		return err
+	}
+	w.sinceCheckpoint++
+	if w.key != nil && w.checkpointInterval > 0 && w.sinceCheckpoint >= w.checkpointInterval {
+		return w.checkpoint()
+	}
+	return nil
+}
+
+// Close добавляет контрольную точку (если с последней были записи) и закрывает журнал
+func (w *ChainWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	if w.key != nil && w.sinceCheckpoint > 0 {
+		err = w.checkpoint()
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *ChainWriter) checkpoint() error {
+	seq := w.seq + 1
+	signature := ed25519.Sign(w.key, CheckpointMessage(seq, w.prev))
+	if err := w.writeLine(ChainLine{Signature: base64.StdEncoding.EncodeToString(signature)}); err != nil {
+		return err
+	}
+	w.sinceCheckpoint = 0
+	return w.file.Sync()
+}
+
+func (w *ChainWriter) writeLine(l ChainLine) error {
+	l.Seq = w.seq + 1
+	l.Prev = w.prev
+	if l.Record != nil {
+		// Хеш вычисляется от записи в том виде, в каком она попадёт в файл
+		var record bytes.Buffer
+		if err := json.Compact(&record, l.Record); err != nil {
+			return err
+		}
+		l.Record = record.Bytes()
+	}
+	l.Hash = l.ComputeHash()
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(l); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(data.Bytes()); err != nil {
+		return err
+	}
+	w.seq, w.prev = l.Seq, l.Hash
+	return nil
+}
+
+// ChainVerification - результат проверки журнала аудита
+type ChainVerification struct {
+	FirstSeq       uint64
+	LastSeq        uint64
+	LastHash       string
+	Records        int
+	Checkpoints    int
+	LastCheckpoint uint64 // Номер последней контрольной точки, записи после неё не подтверждены подписью
+}
+
+// VerifyChain проверяет цепочку хешей журнала аудита и, если publicKey не nil, подписи контрольных точек.
+// Журнал может быть фрагментом (выгрузкой диапазона записей): тогда prev первой строки не проверяется,
+// но для полного журнала (начинающегося с записи 1) она должна ссылаться на ChainGenesis.
+// При нарушении возвращается ошибка с номером строки
+func VerifyChain(r io.Reader, publicKey ed25519.PublicKey) (ChainVerification, error) {
+	var v ChainVerification
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), chainMaxTail)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var l ChainLine
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return v, fmt.Errorf("line %d: malformed record: %w", lineNo, err)
+		}
+		switch {
+		case v.LastSeq == 0 && l.Seq == 1 && l.Prev != ChainGenesis:
+			return v, fmt.Errorf("line %d: first record does not start the chain", lineNo)
+		case v.LastSeq != 0 && l.Seq != v.LastSeq+1:
+			return v, fmt.Errorf("line %d: record %d follows record %d", lineNo, l.Seq, v.LastSeq)
+		case v.LastSeq != 0 && l.Prev != v.LastHash:
+			return v, fmt.Errorf("line %d: record %d is not linked to the previous record", lineNo, l.Seq)
+		case l.Hash != l.ComputeHash():
+			return v, fmt.Errorf("line %d: record %d hash mismatch", lineNo, l.Seq)
+		}
+		if l.Signature != "" {
+			if publicKey != nil {
+				signature, err := base64.StdEncoding.DecodeString(l.Signature)
+				if err != nil || !ed25519.Verify(publicKey, CheckpointMessage(l.Seq, l.Prev), signature) {
+					return v, fmt.Errorf("line %d: checkpoint %d signature is invalid", lineNo, l.Seq)
+				}
+			}
+			v.Checkpoints++
+			v.LastCheckpoint = l.Seq
+		} else {
+			v.Records++
+		}
+		if v.FirstSeq == 0 {
+			v.FirstSeq = l.Seq
+		}
+		v.LastSeq, v.LastHash = l.Seq, l.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return v, fmt.Errorf("line %d: %w", lineNo+1, err)
+	}
+	return v, nil
+}
+
+// LoadSigningKey читает закрытый ключ Ed25519 в формате PEM (PKCS #8), например созданный командой
+// openssl genpkey -algorithm ed25519
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+	return edKey, nil
+}
+
+// LoadVerificationKey читает открытый ключ Ed25519 в формате PEM (PKIX), например созданный командой
+// openssl pkey -pubout
+func LoadVerificationKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key", path)
+	}
+	return edKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM data", path)
+	}
+	return block, nil
+}
+
+// AuditChainConfig задаёт журнал аудита с цепочкой хешей. Пустое имя файла выключает журнал
+type AuditChainConfig struct {
+	File               string
+	SigningKeyFile     string // Ключ подписи контрольных точек; без ключа контрольные точки не добавляются
+	CheckpointInterval int    // Число записей между контрольными точками
+}
+
+var auditChain *ChainWriter
+
+// InitAuditChain дополнительно направляет события аудита (записи с объектом "audit") корневого журнала в журнал
+// с цепочкой хешей и возвращает новый корневой журнал. Вызывается после Init
+func InitAuditChain(c AuditChainConfig) (*zap.Logger, error) {
+	if c.File == "" {
+		return Get(), nil
+	}
+	var key ed25519.PrivateKey
+	var err error
+	if c.SigningKeyFile != "" {
+		key, err = LoadSigningKey(c.SigningKeyFile)
+		if err != nil {
+			return Get(), fmt.Errorf("error loading audit signing key: %w", err)
+		}
+	}
+	auditChain, err = OpenChainWriter(c.File, key, c.CheckpointInterval)
+	if err != nil {
+		return Get(), err
+	}
+	chainCore := &auditChainCore{
+		writer:  auditChain,
+		encoder: zapcore.NewJSONEncoder(jsonEncoderConfig()),
+	}
+	rootLogger = Get().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, chainCore)
+	}))
+	rootLogger.Info("Audit log enabled", zap.String("file", c.File), zap.Bool("signed", key != nil))
+	return rootLogger, nil
+}
+
+// CloseAuditChain добавляет завершающую контрольную точку и закрывает журнал аудита с цепочкой хешей
+func CloseAuditChain() error {
+	if auditChain == nil {
+		return nil
+	}
+	return auditChain.Close()
+}
+
+// auditChainCore пропускает в журнал с цепочкой хешей только записи, содержащие поле "audit"
+type auditChainCore struct {
+	writer  *ChainWriter
+	encoder zapcore.Encoder
+	fields  []zapcore.Field
+	audit   bool // Поле "audit" добавлено через With
+}
+
+// Enabled пропускает уровень, на котором Audit записывает события
+func (c *auditChainCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.InfoLevel
+}
+
+func (c *auditChainCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field(nil), c.fields...), fields...)
+	clone.audit = c.audit || hasAuditField(fields)
+	return &clone
+}
+
+func (c *auditChainCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *auditChainCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if !c.audit && !hasAuditField(fields) {
+		return nil
+	}
+	buf, err := c.encoder.EncodeEntry(entry, append(append([]zapcore.Field(nil), c.fields...), fields...))
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	return c.writer.Append(bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+func (c *auditChainCore) Sync() error {
+	return nil
+}
+
+func hasAuditField(fields []zapcore.Field) bool {
+	for _, f := range fields {
+		if f.Key == "audit" {
+			return true
+		}
+	}
+	return false
+}
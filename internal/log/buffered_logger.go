@@ -1,58 +1,245 @@
 package log
 
 import (
-	"bytes"
 	"go.uber.org/zap"
-	"regexp"
 )
 
-const bufferSize = 1024
+const (
+	bufferSize    = 1024
+	maxLineLength = 4096 // Более длинные строки записываются в журнал частями
+	maxSeqLength  = 4096 // Более длинные управляющие строки (OSC, DCS) считаются незавершёнными
+)
+
+// Состояния разбора управляющих последовательностей ECMA-48
+const (
+	stateGround       = iota
+	stateEscape       // Получен ESC
+	stateEscapeInter  // ESC и промежуточный символ (' ', '#', '%', '(' ...), ожидается ещё один символ
+	stateSkipRune     // Пропуск байтов продолжения UTF-8 символа, завершающего последовательность
+	stateCSI          // ESC [ или CSI, параметры до финального символа
+	stateString       // OSC, DCS, PM, APC до терминатора
+	stateStringEscape // ESC внутри управляющей строки, возможно начало ST (ESC \)
+	stateStringC1     // Байт 0xC2 внутри управляющей строки, возможно начало ST (C1, U+009C)
+	stateC1           // Байт 0xC2 вне последовательности, возможно начало управляющего символа C1
+)
 
+// BufferedLogger записывает поток сессии в журнал построчно, удаляя управляющие последовательности терминала.
+// Разбор выполняется конечным автоматом, поэтому последовательности, разделённые между вызовами Write,
+// удаляются корректно. Управляющие символы C1 распознаются в кодировке UTF-8 (U+0080 - U+009F).
+// Незавершённая последовательность (например, ESC [ с недопустимым символом или управляющая строка, прерванная
+// переводом строки) удаляется только вместе с начальными символами, её содержимое разбирается как обычные данные.
+//
+// Пустые строки не записываются. Если в переданном Write блоке есть перевод строки, остаток блока после него
+// тоже записывается отдельной строкой: так приглашение командной строки попадает в журнал сразу
 type BufferedLogger struct {
 	baseLogger *zap.Logger
 	streamID   string
-	buffer     *bytes.Buffer
+	line       []byte
+	seq        []byte // Содержимое текущей последовательности, разбирается заново, если она оказалась незавершённой
+	state      int
+	stringBEL  bool // Текущая управляющая строка (OSC) может завершаться символом BEL
+	skipBytes  int
 }
 
 func NewBufferedLogger(baseLogger *zap.Logger, streamID string) BufferedLogger {
-	bl := BufferedLogger{
+	return BufferedLogger{
 		baseLogger: baseLogger,
 		streamID:   streamID,
-		buffer:     bytes.NewBuffer(make([]byte, bufferSize)),
+		line:       make([]byte, 0, bufferSize),
 	}
-	bl.buffer.Reset()
-	return bl
 }
 
 func (bl *BufferedLogger) Write(p []byte) (n int, err error) {
-	controlSequences := regexp.MustCompile(`\x1b[ #%()*+\-.\/].|(?:\x1b\[|\x9b)[ -?]*[@-~]|(?:\x1b\]|\x9d).*?(?:\x1b\\|[\a\x9c])|(?:\x1b[P^_]|[\x90\x9e\x9f]).*?(?:\x1b\\|\x9c)|\x1b.|[\x80-\x9f]`)
-	filteredData := controlSequences.ReplaceAll(p, []byte(""))
-	newLine := regexp.MustCompile("(\r\n|\r|\n)")
-	lines := newLine.Split(string(filteredData), -1)
-	for _, line := range lines {
-		n, err := bl.buffer.Write([]byte(line))
-		if err != nil {
-			return n, err
-		}
-		l := bl.buffer.Len()
-		if l > 0 {
-			lb := bl.buffer.Bytes()[l-1]
-			if lb == '\r' {
-				bl.buffer.Truncate(l - 1)
+	if bl.feed(p) {
+		bl.flush()
+	}
+	return len(p), nil
+}
+
+// feed разбирает данные и возвращает true, если в них был перевод строки
+func (bl *BufferedLogger) feed(p []byte) bool {
+	lineBreak := false
+	for i := 0; i < len(p); i++ {
+		b := p[i]
+		switch bl.state {
+		case stateGround:
+			start := i
+			for i < len(p) && !isSpecial(p[i]) {
+				i++
+			}
+			bl.appendLine(p[start:i])
+			if i == len(p) {
+				continue
+			}
+			b = p[i]
+			switch b {
+			case '\r', '\n':
+				lineBreak = true
+				bl.flush()
+			case 0x1b:
+				bl.state = stateEscape
+			case 0xc2:
+				bl.state = stateC1
+			}
+		case stateEscape:
+			bl.state = stateGround
+			switch {
+			case b == '[':
+				bl.state = stateCSI
+				bl.seq = bl.seq[:0]
+			case b == ']':
+				bl.startString(true)
+			case b == 'P' || b == '^' || b == '_':
+				bl.startString(false)
+			case b == ' ' || b == '#' || b == '%' || (b >= '(' && b <= '+') || (b >= '-' && b <= '/'):
+				bl.state = stateEscapeInter
+			case b == '\n': // ESC перед переводом строки остаётся в строке
+				bl.appendLine([]byte{0x1b})
+				i--
+			default:
+				bl.skipRune(b)
+			}
+		case stateEscapeInter:
+			bl.state = stateGround
+			if b == '\n' {
+				i--
+			} else {
+				bl.skipRune(b)
+			}
+		case stateSkipRune:
+			if b&0xc0 != 0x80 {
+				bl.state = stateGround
+				i--
+				continue
+			}
+			bl.skipBytes--
+			if bl.skipBytes == 0 {
+				bl.state = stateGround
+			}
+		case stateCSI:
+			switch {
+			case b >= 0x20 && b <= 0x3f:
+				bl.seq = append(bl.seq, b)
+				if len(bl.seq) > maxSeqLength {
+					lineBreak = bl.abortSequence() || lineBreak
+				}
+			case b >= 0x40 && b <= 0x7e:
+				bl.state = stateGround
+			default:
+				lineBreak = bl.abortSequence() || lineBreak
+				i--
+			}
+		case stateString:
+			switch {
+			case b == '\n':
+				lineBreak = bl.abortSequence() || lineBreak
+				i--
+			case b == 0x07 && bl.stringBEL:
+				bl.state = stateGround
+			case b == 0x1b:
+				bl.state = stateStringEscape
+			case b == 0xc2:
+				bl.state = stateStringC1
+			default:
+				bl.seq = append(bl.seq, b)
+				if len(bl.seq) > maxSeqLength {
+					lineBreak = bl.abortSequence() || lineBreak
+				}
+			}
+		case stateStringEscape:
+			if b == '\\' {
+				bl.state = stateGround
+				continue
+			}
+			bl.seq = append(bl.seq, 0x1b)
+			bl.state = stateString
+			i--
+		case stateStringC1:
+			if b == 0x9c {
+				bl.state = stateGround
+				continue
+			}
+			bl.seq = append(bl.seq, 0xc2)
+			bl.state = stateString
+			i--
+		case stateC1:
+			bl.state = stateGround
+			switch {
+			case b == 0x9b:
+				bl.state = stateCSI
+				bl.seq = bl.seq[:0]
+			case b == 0x9d:
+				bl.startString(true)
+			case b == 0x90 || b == 0x9e || b == 0x9f:
+				bl.startString(false)
+			case b >= 0x80 && b <= 0x9f:
+				// Прочие управляющие символы C1 удаляются
+			default:
+				bl.appendLine([]byte{0xc2})
+				i--
 			}
 		}
-		if len(lines) == 1 { // переданный массив не содержит \n, просто добавляем его к буферу
-			return n, nil
-		}
-		if bl.buffer.Len() > 0 {
-			bl.baseLogger.Info(bl.buffer.String(), zap.String("stream_id", bl.streamID))
-		}
-		bl.buffer.Reset()
 	}
-	return 0, nil
+	return lineBreak
 }
 
 func (bl *BufferedLogger) Close() error {
-	bl.baseLogger.Info(bl.buffer.String(), zap.String("stream_id", bl.streamID))
+	bl.baseLogger.Info(string(bl.line), zap.String("stream_id", bl.streamID))
+	bl.line = bl.line[:0]
 	return bl.baseLogger.Sync()
 }
+
+// isSpecial возвращает true для байтов, требующих обработки автоматом: переводов строки, ESC
+// и первого байта UTF-8 кодировки управляющих символов C1
+func isSpecial(b byte) bool {
+	return b == '\r' || b == '\n' || b == 0x1b || b == 0xc2
+}
+
+func (bl *BufferedLogger) appendLine(data []byte) {
+	for len(data) > 0 {
+		free := maxLineLength - len(bl.line)
+		if len(data) < free {
+			bl.line = append(bl.line, data...)
+			return
+		}
+		bl.line = append(bl.line, data[:free]...)
+		data = data[free:]
+		bl.flush()
+	}
+}
+
+func (bl *BufferedLogger) flush() {
+	if len(bl.line) > 0 {
+		bl.baseLogger.Info(string(bl.line), zap.String("stream_id", bl.streamID))
+		bl.line = bl.line[:0]
+	}
+}
+
+func (bl *BufferedLogger) startString(bel bool) {
+	bl.state = stateString
+	bl.stringBEL = bel
+	bl.seq = bl.seq[:0]
+}
+
+// skipRune пропускает символ, завершающий последовательность, вместе с байтами продолжения UTF-8
+func (bl *BufferedLogger) skipRune(b byte) {
+	switch {
+	case b >= 0xf0:
+		bl.skipBytes = 3
+	case b >= 0xe0:
+		bl.skipBytes = 2
+	case b >= 0xc0:
+		bl.skipBytes = 1
+	default:
+		return
+	}
+	bl.state = stateSkipRune
+}
+
+// abortSequence разбирает содержимое незавершённой последовательности как обычные данные
+func (bl *BufferedLogger) abortSequence() bool {
+	bl.state = stateGround
+	content := append([]byte(nil), bl.seq...)
+	bl.seq = bl.seq[:0]
+	return bl.feed(content)
+}
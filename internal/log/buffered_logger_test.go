@@ -0,0 +1,221 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// referenceLogger - прежняя реализация BufferedLogger на регулярных выражениях. Используется как эталон
+// для сравнения результатов и производительности
+type referenceLogger struct {
+	baseLogger *zap.Logger
+	streamID   string
+	buffer     *bytes.Buffer
+}
+
+func newReferenceLogger(baseLogger *zap.Logger, streamID string) referenceLogger {
+	bl := referenceLogger{
+		baseLogger: baseLogger,
+		streamID:   streamID,
+		buffer:     bytes.NewBuffer(make([]byte, bufferSize)),
+	}
+	bl.buffer.Reset()
+	return bl
+}
+
+func (bl *referenceLogger) Write(p []byte) (n int, err error) {
+	controlSequences := regexp.MustCompile(`\x1b[ #%()*+\-.\/].|(?:\x1b\[|\x9b)[ -?]*[@-~]|(?:\x1b\]|\x9d).*?(?:\x1b\\|[\a\x9c])|(?:\x1b[P^_]|[\x90\x9e\x9f]).*?(?:\x1b\\|\x9c)|\x1b.|[\x80-\x9f]`)
+	filteredData := controlSequences.ReplaceAll(p, []byte(""))
+	newLine := regexp.MustCompile("(\r\n|\r|\n)")
+	lines := newLine.Split(string(filteredData), -1)
+	for _, line := range lines {
+		n, err := bl.buffer.Write([]byte(line))
+		if err != nil {
+			return n, err
+		}
+		l := bl.buffer.Len()
+		if l > 0 {
+			lb := bl.buffer.Bytes()[l-1]
+			if lb == '\r' {
+				bl.buffer.Truncate(l - 1)
+			}
+		}
+		if len(lines) == 1 {
+			return n, nil
+		}
+		if bl.buffer.Len() > 0 {
+			bl.baseLogger.Info(bl.buffer.String(), zap.String("stream_id", bl.streamID))
+		}
+		bl.buffer.Reset()
+	}
+	return 0, nil
+}
+
+func (bl *referenceLogger) Close() error {
+	bl.baseLogger.Info(bl.buffer.String(), zap.String("stream_id", bl.streamID))
+	return bl.baseLogger.Sync()
+}
+
+// logLines передаёт writes в логгер, созданный newLogger, отдельными вызовами Write, закрывает его
+// и возвращает записанные в журнал строки
+func logLines(t testing.TB, newLogger func(*zap.Logger) io.WriteCloser, writes []string) []string {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := newLogger(zap.New(core))
+	for _, w := range writes {
+		if _, err := l.Write([]byte(w)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{}
+	for _, e := range logs.AllUntimed() {
+		lines = append(lines, e.Message)
+	}
+	return lines
+}
+
+func newTestLogger(base *zap.Logger) io.WriteCloser {
+	bl := NewBufferedLogger(base, "test")
+	return &bl
+}
+
+func newTestReferenceLogger(base *zap.Logger) io.WriteCloser {
+	bl := newReferenceLogger(base, "test")
+	return &bl
+}
+
+func TestBufferedLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string // Последняя строка записывается при закрытии логгера
+	}{
+		{name: "line", writes: []string{"hello\n"}, want: []string{"hello", ""}},
+		{name: "lf", writes: []string{"a\nb\n"}, want: []string{"a", "b", ""}},
+		{name: "crlf", writes: []string{"a\r\nb\r\n"}, want: []string{"a", "b", ""}},
+		{name: "cr", writes: []string{"a\rb\r"}, want: []string{"a", "b", ""}},
+		{name: "lfcr", writes: []string{"a\n\rb\n"}, want: []string{"a", "b", ""}},
+		{name: "empty lines", writes: []string{"\n\r\n\r\n"}, want: []string{""}},
+		{name: "tail after line break", writes: []string{"show clock\nrouter# "}, want: []string{"show clock", "router# ", ""}},
+		{name: "no line break", writes: []string{"abc", "def"}, want: []string{"abcdef"}},
+		{name: "split crlf", writes: []string{"a\r", "\nb\r\n"}, want: []string{"a", "b", ""}},
+		{name: "split line", writes: []string{"sh", "ow ver", "sion\n"}, want: []string{"show version", ""}},
+		{name: "sgr", writes: []string{"\x1b[1;31mred\x1b[0m\n"}, want: []string{"red", ""}},
+		{name: "split csi", writes: []string{"a\x1b", "[3", "1mb\x1b[0", "m\n"}, want: []string{"ab", ""}},
+		{name: "split osc bel", writes: []string{"\x1b]0;ti", "tle\x07$ "}, want: []string{"$ "}},
+		{name: "split osc st", writes: []string{"\x1b]2;title\x1b", "\\$ "}, want: []string{"$ "}},
+		{name: "dcs", writes: []string{"a\x1bP1$r0m\x1b\\b\n"}, want: []string{"ab", ""}},
+		{name: "charset", writes: []string{"\x1b(Ba\x1b", "(0b\n"}, want: []string{"ab", ""}},
+		{name: "esc single", writes: []string{"\x1b7a\x1b8\x1b=b\n"}, want: []string{"ab", ""}},
+		{name: "c1 csi", writes: []string{"\xc2\x9b1mx\xc2", "\x9b0my\n"}, want: []string{"xy", ""}},
+		{name: "c1 osc", writes: []string{"\xc2\x9d0;t\xc2\x9cx\n"}, want: []string{"x", ""}},
+		{name: "utf8", writes: []string{"привет\n", "\xd0", "\xbc\xd0\xb8\xd1\x80\n"}, want: []string{"привет", "мир", ""}},
+		{name: "unterminated csi tail", writes: []string{"abc\x1b[12"}, want: []string{"abc"}},
+		{name: "unterminated esc tail", writes: []string{"abc\x1b"}, want: []string{"abc"}},
+		{name: "unterminated osc tail", writes: []string{"abc\x1b]0;title"}, want: []string{"abc"}},
+		{name: "csi aborted", writes: []string{"\x1b[12\x01x\n"}, want: []string{"12\x01x", ""}},
+		{name: "osc aborted by line break", writes: []string{"\x1b]0;title\nnext\n"}, want: []string{"0;title", "next", ""}},
+		{name: "esc before line break", writes: []string{"a\x1b\nb\n"}, want: []string{"a\x1b", "b", ""}},
+		{name: "long line", writes: []string{strings.Repeat("x", maxLineLength+10) + "\n"},
+			want: []string{strings.Repeat("x", maxLineLength), strings.Repeat("x", 10), ""}},
+		{name: "long osc", writes: []string{"\x1b]" + strings.Repeat("t", maxSeqLength+1) + "\x07\n"}, // Разбирается как данные
+			want: []string{strings.Repeat("t", maxLineLength), strings.Repeat("t", maxSeqLength+1-maxLineLength) + "\a", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := logLines(t, newTestLogger, tt.writes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// referenceTokens - фрагменты вывода, из которых составляются случайные потоки для сравнения с прежней реализацией.
+// Прежняя реализация обрабатывала байты 0x80-0x9f без учёта UTF-8 и не находила последовательности, разделённые
+// между вызовами Write, поэтому потоки составляются из ASCII, а последовательности не разделяются
+var referenceTokens = []string{
+	"a", "b", "show", " ", "#", "-", "\t", "\r", "\n", "\r\n", "\n\r",
+	"\x1b[m", "\x1b[1;31m", "\x1b[0m", "\x1b[?2004h", "\x1b[K", "\x1b[2J", "\x1b[10;20H",
+	"\x1b]0;user@host: ~\x07", "\x1b]2;title\x1b\\", "\x1bP1$r0m\x1b\\", "\x1b_apc\x1b\\",
+	"\x1b(B", "\x1b)0", "\x1b#8", "\x1b7", "\x1b8", "\x1b=", "\x1b>", "\x1bM",
+}
+
+func TestBufferedLoggerMatchesReference(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		writes := make([]string, 1+rnd.Intn(5))
+		for w := range writes {
+			var sb strings.Builder
+			for n := rnd.Intn(20); n > 0; n-- {
+				sb.WriteString(referenceTokens[rnd.Intn(len(referenceTokens))])
+			}
+			writes[w] = sb.String()
+		}
+		got := logLines(t, newTestLogger, writes)
+		want := logLines(t, newTestReferenceLogger, writes)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("writes %q: got %q, reference %q", writes, got, want)
+		}
+	}
+}
+
+// benchmarkOutput - вывод, похожий на show running-config в цветном терминале, блоками по 1 КБ
+func benchmarkOutput() [][]byte {
+	var sb strings.Builder
+	sb.WriteString("\x1b]0;admin@router: ~\x07router# show running-config\r\n")
+	for i := 0; i < 2000; i++ {
+		sb.WriteString("interface GigabitEthernet0/")
+		sb.WriteString(strings.Repeat("1", i%3+1))
+		sb.WriteString("\r\n \x1b[32mdescription\x1b[0m uplink to core\r\n ip address 10.0.0.1 255.255.255.0\r\n!\r\n")
+		if i%40 == 0 {
+			sb.WriteString(" --More-- \x1b[K\x1b[10D")
+		}
+	}
+	data := []byte(sb.String())
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := bufferSize
+		if n > len(data) {
+			n = len(data)
+		}
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+func BenchmarkBufferedLogger(b *testing.B) {
+	chunks := benchmarkOutput()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunks)) * bufferSize)
+	for i := 0; i < b.N; i++ {
+		bl := NewBufferedLogger(zap.NewNop(), "bench")
+		for _, c := range chunks {
+			_, _ = bl.Write(c)
+		}
+	}
+}
+
+func BenchmarkBufferedLoggerReference(b *testing.B) {
+	chunks := benchmarkOutput()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunks)) * bufferSize)
+	for i := 0; i < b.N; i++ {
+		bl := newReferenceLogger(zap.NewNop(), "bench")
+		for _, c := range chunks {
+			_, _ = bl.Write(c)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 )
 
 var rootLogger *zap.Logger
+var rootLevel zap.AtomicLevel
 
 func Init(level string) (*zap.Logger, error) {
 	rawJSON := []byte(`{
@@ -33,6 +34,7 @@ func Init(level string) (*zap.Logger, error) {
 		return rootLogger, err
 	}
 
+	rootLevel = cfg.Level
 	var err error
 	rootLogger, err = cfg.Build()
 	if err != nil {
@@ -0,0 +1,476 @@
+package log
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	tabWidth         = 8
+	maxScreenWidth   = 1000 // Размер окна ограничивается, чтобы клиент не мог заставить выделить произвольный объём памяти
+	maxScreenHeight  = 1000
+	maxCSIParamBytes = 64 // Последовательность с более длинными параметрами отбрасывается
+)
+
+// Состояния разбора вывода виртуальным терминалом
+const (
+	screenGround = iota
+	screenEscape
+	screenEscapeInter
+	screenCSI
+	screenString
+	screenStringEscape
+)
+
+// Screen - виртуальный терминал, поддерживающий подмножество VT100/xterm, достаточное для восстановления
+// текста экрана полноэкранных программ (top, vi, меню сетевых устройств): перемещение курсора, очистку экрана
+// и строк, вставку и удаление строк и символов, область прокрутки и альтернативный экран.
+// Атрибуты символов (цвет, яркость) и ширина символов не учитываются
+type Screen struct {
+	width, height int
+	cells         [][]rune
+	main          [][]rune // Основной экран, пока активен альтернативный
+	x, y          int
+	wrapPending   bool // Курсор в последней колонке, следующий символ переносится на новую строку
+	savedX        int
+	savedY        int
+	top, bottom   int // Область прокрутки
+
+	state    int
+	params   []byte
+	dropCSI  bool // Параметры последовательности слишком длинные, последовательность не выполняется
+	private  bool
+	stringST bool // Управляющая строка завершается только ST, а не BEL
+	utf8buf  []byte
+}
+
+func NewScreen(width, height int) *Screen {
+	s := &Screen{}
+	s.Resize(width, height)
+	return s
+}
+
+// Resize изменяет размер экрана, сохраняя видимую часть содержимого
+func (s *Screen) Resize(width, height int) {
+	if width <= 0 {
+		width = 80
+	}
+	if height <= 0 {
+		height = 24
+	}
+	width, height = minInt(width, maxScreenWidth), minInt(height, maxScreenHeight)
+	resize := func(old [][]rune) [][]rune {
+		rows := blankRows(width, height)
+		for y := 0; y < height && y < len(old); y++ {
+			copy(rows[y], old[y])
+		}
+		return rows
+	}
+	s.cells = resize(s.cells)
+	if s.main != nil {
+		s.main = resize(s.main)
+	}
+	s.width, s.height = width, height
+	s.top, s.bottom = 0, height-1
+	s.x, s.y = clamp(s.x, 0, width-1), clamp(s.y, 0, height-1)
+	s.wrapPending = false
+}
+
+// Lines возвращает строки экрана без завершающих пробелов
+func (s *Screen) Lines() []string {
+	lines := make([]string, s.height)
+	for y, row := range s.cells {
+		lines[y] = strings.TrimRight(string(row), " ")
+	}
+	return lines
+}
+
+func (s *Screen) Write(p []byte) (int, error) {
+	for _, b := range p {
+		switch s.state {
+		case screenGround:
+			s.ground(b)
+		case screenEscape:
+			s.escape(b)
+		case screenEscapeInter:
+			s.state = screenGround // Выбор набора символов и т.п. не влияет на текст
+		case screenCSI:
+			switch {
+			case b >= 0x20 && b <= 0x3f:
+				switch {
+				case b == '?':
+					s.private = true
+				case len(s.params) < maxCSIParamBytes:
+					s.params = append(s.params, b)
+				default:
+					s.dropCSI = true
+				}
+			case b >= 0x40 && b <= 0x7e:
+				s.state = screenGround
+				if !s.dropCSI {
+					s.csi(b)
+				}
+			case b == 0x1b:
+				s.state = screenEscape
+			default:
+				s.control(b)
+			}
+		case screenString:
+			switch {
+			case b == 0x07 && !s.stringST:
+				s.state = screenGround
+			case b == 0x1b:
+				s.state = screenStringEscape
+			}
+		case screenStringEscape:
+			if b == '\\' {
+				s.state = screenGround
+			} else {
+				s.state = screenString
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (s *Screen) ground(b byte) {
+	if len(s.utf8buf) == 0 {
+		switch {
+		case b == 0x1b:
+			s.state = screenEscape
+			return
+		case b < 0x20 || b == 0x7f:
+			s.control(b)
+			return
+		case b < 0x80:
+			s.put(rune(b))
+			return
+		}
+	}
+	s.utf8buf = append(s.utf8buf, b)
+	if !utf8.FullRune(s.utf8buf) {
+		return
+	}
+	r, _ := utf8.DecodeRune(s.utf8buf)
+	s.utf8buf = s.utf8buf[:0]
+	switch {
+	case r == 0x9b:
+		s.startCSI()
+	case r == 0x9d || r == 0x90 || r == 0x9e || r == 0x9f:
+		s.state = screenString
+		s.stringST = r != 0x9d
+	case r >= 0x80 && r <= 0x9f:
+		// Прочие управляющие символы C1 не влияют на текст
+	default:
+		s.put(r)
+	}
+}
+
+func (s *Screen) control(b byte) {
+	switch b {
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapPending = false
+	case '\t':
+		s.x = minInt(s.width-1, (s.x/tabWidth+1)*tabWidth)
+		s.wrapPending = false
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\r':
+		s.x = 0
+		s.wrapPending = false
+	}
+}
+
+func (s *Screen) escape(b byte) {
+	s.state = screenGround
+	switch b {
+	case '[':
+		s.startCSI()
+	case ']':
+		s.state = screenString
+		s.stringST = false
+	case 'P', '^', '_', 'X':
+		s.state = screenString
+		s.stringST = true
+	case ' ', '#', '%', '(', ')', '*', '+', '-', '.', '/':
+		s.state = screenEscapeInter
+	case '7':
+		s.savedX, s.savedY = s.x, s.y
+	case '8':
+		s.restoreCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.x = 0
+		s.lineFeed()
+	case 'M':
+		if s.y == s.top {
+			s.scrollDown(1)
+		} else if s.y > 0 {
+			s.y--
+		}
+	case 'c':
+		s.main = nil
+		s.cells = nil
+		s.x, s.y = 0, 0
+		s.Resize(s.width, s.height)
+	}
+}
+
+func (s *Screen) startCSI() {
+	s.state = screenCSI
+	s.params = s.params[:0]
+	s.dropCSI = false
+	s.private = false
+}
+
+func (s *Screen) csi(final byte) {
+	params := parseParams(s.params)
+	// Количество и координаты не превышают размера экрана: так арифметика с ними не переполняется
+	limit := maxInt(s.width, s.height)
+	arg := func(i, def int) int {
+		if i < len(params) && params[i] > 0 {
+			return minInt(params[i], limit)
+		}
+		return def
+	}
+	eraseMode := func() int {
+		if len(params) > 0 {
+			return params[0]
+		}
+		return 0
+	}
+	if s.private {
+		if final == 'h' || final == 'l' {
+			for _, mode := range params {
+				s.privateMode(mode, final == 'h')
+			}
+		}
+		return
+	}
+	s.wrapPending = false
+	switch final {
+	case 'A':
+		s.y = clamp(s.y-arg(0, 1), 0, s.height-1)
+	case 'B', 'e':
+		s.y = clamp(s.y+arg(0, 1), 0, s.height-1)
+	case 'C', 'a':
+		s.x = clamp(s.x+arg(0, 1), 0, s.width-1)
+	case 'D':
+		s.x = clamp(s.x-arg(0, 1), 0, s.width-1)
+	case 'E':
+		s.x, s.y = 0, clamp(s.y+arg(0, 1), 0, s.height-1)
+	case 'F':
+		s.x, s.y = 0, clamp(s.y-arg(0, 1), 0, s.height-1)
+	case 'G', '`':
+		s.x = clamp(arg(0, 1)-1, 0, s.width-1)
+	case 'd':
+		s.y = clamp(arg(0, 1)-1, 0, s.height-1)
+	case 'H', 'f':
+		s.y = clamp(arg(0, 1)-1, 0, s.height-1)
+		s.x = clamp(arg(1, 1)-1, 0, s.width-1)
+	case 'J':
+		s.eraseDisplay(eraseMode())
+	case 'K':
+		s.eraseLine(eraseMode())
+	case 'L':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.scrollRegion(s.y, s.bottom, -arg(0, 1))
+		}
+	case 'M':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.scrollRegion(s.y, s.bottom, arg(0, 1))
+		}
+	case '@':
+		row := s.cells[s.y]
+		n := minInt(arg(0, 1), s.width-s.x)
+		copy(row[s.x+n:], row[s.x:])
+		fill(row[s.x : s.x+n])
+	case 'P':
+		row := s.cells[s.y]
+		n := minInt(arg(0, 1), s.width-s.x)
+		copy(row[s.x:], row[s.x+n:])
+		fill(row[s.width-n:])
+	case 'X':
+		fill(s.cells[s.y][s.x:minInt(s.width, s.x+arg(0, 1))])
+	case 'S':
+		s.scrollUp(arg(0, 1))
+	case 'T':
+		s.scrollDown(arg(0, 1))
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, s.height)-1
+		if top < bottom && bottom < s.height {
+			s.top, s.bottom = top, bottom
+			s.x, s.y = 0, 0
+		}
+	case 's':
+		s.savedX, s.savedY = s.x, s.y
+	case 'u':
+		s.restoreCursor()
+	}
+}
+
+// privateMode обрабатывает переключение на альтернативный экран (режимы 47, 1047, 1049)
+func (s *Screen) privateMode(mode int, set bool) {
+	if mode != 47 && mode != 1047 && mode != 1049 {
+		return
+	}
+	if set && s.main == nil {
+		if mode == 1049 {
+			s.savedX, s.savedY = s.x, s.y
+		}
+		s.main = s.cells
+		s.cells = blankRows(s.width, s.height)
+	} else if !set && s.main != nil {
+		s.cells = s.main
+		s.main = nil
+		if mode == 1049 {
+			s.restoreCursor()
+		}
+	}
+	s.wrapPending = false
+}
+
+// restoreCursor восстанавливает сохранённую позицию курсора (размер экрана мог с тех пор измениться)
+func (s *Screen) restoreCursor() {
+	s.x, s.y = clamp(s.savedX, 0, s.width-1), clamp(s.savedY, 0, s.height-1)
+	s.wrapPending = false
+}
+
+func (s *Screen) put(r rune) {
+	if s.wrapPending {
+		s.x = 0
+		s.lineFeed()
+	}
+	s.cells[s.y][s.x] = r
+	if s.x == s.width-1 {
+		s.wrapPending = true
+	} else {
+		s.x++
+	}
+}
+
+func (s *Screen) lineFeed() {
+	s.wrapPending = false
+	if s.y == s.bottom {
+		s.scrollUp(1)
+	} else if s.y < s.height-1 {
+		s.y++
+	}
+}
+
+func (s *Screen) scrollUp(n int) {
+	s.scrollRegion(s.top, s.bottom, n)
+}
+
+func (s *Screen) scrollDown(n int) {
+	s.scrollRegion(s.top, s.bottom, -n)
+}
+
+// scrollRegion сдвигает строки с top по bottom на n строк вверх (при отрицательном n - вниз),
+// освободившиеся строки очищаются
+func (s *Screen) scrollRegion(top, bottom, n int) {
+	rows := s.cells[top : bottom+1]
+	if n > len(rows) {
+		n = len(rows)
+	}
+	if n < -len(rows) {
+		n = -len(rows)
+	}
+	if n > 0 {
+		removed := append([][]rune(nil), rows[:n]...)
+		copy(rows, rows[n:])
+		for i, row := range removed {
+			fill(row)
+			rows[len(rows)-n+i] = row
+		}
+	} else if n < 0 {
+		n = -n
+		removed := append([][]rune(nil), rows[len(rows)-n:]...)
+		copy(rows[n:], rows)
+		for i, row := range removed {
+			fill(row)
+			rows[i] = row
+		}
+	}
+}
+
+func (s *Screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		fill(s.cells[s.y][s.x:])
+		for _, row := range s.cells[s.y+1:] {
+			fill(row)
+		}
+	case 1:
+		fill(s.cells[s.y][:s.x+1])
+		for _, row := range s.cells[:s.y] {
+			fill(row)
+		}
+	case 2, 3:
+		for _, row := range s.cells {
+			fill(row)
+		}
+	}
+}
+
+func (s *Screen) eraseLine(mode int) {
+	row := s.cells[s.y]
+	switch mode {
+	case 0:
+		fill(row[s.x:])
+	case 1:
+		fill(row[:s.x+1])
+	case 2:
+		fill(row)
+	}
+}
+
+func parseParams(raw []byte) []int {
+	if len(raw) == 0 {
+		return nil
+	}
+	fields := strings.Split(string(raw), ";")
+	params := make([]int, len(fields))
+	for i, f := range fields {
+		params[i], _ = strconv.Atoi(f)
+	}
+	return params
+}
+
+func blankRows(width, height int) [][]rune {
+	rows := make([][]rune, height)
+	for y := range rows {
+		rows[y] = make([]rune, width)
+		fill(rows[y])
+	}
+	return rows
+}
+
+func fill(cells []rune) {
+	for i := range cells {
+		cells[i] = ' '
+	}
+}
+
+func clamp(v, lo, hi int) int {
+	return maxInt(lo, minInt(v, hi))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,102 @@
+package log
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ScreenLogger передаёт вывод сессии виртуальному терминалу и периодически записывает в журнал изменившиеся строки
+// его экрана. Это даёт читаемый и доступный для поиска текст работы с полноэкранными программами, который нельзя
+// получить из потока простым удалением управляющих последовательностей.
+// Если изменилось больше половины строк, записывается весь экран
+type ScreenLogger struct {
+	baseLogger *zap.Logger
+	streamID   string
+	mu         sync.Mutex
+	screen     *Screen
+	logged     []string // Строки экрана на момент последней записи в журнал
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func NewScreenLogger(baseLogger *zap.Logger, streamID string, width, height int, interval time.Duration) *ScreenLogger {
+	sl := &ScreenLogger{
+		baseLogger: baseLogger,
+		streamID:   streamID,
+		screen:     NewScreen(width, height),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go func() {
+		defer close(sl.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sl.snapshot()
+			case <-sl.stop:
+				return
+			}
+		}
+	}()
+	return sl
+}
+
+func (sl *ScreenLogger) Write(p []byte) (int, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.screen.Write(p)
+}
+
+func (sl *ScreenLogger) Resize(width, height int) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.screen.Resize(width, height)
+}
+
+// Close записывает последнее состояние экрана и останавливает периодическую запись
+func (sl *ScreenLogger) Close() error {
+	close(sl.stop)
+	<-sl.done
+	sl.snapshot()
+	return sl.baseLogger.Sync()
+}
+
+func (sl *ScreenLogger) snapshot() {
+	sl.mu.Lock()
+	lines := sl.screen.Lines()
+	sl.mu.Unlock()
+
+	var changed []int
+	for y, line := range lines {
+		if len(sl.logged) != len(lines) || sl.logged[y] != line {
+			changed = append(changed, y)
+		}
+	}
+	sl.logged = lines
+	if len(changed) == 0 {
+		return
+	}
+	full := len(changed)*2 > len(lines)
+	var text string
+	if full {
+		text = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	} else {
+		rows := make([]string, len(changed))
+		for i, y := range changed {
+			rows[i] = lines[y]
+		}
+		text = strings.Join(rows, "\n")
+	}
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	sl.baseLogger.Info(text,
+		zap.String("stream_id", sl.streamID),
+		zap.Bool("screen_full", full),
+		zap.Ints("screen_rows", changed))
+}
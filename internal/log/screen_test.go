@@ -0,0 +1,72 @@
+package log
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{name: "text", output: "ab\r\ncd", want: []string{"ab", "cd", ""}},
+		{name: "cursor position", output: "\x1b[2;3Hx\x1b[1;1Hy", want: []string{"y", "  x", ""}},
+		{name: "erase line", output: "abcdef\x1b[1;3H\x1b[K", want: []string{"ab", "", ""}},
+		{name: "erase chars", output: "abcdef\x1b[1;2H\x1b[2X", want: []string{"a  def", "", ""}},
+		{name: "insert chars", output: "abc\x1b[1;2H\x1b[2@", want: []string{"a  bc", "", ""}},
+		{name: "delete chars", output: "abcdef\x1b[1;2H\x1b[2P", want: []string{"adef", "", ""}},
+		{name: "alternate screen", output: "main\x1b[?1049hvi\x1b[?1049l", want: []string{"main", "", ""}},
+		{name: "scroll", output: "1\r\n2\r\n3\r\n4", want: []string{"2", "3", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScreen(10, 3)
+			_, _ = s.Write([]byte(tt.output))
+			if got := s.Lines(); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScreenLargeParams проверяет, что параметры, превышающие размер экрана, в том числе вызывающие переполнение
+// int, не приводят к панике
+func TestScreenLargeParams(t *testing.T) {
+	for _, param := range []string{"9223372036854775807", "9223372036854775808", "99999999999999999999", "65535", "-1"} {
+		for _, final := range "ABCDEFGHJKLMPSTX@`adefrsu" {
+			s := NewScreen(10, 3)
+			output := "ab\r\ncd\x1b[2;2H\x1b[" + param + string(final) + "x\x1b[" + param + ";" + param + string(final) + "y"
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("CSI %s %c: %v", param, final, r)
+					}
+				}()
+				_, _ = s.Write([]byte(output))
+			}()
+		}
+	}
+}
+
+func TestScreenLimits(t *testing.T) {
+	s := NewScreen(1<<30, 1<<30)
+	if s.width != maxScreenWidth || s.height != maxScreenHeight {
+		t.Errorf("screen size %dx%d not limited", s.width, s.height)
+	}
+	s.Resize(100000, 5)
+	if s.width != maxScreenWidth || s.height != 5 {
+		t.Errorf("screen size %dx%d not limited after resize", s.width, s.height)
+	}
+
+	s = NewScreen(10, 3)
+	_, _ = s.Write([]byte("\x1b[" + strings.Repeat("1;", 100000)))
+	if len(s.params) > maxCSIParamBytes {
+		t.Errorf("unterminated CSI params grew to %d bytes", len(s.params))
+	}
+	_, _ = s.Write([]byte("2Jab")) // Слишком длинная последовательность отбрасывается, экран не очищается
+	if got := s.Lines()[0]; got != "ab" {
+		t.Errorf("got %q after dropped sequence, want %q", got, "ab")
+	}
+}
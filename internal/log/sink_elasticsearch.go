@@ -0,0 +1,246 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	esRequestTimeout  = 30 * time.Second
+	esRetryAttempts   = 5
+	esRetryDelay      = time.Second // Удваивается после каждой неудачной попытки
+	esCloseTimeout    = 10 * time.Second
+	esDropReportDelay = time.Minute
+)
+
+// ElasticsearchSinkConfig задаёт отправку журнала в Elasticsearch через Bulk API. Записи отправляются пакетами
+// до BatchSize записей или раз в FlushIntervalSec секунд в ежедневные индексы <Index>-ГГГГ.ММ.ДД.
+// Если Elasticsearch не успевает принимать записи и очередь из QueueSize записей заполнена, запись журнала
+// ожидает места в очереди до BlockTimeoutMs миллисекунд, после чего отбрасывается
+type ElasticsearchSinkConfig struct {
+	URL              string
+	Index            string
+	Username         string
+	Password         string
+	BatchSize        int
+	FlushIntervalSec int
+	QueueSize        int
+	BlockTimeoutMs   int
+}
+
+type esDocument struct {
+	index string
+	body  []byte
+}
+
+type elasticsearchSink struct {
+	config       ElasticsearchSinkConfig
+	client       *http.Client
+	queue        chan esDocument
+	blockTimeout time.Duration
+	retryDelay   time.Duration
+	done         chan struct{}
+
+	mu      sync.Mutex
+	dropped int // Отброшенные записи, о которых ещё не сообщено
+
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+func newElasticsearchSink(c ElasticsearchSinkConfig) (*elasticsearchSink, error) {
+	if c.Index == "" {
+		return nil, fmt.Errorf("elasticsearch index is not set")
+	}
+	if c.BatchSize <= 0 || c.QueueSize <= 0 || c.FlushIntervalSec <= 0 {
+		return nil, fmt.Errorf("elasticsearch batch size, queue size and flush interval must be positive")
+	}
+	c.URL = strings.TrimRight(c.URL, "/")
+	s := &elasticsearchSink{
+		config:       c,
+		client:       &http.Client{Timeout: esRequestTimeout},
+		queue:        make(chan esDocument, c.QueueSize),
+		blockTimeout: time.Duration(c.BlockTimeoutMs) * time.Millisecond,
+		retryDelay:   esRetryDelay,
+		done:         make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *elasticsearchSink) write(entry zapcore.Entry, _ string, line []byte) error {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return nil
+	}
+	doc := esDocument{
+		index: s.config.Index + "-" + entry.Time.UTC().Format("2006.01.02"),
+		body:  append([]byte(nil), line...),
+	}
+	select {
+	case s.queue <- doc:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(s.blockTimeout)
+	defer timer.Stop()
+	select {
+	case s.queue <- doc:
+	case <-timer.C:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *elasticsearchSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Duration(s.config.FlushIntervalSec) * time.Second)
+	defer ticker.Stop()
+	lastDropReport := time.Now()
+	batch := make([]esDocument, 0, s.config.BatchSize)
+	for {
+		flush := false
+		select {
+		case doc, ok := <-s.queue:
+			if !ok {
+				s.send(batch)
+				s.reportDropped()
+				return
+			}
+			batch = append(batch, doc)
+			flush = len(batch) >= s.config.BatchSize
+		case <-ticker.C:
+			flush = len(batch) > 0
+		}
+		if flush {
+			s.send(batch)
+			batch = batch[:0]
+		}
+		if time.Since(lastDropReport) >= esDropReportDelay {
+			s.reportDropped()
+			lastDropReport = time.Now()
+		}
+	}
+}
+
+// send отправляет пакет, повторяя отправку записей, которые Elasticsearch не принял из-за перегрузки или сбоя.
+// Пока пакет не отправлен, новые записи накапливаются в очереди
+func (s *elasticsearchSink) send(batch []esDocument) {
+	delay := s.retryDelay
+	for attempt := 1; len(batch) > 0; attempt++ {
+		retry, err := s.bulk(batch)
+		if err == nil && len(retry) == 0 {
+			return
+		}
+		if attempt == esRetryAttempts {
+			if err == nil {
+				err = fmt.Errorf("%d records not accepted", len(retry))
+			}
+			sinkError("Elasticsearch", fmt.Errorf("%w, %d records dropped", err, len(batch)))
+			return
+		}
+		if err == nil {
+			batch = retry
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+	} `json:"items"`
+}
+
+// bulk отправляет записи одним запросом Bulk API. Возвращает записи, отправку которых имеет смысл повторить;
+// при ошибке повторить нужно все записи
+func (s *elasticsearchSink) bulk(batch []esDocument) ([]esDocument, error) {
+	var body bytes.Buffer
+	for _, doc := range batch {
+		_, _ = fmt.Fprintf(&body, `{"create":{"_index":%q}}`+"\n", doc.index)
+		body.Write(doc.body)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, s.config.URL+"/_bulk", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+	response, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bulk request failed with status %d", response.StatusCode)
+	}
+	var result esBulkResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	var retry []esDocument
+	rejected := 0
+	for i, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status == http.StatusTooManyRequests || status.Status >= 500:
+				retry = append(retry, batch[i])
+			case status.Status >= 300:
+				rejected++
+			}
+		}
+	}
+	if rejected > 0 {
+		sinkError("Elasticsearch", fmt.Errorf("%d records rejected", rejected))
+	}
+	return retry, nil
+}
+
+func (s *elasticsearchSink) reportDropped() {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		sinkError("Elasticsearch", fmt.Errorf("queue is full, %d records dropped", dropped))
+	}
+}
+
+// close отправляет записи из очереди, ожидая завершения отправки не дольше esCloseTimeout
+func (s *elasticsearchSink) close() error {
+	s.closeMu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.closeMu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(esCloseTimeout):
+		return fmt.Errorf("timeout sending queued records to Elasticsearch")
+	}
+}
@@ -0,0 +1,238 @@
+package log
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// esStub - заглушка Bulk API Elasticsearch, запоминающая документы каждого запроса
+type esStub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests [][]string
+	indexes  []string
+	respond  func(request int, docs []string) (int, string) // Ответ на запрос с номером request (с 0)
+	received chan struct{}
+}
+
+func newESStub(t *testing.T, respond func(request int, docs []string) (int, string)) *esStub {
+	stub := &esStub{respond: respond, received: make(chan struct{}, 100)}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var docs []string
+		scanner := bufio.NewScanner(r.Body)
+		for i := 0; scanner.Scan(); i++ {
+			if i%2 == 0 {
+				stub.mu.Lock()
+				stub.indexes = append(stub.indexes, scanner.Text())
+				stub.mu.Unlock()
+			} else {
+				docs = append(docs, scanner.Text())
+			}
+		}
+		stub.mu.Lock()
+		n := len(stub.requests)
+		stub.requests = append(stub.requests, docs)
+		stub.mu.Unlock()
+		stub.received <- struct{}{}
+		status, body := http.StatusOK, esBulkOK(len(docs))
+		if stub.respond != nil {
+			status, body = stub.respond(n, docs)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (stub *esStub) docs() [][]string {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return append([][]string(nil), stub.requests...)
+}
+
+// wait ожидает n запросов к заглушке
+func (stub *esStub) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-stub.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for bulk request %d", i+1)
+		}
+	}
+}
+
+// esBulkItems возвращает ответ Bulk API с заданными статусами записей
+func esBulkItems(statuses ...int) string {
+	items := make([]string, len(statuses))
+	errors := false
+	for i, status := range statuses {
+		items[i] = fmt.Sprintf(`{"create":{"status":%d}}`, status)
+		errors = errors || status >= 300
+	}
+	return fmt.Sprintf(`{"errors":%v,"items":[%s]}`, errors, strings.Join(items, ","))
+}
+
+func esBulkOK(n int) string {
+	statuses := make([]int, n)
+	for i := range statuses {
+		statuses[i] = http.StatusCreated
+	}
+	return esBulkItems(statuses...)
+}
+
+func newTestESSink(t *testing.T, url string, c ElasticsearchSinkConfig) *elasticsearchSink {
+	t.Helper()
+	c.URL = url
+	c.Index = "bastion"
+	s, err := newElasticsearchSink(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.retryDelay = time.Millisecond
+	return s
+}
+
+func writeDocs(t *testing.T, s sink, docs ...string) {
+	t.Helper()
+	for _, doc := range docs {
+		if err := s.write(zapcore.Entry{Time: time.Now(), Level: zapcore.InfoLevel}, "", []byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestElasticsearchSinkBatchSize(t *testing.T) {
+	stub := newESStub(t, nil)
+	s := newTestESSink(t, stub.URL, ElasticsearchSinkConfig{BatchSize: 3, FlushIntervalSec: 3600, QueueSize: 10})
+	writeDocs(t, s, `{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`, `{"n":5}`, `{"n":6}`, `{"n":7}`)
+	stub.wait(t, 2)
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{`{"n":1}`, `{"n":2}`, `{"n":3}`}, {`{"n":4}`, `{"n":5}`, `{"n":6}`}, {`{"n":7}`}}
+	if got := stub.docs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+	index := `{"create":{"_index":"bastion-` + time.Now().UTC().Format("2006.01.02") + `"}}`
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.indexes[0] != index {
+		t.Errorf("got action %s, want %s", stub.indexes[0], index)
+	}
+}
+
+func TestElasticsearchSinkFlushInterval(t *testing.T) {
+	stub := newESStub(t, nil)
+	s := newTestESSink(t, stub.URL, ElasticsearchSinkConfig{BatchSize: 100, FlushIntervalSec: 1, QueueSize: 10})
+	defer s.close()
+	writeDocs(t, s, `{"n":1}`, `{"n":2}`)
+	stub.wait(t, 1)
+	want := [][]string{{`{"n":1}`, `{"n":2}`}}
+	if got := stub.docs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+}
+
+func TestElasticsearchSinkFlushOnClose(t *testing.T) {
+	stub := newESStub(t, nil)
+	s := newTestESSink(t, stub.URL, ElasticsearchSinkConfig{BatchSize: 100, FlushIntervalSec: 3600, QueueSize: 10})
+	writeDocs(t, s, `{"n":1}`, `{"n":2}`, `{"n":3}`)
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{`{"n":1}`, `{"n":2}`, `{"n":3}`}}
+	if got := stub.docs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+	writeDocs(t, s, `{"n":4}`) // Записи после закрытия игнорируются
+}
+
+func TestElasticsearchSinkRetry(t *testing.T) {
+	stub := newESStub(t, func(request int, docs []string) (int, string) {
+		switch request {
+		case 0:
+			return http.StatusServiceUnavailable, `{"error":"unavailable"}`
+		case 1: // Первая запись повторяется, вторая принята, третья отклонена без повтора
+			return http.StatusOK, esBulkItems(http.StatusTooManyRequests, http.StatusCreated, http.StatusBadRequest)
+		case 2:
+			return http.StatusOK, esBulkItems(http.StatusInternalServerError)
+		default:
+			return http.StatusOK, esBulkOK(len(docs))
+		}
+	})
+	s := newTestESSink(t, stub.URL, ElasticsearchSinkConfig{BatchSize: 3, FlushIntervalSec: 3600, QueueSize: 10})
+	writeDocs(t, s, `{"n":1}`, `{"n":2}`, `{"n":3}`)
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{`{"n":1}`, `{"n":2}`, `{"n":3}`},
+		{`{"n":1}`, `{"n":2}`, `{"n":3}`},
+		{`{"n":1}`},
+		{`{"n":1}`},
+	}
+	if got := stub.docs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+}
+
+func TestElasticsearchSinkRetryLimit(t *testing.T) {
+	stub := newESStub(t, func(int, []string) (int, string) {
+		return http.StatusTooManyRequests, ""
+	})
+	s := newTestESSink(t, stub.URL, ElasticsearchSinkConfig{BatchSize: 1, FlushIntervalSec: 3600, QueueSize: 10})
+	writeDocs(t, s, `{"n":1}`)
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(stub.docs()); got != esRetryAttempts {
+		t.Errorf("got %d requests, want %d", got, esRetryAttempts)
+	}
+}
+
+func TestElasticsearchSinkQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	stub := newESStub(t, func(_ int, docs []string) (int, string) {
+		<-release
+		return http.StatusOK, esBulkOK(len(docs))
+	})
+	s := newTestESSink(t, stub.URL, ElasticsearchSinkConfig{BatchSize: 1, FlushIntervalSec: 3600, QueueSize: 1, BlockTimeoutMs: 10})
+	writeDocs(t, s, `{"n":1}`)
+	stub.wait(t, 1) // Отправка первой записи не завершается, пока заглушка не ответит
+	start := time.Now()
+	writeDocs(t, s, `{"n":2}`, `{"n":3}`, `{"n":4}`) // Вторая запись занимает очередь, остальные отбрасываются
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("writes to full queue returned after %s, want at least block timeout per record", elapsed)
+	}
+	s.mu.Lock()
+	dropped := s.dropped
+	s.mu.Unlock()
+	if dropped != 2 {
+		t.Errorf("got %d dropped records, want 2", dropped)
+	}
+	close(release)
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{`{"n":1}`}, {`{"n":2}`}}
+	if got := stub.docs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+	if s.dropped != 0 {
+		t.Errorf("dropped records were not reported on close")
+	}
+}
@@ -0,0 +1,165 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	fileSinkIdleTimeout   = 5 * time.Minute // Файл сессии закрывается, если в него долго не было записей
+	fileSinkCommonLog     = "bastion.log"   // Записи, не относящиеся к сессиям
+	fileSinkSessionPrefix = "session-"
+)
+
+// FileSinkConfig задаёт запись журнала в локальные файлы: отдельный файл для каждой сессии и общий файл
+// для остальных записей. Файл, превысивший MaxSizeMB, переименовывается в <имя>.1 (прежние копии сдвигаются),
+// хранится не более MaxBackups копий
+type FileSinkConfig struct {
+	Dir        string
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+type fileSink struct {
+	dir        string
+	maxSize    int64
+	maxBackups int
+
+	mu    sync.Mutex
+	files map[string]*rotatingFile
+	stop  chan struct{}
+}
+
+type rotatingFile struct {
+	file     *os.File
+	size     int64
+	lastUsed time.Time
+}
+
+func newFileSink(c FileSinkConfig) (*fileSink, error) {
+	if err := os.MkdirAll(c.Dir, 0750); err != nil {
+		return nil, err
+	}
+	s := &fileSink{
+		dir:        c.Dir,
+		maxSize:    int64(c.MaxSizeMB) * 1024 * 1024,
+		maxBackups: c.MaxBackups,
+		files:      map[string]*rotatingFile{},
+		stop:       make(chan struct{}),
+	}
+	go s.closeIdleFiles()
+	return s, nil
+}
+
+func (s *fileSink) write(_ zapcore.Entry, session string, line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := fileSinkCommonLog
+	if session != "" {
+		name = fileSinkSessionPrefix + sanitizeFileName(session) + ".log"
+	}
+	f, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	if s.maxSize > 0 && f.size > 0 && f.size+int64(len(line))+1 > s.maxSize {
+		if f, err = s.rotate(name); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(append(line, '\n'))
+	f.size += int64(n)
+	f.lastUsed = time.Now()
+	return err
+}
+
+func (s *fileSink) open(name string) (*rotatingFile, error) {
+	if f, ok := s.files[name]; ok {
+		return f, nil
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	f := &rotatingFile{file: file, size: info.Size(), lastUsed: time.Now()}
+	s.files[name] = f
+	return f, nil
+}
+
+// rotate закрывает файл, сдвигает его копии (<имя>.1 -> <имя>.2 ...) и открывает новый файл
+func (s *fileSink) rotate(name string) (*rotatingFile, error) {
+	_ = s.files[name].file.Close()
+	delete(s.files, name)
+	path := filepath.Join(s.dir, name)
+	if s.maxBackups <= 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		return s.open(name)
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", path, s.maxBackups))
+	for i := s.maxBackups - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		return nil, err
+	}
+	return s.open(name)
+}
+
+func (s *fileSink) closeIdleFiles() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			for name, f := range s.files {
+				if time.Since(f.lastUsed) > fileSinkIdleTimeout {
+					_ = f.file.Close()
+					delete(s.files, name)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *fileSink) close() error {
+	close(s.stop)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for name, f := range s.files {
+		if cerr := f.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.files, name)
+	}
+	return err
+}
+
+// sanitizeFileName заменяет в имени символы, недопустимые или опасные в имени файла
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
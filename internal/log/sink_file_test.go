@@ -0,0 +1,57 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newFileSink(FileSinkConfig{Dir: dir, MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.maxSize = 30 // Три записи по 10 байт вместе с переводом строки
+	entry := zapcore.Entry{Time: time.Now(), Level: zapcore.InfoLevel}
+	for i := 1; i <= 10; i++ {
+		if err := s.write(entry, "token/../1", []byte(fmt.Sprintf("record-%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.write(entry, "", []byte("common")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(name string) []string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	session := "session-token____1.log"
+	tests := map[string][]string{
+		session + ".2":    {"record-04", "record-05", "record-06"},
+		session + ".1":    {"record-07", "record-08", "record-09"},
+		session:           {"record-10"},
+		fileSinkCommonLog: {"common"},
+	}
+	for name, want := range tests {
+		if got := read(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, session+".3")); !os.IsNotExist(err) {
+		t.Errorf("backup beyond MaxBackups exists: %v", err)
+	}
+}
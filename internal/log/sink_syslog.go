@@ -0,0 +1,238 @@
+package log
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	syslogTimeout         = 5 * time.Second
+	syslogTimeFormat      = "2006-01-02T15:04:05.000000Z07:00"
+	syslogQueueSize       = 1024
+	syslogCloseTimeout    = 10 * time.Second
+	syslogDropReportDelay = time.Minute
+)
+
+// SyslogSinkConfig задаёт отправку журнала на сервер syslog в формате RFC 5424. Network - udp, tcp или tls;
+// для tcp и tls сообщения разделяются подсчётом октетов (RFC 6587, RFC 5425).
+// Сообщения отправляются в фоне, чтобы медленный или недоступный сервер не задерживал сессии: если очередь
+// из syslogQueueSize сообщений заполнена, сообщение отбрасывается
+type SyslogSinkConfig struct {
+	Network    string
+	Address    string
+	Facility   int
+	AppName    string
+	CACertFile string // Сертификат, которому доверяет клиент TLS в дополнение к системным корневым
+}
+
+type syslogSink struct {
+	network   string
+	address   string
+	facility  int
+	hostname  string
+	appName   string
+	procID    string
+	tlsConfig *tls.Config
+	dial      func() (net.Conn, error)
+	queue     chan []byte
+	done      chan struct{}
+	conn      net.Conn // Используется только горутиной отправки
+
+	mu      sync.Mutex
+	dropped int // Отброшенные сообщения, о которых ещё не сообщено
+
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+func newSyslogSink(c SyslogSinkConfig) (*syslogSink, error) {
+	s := &syslogSink{
+		network:  c.Network,
+		address:  c.Address,
+		facility: c.Facility,
+		appName:  syslogHeaderField(c.AppName, 48),
+		procID:   strconv.Itoa(os.Getpid()),
+		queue:    make(chan []byte, syslogQueueSize),
+		done:     make(chan struct{}),
+	}
+	s.dial = s.connect
+	switch c.Network {
+	case "udp", "tcp":
+	case "tls":
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		if c.CACertFile != "" {
+			certs, err := os.ReadFile(c.CACertFile)
+			if err != nil {
+				return nil, err
+			}
+			if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
+				return nil, errors.New("appending certificate to pool failed")
+			}
+		}
+		s.tlsConfig = &tls.Config{RootCAs: rootCAs}
+	default:
+		return nil, fmt.Errorf("unknown syslog network '%s'", c.Network)
+	}
+	if s.facility < 0 || s.facility > 23 {
+		return nil, fmt.Errorf("syslog facility %d out of range 0-23", s.facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	s.hostname = syslogHeaderField(hostname, 255)
+	go s.run()
+	return s, nil
+}
+
+func (s *syslogSink) write(entry zapcore.Entry, session string, line []byte) error {
+	msgID := "-"
+	if session != "" {
+		msgID = "session"
+	}
+	priority := s.facility*8 + syslogSeverity(entry.Level)
+	msg := fmt.Sprintf("<%d>1 %s %s %s %s %s - %s", priority, entry.Time.Format(syslogTimeFormat),
+		s.hostname, s.appName, s.procID, msgID, line)
+	if s.network != "udp" {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return nil
+	}
+	select {
+	case s.queue <- []byte(msg):
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *syslogSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(syslogDropReportDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-s.queue:
+			if !ok {
+				s.reportDropped()
+				return
+			}
+			if err := s.send(msg); err != nil {
+				sinkError("Syslog", err)
+			}
+		case <-ticker.C:
+			s.reportDropped()
+		}
+	}
+}
+
+// send отправляет сообщение. При ошибке соединение устанавливается заново: сервер мог перезапуститься
+func (s *syslogSink) send(msg []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = s.dial(); err != nil {
+				return err
+			}
+		}
+		_ = s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = s.conn.Write(msg); err == nil {
+			return nil
+		}
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *syslogSink) reportDropped() {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		sinkError("Syslog", fmt.Errorf("queue is full, %d records dropped", dropped))
+	}
+}
+
+func (s *syslogSink) connect() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	if s.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", s.address, s.tlsConfig)
+	}
+	return dialer.Dial(s.network, s.address)
+}
+
+// close отправляет сообщения из очереди, ожидая завершения отправки не дольше syslogCloseTimeout
+func (s *syslogSink) close() error {
+	s.closeMu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.closeMu.Unlock()
+	select {
+	case <-s.done:
+	case <-time.After(syslogCloseTimeout):
+		return fmt.Errorf("timeout sending queued records to syslog")
+	}
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// syslogSeverity сопоставляет уровню записи важность syslog
+func syslogSeverity(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel:
+		return 2
+	case zapcore.PanicLevel:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// syslogHeaderField приводит значение к полю заголовка RFC 5424: печатные символы ASCII без пробелов,
+// не длиннее maxLength, "-" для пустого значения
+func syslogHeaderField(value string, maxLength int) string {
+	field := make([]byte, 0, len(value))
+	for i := 0; i < len(value) && len(field) < maxLength; i++ {
+		if value[i] > ' ' && value[i] < 0x7f {
+			field = append(field, value[i])
+		}
+	}
+	if len(field) == 0 {
+		return "-"
+	}
+	return string(field)
+}
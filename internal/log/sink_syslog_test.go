@@ -0,0 +1,137 @@
+package log
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSyslogSinkFormat(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryTime := time.Date(2023, 10, 19, 12, 30, 45, 123456000, time.UTC)
+	want := []string{
+		"<134>1 2023-10-19T12:30:45.123456Z " + hostname + " bastionproxy " + strconv.Itoa(os.Getpid()) +
+			` session - {"message":"session output"}`,
+		"<132>1 2023-10-19T12:30:45.123456Z " + hostname + " bastionproxy " + strconv.Itoa(os.Getpid()) +
+			` - - {"message":"warning"}`,
+	}
+	// Пробелы и управляющие символы удаляются из полей заголовка
+	write := func(t *testing.T, network, address string) {
+		s, err := newSyslogSink(SyslogSinkConfig{Network: network, Address: address, Facility: 16, AppName: "bastion proxy\n"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.close()
+		if err := s.write(zapcore.Entry{Time: entryTime, Level: zapcore.InfoLevel}, "token", []byte(`{"message":"session output"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.write(zapcore.Entry{Time: entryTime, Level: zapcore.WarnLevel}, "", []byte(`{"message":"warning"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		write(t, "udp", conn.LocalAddr().String())
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 2048)
+		for _, w := range want {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(buf[:n]); got != w {
+				t.Errorf("got %q, want %q", got, w)
+			}
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		write(t, "tcp", listener.Addr().String())
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		for _, w := range want { // Подсчёт октетов: <длина> <сообщение>
+			length, err := r.ReadString(' ')
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(msg); got != w {
+				t.Errorf("got %q, want %q", got, w)
+			}
+		}
+	})
+}
+
+// TestSyslogSinkDoesNotBlock проверяет, что недоступный сервер syslog не задерживает запись журнала:
+// сообщения сверх размера очереди отбрасываются
+func TestSyslogSinkDoesNotBlock(t *testing.T) {
+	s, err := newSyslogSink(SyslogSinkConfig{Network: "tcp", Address: "127.0.0.1:1", Facility: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	dialing := make(chan struct{}, 1)
+	s.dial = func() (net.Conn, error) { // Сервер не отвечает, пока release не закрыт
+		dialing <- struct{}{}
+		<-release
+		client, server := net.Pipe()
+		go func() { _, _ = io.Copy(io.Discard, server) }()
+		return client, nil
+	}
+	entry := zapcore.Entry{Time: time.Now(), Level: zapcore.InfoLevel}
+	if err := s.write(entry, "", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	<-dialing
+	start := time.Now()
+	for i := 0; i < syslogQueueSize+10; i++ {
+		if err := s.write(entry, "token", []byte("output")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("writes blocked for %s", elapsed)
+	}
+	s.mu.Lock()
+	dropped := s.dropped
+	s.mu.Unlock()
+	if dropped != 10 {
+		t.Errorf("dropped %d records, want 10", dropped)
+	}
+	close(release)
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,160 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SinkConfig задаёт получателей журнала в дополнение к стандартному выводу (или вместо него).
+// Получатель включается, если задан его адрес (каталог, адрес сервера, URL)
+type SinkConfig struct {
+	Stdout        bool
+	Files         FileSinkConfig
+	Syslog        SyslogSinkConfig
+	Elasticsearch ElasticsearchSinkConfig
+}
+
+// sink - получатель записей журнала. session - токен сессии, к которой относится запись (пусто, если запись
+// не относится к сессии), line - запись, закодированная в JSON, без перевода строки
+type sink interface {
+	write(entry zapcore.Entry, session string, line []byte) error
+	close() error
+}
+
+var sinks []sink
+
+// InitSinks направляет записи корневого журнала настроенным получателям и возвращает новый корневой журнал.
+// Вызывается после Init
+func InitSinks(c SinkConfig) (*zap.Logger, error) {
+	var err error
+	var s sink
+	if c.Files.Dir != "" {
+		if s, err = newFileSink(c.Files); err != nil {
+			return Get(), fmt.Errorf("error initializing file log sink: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if c.Syslog.Address != "" {
+		if s, err = newSyslogSink(c.Syslog); err != nil {
+			return Get(), fmt.Errorf("error initializing syslog sink: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if c.Elasticsearch.URL != "" {
+		if s, err = newElasticsearchSink(c.Elasticsearch); err != nil {
+			return Get(), fmt.Errorf("error initializing Elasticsearch sink: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 0 {
+		if !c.Stdout {
+			return Get(), errors.New("no log sinks configured")
+		}
+		return Get(), nil
+	}
+	cores := make([]zapcore.Core, 0, len(sinks)+1)
+	for _, s := range sinks {
+		cores = append(cores, &sinkCore{
+			LevelEnabler: rootLevel,
+			sink:         s,
+			encoder:      zapcore.NewJSONEncoder(jsonEncoderConfig()),
+		})
+	}
+	rootLogger = Get().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		if c.Stdout {
+			cores = append(cores, core)
+		}
+		return zapcore.NewTee(cores...)
+	}))
+	return rootLogger, nil
+}
+
+// CloseSinks отправляет накопленные записи и закрывает получателей журнала
+func CloseSinks() error {
+	var err error
+	for _, s := range sinks {
+		if serr := s.close(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	sinks = nil
+	return err
+}
+
+// jsonEncoderConfig совпадает с настройками кодирования стандартного вывода (см. Init)
+func jsonEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:      "time",
+		MessageKey:   "message",
+		LevelKey:     "level",
+		CallerKey:    "caller",
+		EncodeTime:   zapcore.ISO8601TimeEncoder,
+		EncodeLevel:  zapcore.LowercaseLevelEncoder,
+		EncodeCaller: zapcore.ShortCallerEncoder,
+		LineEnding:   "\n",
+	}
+}
+
+// sinkCore кодирует записи и передаёт их получателю вместе с токеном сессии из поля "token"
+type sinkCore struct {
+	zapcore.LevelEnabler
+	sink    sink
+	encoder zapcore.Encoder
+	session string
+}
+
+func (c *sinkCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.encoder = c.encoder.Clone()
+	for i := range fields {
+		fields[i].AddTo(clone.encoder)
+	}
+	if session, ok := sessionToken(fields); ok {
+		clone.session = session
+	}
+	return &clone
+}
+
+func (c *sinkCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *sinkCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	session := c.session
+	if s, ok := sessionToken(fields); ok {
+		session = s
+	}
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	return c.sink.write(entry, session, bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+func (c *sinkCore) Sync() error {
+	return nil
+}
+
+func sessionToken(fields []zapcore.Field) (string, bool) {
+	for _, f := range fields {
+		if f.Key == "token" && f.Type == zapcore.StringType {
+			return f.String, true
+		}
+	}
+	return "", false
+}
+
+// sinkError сообщает об ошибке получателя журнала в stderr: записать её в журнал нельзя, она снова попала бы
+// к тому же получателю
+func sinkError(sink string, err error) {
+	_, _ = fmt.Fprintf(os.Stderr, "%s log sink: %s\n", sink, err)
+}
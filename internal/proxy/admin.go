@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const readinessCheckTimeout = 5 * time.Second
+
+// runAdminListener обслуживает служебные запросы на адресе address: метрики Prometheus (/metrics),
+// проверки работоспособности (/healthz) и готовности (/readyz) для балансировщиков нагрузки
+func (app *BastionProxy) runAdminListener(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(api.HealthOK))
+	})
+	mux.HandleFunc("/readyz", app.readyzHandler)
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	app.logger.Info("Admin listener listening", zap.String("address", address))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		app.logger.Error("Admin listener failed", zap.Error(err))
+	}
+}
+
+// readyzHandler проверяет, что прокси принимает подключения клиентов, сервер бастиона доступен и сертификат,
+// которому прокси доверяет при подключении к серверу, действителен. Если хотя бы одна проверка не пройдена,
+// возвращается 503
+func (app *BastionProxy) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	health := api.HealthDTO{Status: api.HealthOK, Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			app.logger.Warn("Readiness check failed", zap.String("check", name), zap.Error(err))
+			health.Status = api.HealthUnavailable
+			health.Checks[name] = err.Error()
+			return
+		}
+		health.Checks[name] = api.HealthOK
+	}
+	var err error
+	if !app.listening.Load() {
+		err = errors.New("not accepting connections")
+	}
+	check("listener", err)
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+	check("api", app.apiClient.CheckServer(ctx))
+	check("certificate", checkCertificateFile(app.config.API.CertificateFile, time.Now()))
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status != api.HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(health)
+}
+
+// checkCertificateFile проверяет, что все сертификаты в файле PEM действительны в момент now.
+// Пустое имя файла означает, что используются только системные корневые сертификаты
+func checkCertificateFile(fileName string, now time.Time) error {
+	if fileName == "" {
+		return nil
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("certificate '%s' is not valid before %s", cert.Subject, cert.NotBefore.Format(time.RFC3339))
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("certificate '%s' expired at %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("no certificates found in %s", fileName)
+	}
+	return nil
+}
@@ -2,17 +2,33 @@ package proxy
 
 import (
 	"bastion/internal/api/client"
+	"bastion/internal/auth"
 	"bastion/internal/log"
+	"bastion/internal/tracing"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/gliderlabs/ssh"
 	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type BastionProxy struct {
-	config    ConfigStruct
-	logger    *zap.Logger
-	apiClient client.APIClient
+	config      ConfigStruct
+	logger      *zap.Logger
+	apiClient   client.APIClient
+	certChecker *gossh.CertChecker
+	oidcClient  *auth.OIDCClient // Используется для входа пользователей через Device Authorization Grant
+
+	secretPrompts []*regexp.Regexp // Приглашения ввода пароля, после которых ввод пользователя маскируется в журнале
+	reporter      *sessionReporter
+	listening     atomic.Bool // Прокси принимает подключения клиентов
+	sessions      sessionRegistry
 }
 
 func New() (*BastionProxy, error) {
@@ -23,6 +39,32 @@ func New() (*BastionProxy, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error initializing logging: %s", err)
 	}
+	proxy.logger, err = log.InitSinks(log.SinkConfig{
+		Stdout:        proxy.config.Log.Stdout,
+		Files:         log.FileSinkConfig(proxy.config.Log.Files),
+		Syslog:        log.SyslogSinkConfig(proxy.config.Log.Syslog),
+		Elasticsearch: log.ElasticsearchSinkConfig(proxy.config.Log.Elasticsearch),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error initializing log sinks: %s", err)
+	}
+	proxy.logger, err = log.InitAuditChain(log.AuditChainConfig{
+		File:               proxy.config.AuditLog.File,
+		SigningKeyFile:     proxy.config.AuditLog.SigningKeyFile,
+		CheckpointInterval: proxy.config.AuditLog.CheckpointInterval,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error initializing audit log: %s", err)
+	}
+	err = tracing.Init("bastion-proxy", tracing.Config{
+		Endpoint:    proxy.config.Tracing.Endpoint,
+		Insecure:    proxy.config.Tracing.Insecure,
+		SampleRatio: proxy.config.Tracing.SampleRatio,
+	})
+	if err != nil {
+		proxy.logger.Error(err.Error())
+		return nil, err
+	}
 	c := client.APIClientConfig{
 		Endpoint:         proxy.config.API.URL,
 		CertificateFile:  proxy.config.API.CertificateFile,
@@ -36,15 +78,77 @@ func New() (*BastionProxy, error) {
 		proxy.logger.Error(err.Error())
 		return nil, err
 	}
+	proxy.reporter, err = newSessionReporter(proxy.logger, proxy.apiClient.ReportSessionEvent, proxy.config.Reporting.SpoolDir)
+	if err != nil {
+		proxy.logger.Error(err.Error())
+		return nil, err
+	}
+	proxy.secretPrompts, err = compileSecretPrompts(proxy.config.SecretMasking.Mode, proxy.config.SecretMasking.PromptPatterns)
+	if err != nil {
+		proxy.logger.Error(err.Error())
+		return nil, err
+	}
+	if proxy.config.SSHCA.PublicKeyFile != "" {
+		proxy.certChecker, err = newCertChecker(proxy.config.SSHCA.PublicKeyFile)
+		if err != nil {
+			proxy.logger.Error(err.Error())
+			return nil, err
+		}
+		proxy.logger.Info("SSH certificate authentication enabled")
+	}
+	if proxy.config.OIDC.DeviceFlow {
+		proxy.oidcClient, err = auth.New(proxy.config.OIDC.Issuer, proxy.config.OIDC.ClientID, proxy.config.OIDC.ClientSecret, "", []string{"profile"})
+		if err != nil {
+			proxy.logger.Error(err.Error())
+			return nil, err
+		}
+		proxy.logger.Info("Device authorization grant login enabled")
+	}
 	return &proxy, nil
 }
 
 func (app *BastionProxy) Run() {
+	if app.config.Admin.BindAddress != "" {
+		go app.runAdminListener(app.config.Admin.BindAddress)
+	}
+	server := &ssh.Server{Addr: app.config.BindAddress, Handler: app.SessionHandler}
+	for _, option := range []ssh.Option{ssh.WrapConn(app.ConnCallback), app.AuthOption(), app.ForwardingOption(), app.SubsystemOption()} {
+		if err := server.SetOption(option); err != nil {
+			app.logger.Fatal(err.Error())
+		}
+	}
+	listener, err := net.Listen("tcp", app.config.BindAddress)
+	if err != nil {
+		app.logger.Fatal(err.Error())
+	}
+	app.listening.Store(true)
 	app.logger.Info("Bastion proxy listening", zap.String("address", app.config.BindAddress))
-	app.logger.Fatal(ssh.ListenAndServe(app.config.BindAddress, app.SessionHandler, ssh.WrapConn(app.ConnCallback)).Error())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-served:
+		app.listening.Store(false)
+		app.logger.Fatal(err.Error())
+	case sig := <-signals:
+		// Повторный сигнал завершает процесс немедленно
+		signal.Stop(signals)
+		app.logger.Info("Shutting down", zap.String("signal", sig.String()))
+		app.shutdown(server)
+	}
 }
 
 func (app *BastionProxy) Shutdown() {
 	app.logger.Info("Shutdown")
+	if err := tracing.Shutdown(); err != nil {
+		app.logger.Error(err.Error())
+	}
+	if err := log.CloseAuditChain(); err != nil {
+		app.logger.Error(err.Error())
+	}
 	_ = app.logger.Sync()
+	if err := log.CloseSinks(); err != nil {
+		fmt.Println(err)
+	}
 }
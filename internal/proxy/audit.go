@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/log"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// sessionAudit записывает события аудита сессии и накапливает сведения для события её завершения.
+// О подключении к цели, ошибке и завершении сессии также сообщается серверу для истории сессий
+type sessionAudit struct {
+	logger      *zap.Logger
+	reporter    *sessionReporter
+	token       string
+	event       log.AuditEvent // Данные клиента и цели, общие для всех событий сессии
+	bytesIn     int64          // Изменяется атомарно
+	bytesOut    int64          // Изменяется атомарно
+	createdAt   time.Time      // Начало подключения к цели для метрики времени подключения
+	connected   bool
+	connectedAt time.Time
+	exitStatus  *int
+	shutdown    *atomic.Bool // Сессии закрываются прокси при остановке
+}
+
+func (app *BastionProxy) newSessionAudit(logger *zap.Logger, clientSession ssh.Session, clientAddress string, session api.ReadSessionDTO) *sessionAudit {
+	e := log.AuditEvent{
+		ClientIP:       clientAddress,
+		TargetNetwork:  session.TargetNetwork,
+		TargetProtocol: session.TargetProtocol,
+		TargetHost:     session.TargetHost,
+		TargetPort:     session.TargetPort,
+	}
+	if userSID, authMethod, ok := authenticatedUser(clientSession); ok {
+		e.User, e.AuthMethod = userSID, authMethod
+	}
+	if e.User == "" {
+		e.User = session.UserName
+	}
+	e.MandateID = session.MandateID
+	return &sessionAudit{logger: logger, reporter: app.reporter, token: session.Token, event: e, createdAt: time.Now(),
+		shutdown: &app.sessions.closing}
+}
+
+// countIn возвращает читатель потока клиента, учитывающий переданные цели байты
+func (a *sessionAudit) countIn(r io.Reader) io.Reader {
+	return countingReader{r: r, n: &a.bytesIn, m: bytesRelayed.WithLabelValues("in", a.event.TargetProtocol)}
+}
+
+// countOut возвращает писатель в поток клиента, учитывающий полученные от цели байты
+func (a *sessionAudit) countOut(w io.Writer) io.Writer {
+	return countingWriter{w: w, n: &a.bytesOut, m: bytesRelayed.WithLabelValues("out", a.event.TargetProtocol)}
+}
+
+// targetConnected отмечает, что соединение с целью (включая автоматический вход) установлено
+func (a *sessionAudit) targetConnected() {
+	a.connected = true
+	a.connectedAt = time.Now()
+	targetConnectDuration.WithLabelValues(a.event.TargetNetwork, a.event.TargetProtocol).Observe(a.connectedAt.Sub(a.createdAt).Seconds())
+	activeSessions.WithLabelValues(a.event.TargetNetwork, a.event.TargetProtocol).Inc()
+	e := a.event
+	e.Event = log.AuditTargetConnected
+	log.Audit(a.logger, e)
+	a.reporter.report(api.SessionEventDTO{Token: a.token, Event: api.SessionEventStarted, OccurredAt: a.connectedAt.Unix()})
+}
+
+func (a *sessionAudit) windowResized(win ssh.Window) {
+	e := a.event
+	e.Event = log.AuditWindowResized
+	e.Width, e.Height = win.Width, win.Height
+	log.Audit(a.logger, e)
+}
+
+// commandFiltered записывает запрет или подтверждение команды фильтром команд мандата
+func (a *sessionAudit) commandFiltered(event, command, rule, reason string) {
+	e := a.event
+	e.Event = event
+	e.Command, e.Rule, e.Reason = command, rule, reason
+	log.Audit(a.logger, e)
+}
+
+// authFailed записывает отказ в доступе к сессии, выявленный после аутентификации клиента
+func (a *sessionAudit) authFailed(err error) {
+	e := a.event
+	e.Event = log.AuditAuthFailed
+	e.Error = err.Error()
+	log.Audit(a.logger, e)
+}
+
+// sessionEnded записывает событие неудачного подключения к цели или завершения сессии
+func (a *sessionAudit) sessionEnded(err error) {
+	e := a.event
+	if !a.connected {
+		e.Event = log.AuditTargetConnectFailed
+		e.Reason = "target connect failed"
+		if err != nil {
+			e.Error = err.Error()
+		}
+		targetConnectFailures.WithLabelValues(a.event.TargetNetwork, a.event.TargetProtocol).Inc()
+	} else {
+		activeSessions.WithLabelValues(a.event.TargetNetwork, a.event.TargetProtocol).Dec()
+		e.Event = log.AuditSessionEnded
+		e.Reason = "closed"
+		if a.shutdown != nil && a.shutdown.Load() {
+			e.Reason = "proxy shutdown"
+		}
+		if err != nil {
+			e.Reason = "error"
+			e.Error = err.Error()
+		}
+		e.BytesIn = atomic.LoadInt64(&a.bytesIn)
+		e.BytesOut = atomic.LoadInt64(&a.bytesOut)
+		e.Duration = time.Since(a.connectedAt)
+		e.ExitStatus = a.exitStatus
+	}
+	log.Audit(a.logger, e)
+
+	now := time.Now().Unix()
+	if e.Error != "" {
+		a.reporter.report(api.SessionEventDTO{Token: a.token, Event: api.SessionEventError, OccurredAt: now, Error: e.Error})
+	}
+	a.reporter.report(api.SessionEventDTO{
+		Token:      a.token,
+		Event:      api.SessionEventEnded,
+		OccurredAt: now,
+		Reason:     e.Reason,
+		BytesIn:    e.BytesIn,
+		BytesOut:   e.BytesOut,
+		DurationMs: e.Duration.Milliseconds(),
+		ExitStatus: e.ExitStatus,
+	})
+}
+
+// auditAuthFailed записывает событие отказа в аутентификации клиента прокси
+func auditAuthFailed(logger *zap.Logger, clientAddress, user, authMethod string, err error) {
+	log.Audit(logger, log.AuditEvent{
+		Event:      log.AuditAuthFailed,
+		User:       user,
+		AuthMethod: authMethod,
+		ClientIP:   clientAddress,
+		Error:      err.Error(),
+	})
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+	m prometheus.Counter
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	c.m.Add(float64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+	m prometheus.Counter
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	c.m.Add(float64(n))
+	return n, err
+}
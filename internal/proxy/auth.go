@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/auth"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func (app *BastionProxy) checkUserPublicKey(ctx context.Context, user string, key gossh.PublicKey) (*gossh.Permissions, error) {
+	login, mandateID, _, _, err := auth.ParseDirectTarget(user)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+	defer cancel()
+	u, err := app.apiClient.AuthenticatePublicKey(ctx, login, strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key))))
+	if err != nil {
+		return nil, err
+	}
+	return &gossh.Permissions{
+		Extensions: map[string]string{
+			userSIDExtension:    u.Name,
+			authMethodExtension: api.AuthMethodPublicKey,
+			mandateIDExtension:  strconv.Itoa(mandateID),
+		},
+	}, nil
+}
+
+// sessionToken возвращает одноразовый токен сессии: из преамбулы соединения, если клиент её отправил,
+// иначе имя пользователя SSH
+func sessionToken(ctx ssh.Context) string {
+	if token, ok := ctx.Value(tokenContextKey).(string); ok {
+		return token
+	}
+	return ctx.User()
+}
+
+// resolveSession получает у сервера данные сессии: по одноразовому токену (из преамбулы или имени пользователя SSH) либо, при прямом
+// подключении, создавая сессию от имени аутентифицированного пользователя. Возвращает логгер, дополненный
+// сведениями о пользователе
+func (app *BastionProxy) resolveSession(ctx context.Context, clientSession ssh.Session, logger *zap.Logger, clientAddress string) (api.ReadSessionDTO, *zap.Logger, error) {
+	if userSID, authMethod, ok := authenticatedUser(clientSession); ok {
+		logger = logger.With(zap.String("user_sid", userSID), zap.String("auth_method", authMethod))
+		session, err := app.directSession(ctx, clientSession, logger, clientAddress, userSID, authMethod)
+		return session, logger, err
+	}
+	if auth.IsDirectTarget(clientSession.User()) {
+		logger = logger.With(zap.String("auth_method", api.AuthMethodDeviceCode))
+		userSID, err := app.deviceCodeLogin(clientSession, logger)
+		if err != nil {
+			return api.ReadSessionDTO{}, logger, err
+		}
+		logger = logger.With(zap.String("user_sid", userSID))
+		session, err := app.directSession(ctx, clientSession, logger, clientAddress, userSID, api.AuthMethodDeviceCode)
+		return session, logger, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+	defer cancel()
+	start := time.Now()
+	session, err := app.apiClient.GetSession(ctx, sessionToken(clientSession.Context()))
+	observeTokenLookup(tokenLookupToken, start, err)
+	return session, logger, err
+}
+
+// readSecret выводит приглашение и читает из терминала клиента строку без эха
+func readSecret(rw io.ReadWriter, prompt string) (string, error) {
+	if _, err := io.WriteString(rw, prompt); err != nil {
+		return "", err
+	}
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := rw.Read(b); err != nil {
+			return "", err
+		}
+		switch b[0] {
+		case '\r', '\n':
+			_, err := io.WriteString(rw, "\r\n")
+			return string(line), err
+		case 0x03, 0x04: // Ctrl+C, Ctrl+D
+			return "", errors.New("input interrupted by user")
+		case 0x7f, 0x08: // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			line = append(line, b[0])
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/api/client"
+	"bastion/internal/auth"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Ключи расширений ssh.Permissions, через которые результат аутентификации передаётся в SessionHandler.
+// Permissions попадают в соединение только после успешной проверки подписи клиента
+const (
+	userSIDExtension    = "bastion-user-sid"
+	authMethodExtension = "bastion-auth-method"
+	mandateIDExtension  = "bastion-mandate-id"
+)
+
+const maxMFAAttempts = 3
+
+func newCertChecker(caPublicKeyFile string) (*gossh.CertChecker, error) {
+	data, err := os.ReadFile(caPublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	caKey, _, _, _, err := gossh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return &gossh.CertChecker{
+		IsUserAuthority: func(key gossh.PublicKey) bool {
+			return bytes.Equal(key.Marshal(), caKey.Marshal())
+		},
+	}, nil
+}
+
+// AuthOption включает аутентификацию клиентов по SSH-сертификатам, выпущенным сервером бастиона (если задан
+// открытый ключ УЦ), и по зарегистрированным на сервере открытым ключам пользователей (прямое подключение).
+// Прямое подключение без зарегистрированного ключа возможно при включённом Device Authorization Grant.
+// Клиенты, подключающиеся по одноразовому токену, по-прежнему допускаются через keyboard-interactive
+// без запроса каких-либо данных
+func (app *BastionProxy) AuthOption() ssh.Option {
+	return func(srv *ssh.Server) error {
+		srv.ServerConfigCallback = func(ctx ssh.Context) *gossh.ServerConfig {
+			return &gossh.ServerConfig{PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+				return app.publicKeyCallback(ctx, conn, key)
+			}}
+		}
+		srv.KeyboardInteractiveHandler = func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+			// Прямое подключение без ключа допускается, только если пользователь может подтвердить личность
+			// через Device Authorization Grant (выполняется уже в рамках сессии, см. deviceCodeLogin)
+			return !auth.IsDirectTarget(ctx.User()) || app.oidcClient != nil
+		}
+		return nil
+	}
+}
+
+func (app *BastionProxy) publicKeyCallback(ctx ssh.Context, conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+	var permissions *gossh.Permissions
+	var err error
+	authMethod := api.AuthMethodPublicKey
+	if cert, ok := key.(*gossh.Certificate); ok {
+		authMethod = api.AuthMethodCertificate
+		permissions, err = app.checkCertificate(conn.User(), cert)
+	} else if auth.IsDirectTarget(conn.User()) {
+		permissions, err = app.checkUserPublicKey(ctx, conn.User(), key)
+	} else {
+		return nil, errors.New("public key authentication is not applicable")
+	}
+	if err != nil {
+		clientAddress := clientIP(conn.RemoteAddr())
+		auditAuthFailed(app.logger, clientAddress, conn.User(), authMethod, err)
+	}
+	return permissions, err
+}
+
+func (app *BastionProxy) checkCertificate(user string, cert *gossh.Certificate) (*gossh.Permissions, error) {
+	if app.certChecker == nil {
+		return nil, errors.New("certificate authentication is disabled")
+	}
+	if cert.CertType != gossh.UserCert {
+		return nil, errors.New("not a user certificate")
+	}
+	// CheckCert не проверяет подписавший УЦ, а сертификат без принципалов считает действительным для любого
+	if !app.certChecker.IsUserAuthority(cert.SignatureKey) {
+		return nil, errors.New("certificate signed by unknown authority")
+	}
+	if len(cert.ValidPrincipals) == 0 {
+		return nil, errors.New("certificate has no principals")
+	}
+	mandateID, _, _, err := auth.ParseCertTarget(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.certChecker.CheckCert(auth.MandatePrincipal(mandateID), cert); err != nil {
+		return nil, err
+	}
+	return &gossh.Permissions{
+		Extensions: map[string]string{
+			userSIDExtension:    cert.KeyId,
+			authMethodExtension: api.AuthMethodCertificate,
+			mandateIDExtension:  strconv.Itoa(mandateID),
+		},
+	}, nil
+}
+
+// authenticatedUser возвращает SID и способ аутентификации пользователя, если клиент аутентифицирован
+// непосредственно на прокси (а не предъявил одноразовый токен)
+func authenticatedUser(clientSession ssh.Session) (string, string, bool) {
+	conn, ok := clientSession.Context().Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok || conn.Permissions == nil {
+		return "", "", false
+	}
+	sid, ok := conn.Permissions.Extensions[userSIDExtension]
+	return sid, conn.Permissions.Extensions[authMethodExtension], ok
+}
+
+// directSession запрашивает у сервера сессию к цели, указанной в имени пользователя SSH. Если мандат требует
+// второй фактор, одноразовый код запрашивается у пользователя в терминале
+func (app *BastionProxy) directSession(ctx context.Context, clientSession ssh.Session, logger *zap.Logger, clientAddress, userSID, authMethod string) (api.ReadSessionDTO, error) {
+	var mandateID, port int
+	var host string
+	var err error
+	if authMethod == api.AuthMethodCertificate {
+		mandateID, host, port, err = auth.ParseCertTarget(clientSession.User())
+	} else {
+		_, mandateID, host, port, err = auth.ParseDirectTarget(clientSession.User())
+	}
+	if err != nil {
+		return api.ReadSessionDTO{}, err
+	}
+	req := api.CreateDirectSessionDTO{
+		OriginIP:   clientAddress,
+		UserName:   userSID,
+		AuthMethod: authMethod,
+		MandateID:  mandateID,
+		TargetHost: host,
+		TargetPort: port,
+	}
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		requestCtx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+		session, err := app.apiClient.CreateDirectSession(requestCtx, req)
+		cancel()
+		observeTokenLookup(tokenLookupDirect, start, err)
+		if !errors.Is(err, client.ErrSecondFactorRequired) || attempt == maxMFAAttempts {
+			return session, err
+		}
+		logger.Info("Second factor requested", zap.Int("attempt", attempt+1))
+		req.MFACode, err = readSecret(clientSession, "Мандат требует второй фактор. Одноразовый код: ")
+		if err != nil {
+			return session, err
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"bastion/internal/auth"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) gossh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestCheckCertificate(t *testing.T) {
+	ca, foreignCA, user := newTestSigner(t), newTestSigner(t), newTestSigner(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pub")
+	if err := os.WriteFile(caFile, gossh.MarshalAuthorizedKey(ca.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	checker, err := newCertChecker(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &BastionProxy{certChecker: checker}
+
+	issue := func(signer gossh.Signer, principals []string) *gossh.Certificate {
+		cert := &gossh.Certificate{
+			Key:             user.PublicKey(),
+			CertType:        gossh.UserCert,
+			KeyId:           "S-1-5-21-1",
+			ValidPrincipals: principals,
+			ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	principals := []string{auth.MandatePrincipal(7)}
+	tests := []struct {
+		name  string
+		cert  *gossh.Certificate
+		valid bool
+	}{
+		{"bastion CA", issue(ca, principals), true},
+		{"foreign CA", issue(foreignCA, principals), false},
+		{"no principals", issue(ca, nil), false},
+		{"other mandate", issue(ca, []string{auth.MandatePrincipal(8)}), false},
+	}
+	for _, tt := range tests {
+		permissions, err := app.checkCertificate("7+host.example.com", tt.cert)
+		if tt.valid && (err != nil || permissions.Extensions[userSIDExtension] != "S-1-5-21-1") {
+			t.Errorf("%s: certificate rejected: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: certificate accepted", tt.name)
+		}
+	}
+}
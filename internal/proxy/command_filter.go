@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/log"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	keyCtrlA     = 0x01
+	keyCtrlB     = 0x02
+	keyCtrlC     = 0x03
+	keyCtrlE     = 0x05
+	keyCtrlF     = 0x06
+	keyBackspace = 0x08
+	keyTab       = 0x09
+	keyLF        = 0x0a
+	keyCR        = 0x0d
+	keyCtrlN     = 0x0e
+	keyCtrlP     = 0x10
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+)
+
+type commandRule struct {
+	action  string
+	pattern string
+	re      *regexp.Regexp
+}
+
+// commandFilter восстанавливает командную строку из нажатий клавиш, передаваемых от клиента к цели, и при нажатии
+// Enter проверяет её по правилам мандата. Нажатия передаются цели сразу, задерживается только Enter: если команда
+// запрещена, вместо него цели отправляется очистка строки (Ctrl-U) и пустая команда, а клиенту - сообщение.
+//
+// Строку, изменённую клавишами редактирования (стрелки, история, автодополнение, вставка удалённого текста и любые
+// другие управляющие клавиши, кроме обрабатываемых фильтром), восстановить по нажатиям невозможно, поэтому при наличии правил такая команда не выполняется: её нужно набрать полностью.
+//
+// Ввод после приглашения ввода пароля (см. secretMasker) не является командой: он передаётся цели без проверки
+// и не записывается в журнал
+type commandFilter struct {
+	target    io.Writer
+	client    io.Writer
+	logger    *zap.Logger
+	audit     *sessionAudit
+	masker    *secretMasker
+	rules     []commandRule
+	allowlist bool // Есть правила allow: команды, не совпавшие ни с одним правилом, запрещены
+
+	mu             sync.Mutex
+	line           []byte
+	uncertain      bool
+	escState       int
+	lastCR         bool
+	confirming     string // Команда, ожидающая подтверждения пользователем, и совпавшее с ней правило
+	confirmingRule string
+}
+
+func newCommandFilter(rules []api.CommandRule, target, client io.Writer, logger *zap.Logger, audit *sessionAudit, masker *secretMasker) (*commandFilter, error) {
+	f := &commandFilter{target: target, client: client, logger: logger, audit: audit, masker: masker}
+	for _, r := range rules {
+		switch r.Action {
+		case api.CommandActionAllow:
+			f.allowlist = true
+		case api.CommandActionDeny, api.CommandActionConfirm:
+		default:
+			return nil, fmt.Errorf("unknown command rule action '%s'", r.Action)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("malformed command rule pattern: %w", err)
+		}
+		f.rules = append(f.rules, commandRule{action: r.Action, pattern: r.Pattern, re: re})
+	}
+	return f, nil
+}
+
+// Write принимает нажатия клавиш клиента и передаёт их цели. Возвращает len(p), если цель приняла данные
+func (f *commandFilter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []byte
+	flush := func() error {
+		if len(out) == 0 {
+			return nil
+		}
+		_, err := f.target.Write(out)
+		out = out[:0]
+		return err
+	}
+	for _, b := range p {
+		if f.confirming != "" {
+			if (b == keyLF || b == 0) && f.lastCR { // Окончание нажатия Enter, вызвавшего запрос подтверждения
+				f.lastCR = false
+				continue
+			}
+			if err := f.confirm(b == 'y' || b == 'Y'); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		isCR := f.lastCR
+		f.lastCR = b == keyCR
+		if f.escState > 0 {
+			out = append(out, b)
+			f.escape(b)
+			continue
+		}
+		switch {
+		case b == keyCR || b == keyLF:
+			if b == keyLF && isCR { // CR LF от клиента - одно нажатие Enter
+				out = append(out, b)
+				continue
+			}
+			if err := flush(); err != nil {
+				return 0, err
+			}
+			if err := f.submit(b); err != nil {
+				return 0, err
+			}
+		case b == keyEscape:
+			f.escState = 1
+			f.uncertain = true
+			out = append(out, b)
+		case b == keyBackspace || b == keyDelete:
+			if len(f.line) > 0 {
+				_, size := utf8.DecodeLastRune(f.line)
+				f.line = f.line[:len(f.line)-size]
+			}
+			out = append(out, b)
+		case b == keyCtrlU || b == keyCtrlC:
+			f.reset()
+			out = append(out, b)
+		case b == keyCtrlW:
+			trimmed := strings.TrimRight(string(f.line), " ")
+			f.line = f.line[:strings.LastIndex(trimmed, " ")+1]
+			out = append(out, b)
+		case b == keyTab || b == keyCtrlA || b == keyCtrlB || b == keyCtrlE || b == keyCtrlF || b == keyCtrlN || b == keyCtrlP:
+			f.uncertain = true
+			out = append(out, b)
+		case b == 0 && isCR: // CR NUL от клиента - одно нажатие Enter
+			out = append(out, b)
+		case b < 0x20: // Прочие управляющие клавиши (Ctrl-Y, Ctrl-R, Ctrl-K, ...) могут изменить строку на цели
+			f.uncertain = true
+			out = append(out, b)
+		default:
+			f.line = append(f.line, b)
+			out = append(out, b)
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// escape пропускает escape-последовательность клавиши (ESC [ ... финальный байт или ESC O x)
+func (f *commandFilter) escape(b byte) {
+	switch f.escState {
+	case 1:
+		if b == '[' || b == 'O' {
+			f.escState = 2
+		} else {
+			f.escState = 0
+		}
+	case 2:
+		if b >= 0x40 && b <= 0x7e {
+			f.escState = 0
+		}
+	}
+}
+
+func (f *commandFilter) reset() {
+	f.line = f.line[:0]
+	f.uncertain = false
+}
+
+// submit проверяет восстановленную команду и либо передаёт цели нажатие Enter, либо отменяет команду
+func (f *commandFilter) submit(enter byte) error {
+	command := strings.TrimSpace(string(f.line))
+	uncertain := f.uncertain
+	f.reset()
+	if f.masker.secretEntered() || command == "" && !uncertain {
+		_, err := f.target.Write([]byte{enter})
+		return err
+	}
+	if uncertain && len(f.rules) > 0 {
+		f.audit.commandFiltered(log.AuditCommandBlocked, command, "", "line edited")
+		return f.cancel("\r\nКоманда изменена клавишами редактирования, наберите её полностью\r\n")
+	}
+	action, rule := f.evaluate(command)
+	switch action {
+	case api.CommandActionDeny:
+		reason := "denied by rule"
+		if rule == "" {
+			reason = "no matching allow rule"
+		}
+		f.audit.commandFiltered(log.AuditCommandBlocked, command, rule, reason)
+		return f.cancel("\r\nКоманда запрещена политикой мандата\r\n")
+	case api.CommandActionConfirm:
+		f.logger.Info("Command requires confirmation", zap.String("command", command), zap.String("rule", rule))
+		f.confirming, f.confirmingRule = command, rule
+		_, err := io.WriteString(f.client, "\r\nКоманда требует подтверждения. Выполнить? (y/N) ")
+		return err
+	}
+	_, err := f.target.Write([]byte{enter})
+	return err
+}
+
+// evaluate возвращает действие первого совпавшего правила и его шаблон
+func (f *commandFilter) evaluate(command string) (string, string) {
+	for _, r := range f.rules {
+		if r.re.MatchString(command) {
+			return r.action, r.pattern
+		}
+	}
+	if f.allowlist {
+		return api.CommandActionDeny, ""
+	}
+	return api.CommandActionAllow, ""
+}
+
+func (f *commandFilter) confirm(confirmed bool) error {
+	command, rule := f.confirming, f.confirmingRule
+	f.confirming, f.confirmingRule = "", ""
+	if !confirmed {
+		f.audit.commandFiltered(log.AuditCommandBlocked, command, rule, "not confirmed")
+		return f.cancel("n\r\n")
+	}
+	f.audit.commandFiltered(log.AuditCommandConfirmed, command, rule, "")
+	if _, err := io.WriteString(f.client, "y\r\n"); err != nil {
+		return err
+	}
+	_, err := f.target.Write([]byte{keyCR})
+	return err
+}
+
+// cancel очищает набранную на цели строку, отправляет пустую команду, чтобы цель вывела приглашение,
+// и сообщает пользователю причину
+func (f *commandFilter) cancel(message string) error {
+	if _, err := io.WriteString(f.client, message); err != nil {
+		return err
+	}
+	_, err := f.target.Write([]byte{keyCtrlU, keyCR})
+	return err
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/log"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCommandFilter(t *testing.T) {
+	rules := []api.CommandRule{{Action: api.CommandActionDeny, Pattern: `^reload\b`}}
+	tests := []struct {
+		name    string
+		input   string
+		target  string
+		blocked bool
+	}{
+		{name: "allowed", input: "show version\r", target: "show version\r"},
+		{name: "denied", input: "reload\r", target: "reload\x15\r", blocked: true},
+		{name: "crlf", input: "show clock\r\nshow users\r\n", target: "show clock\r\nshow users\r\n"},
+		{name: "cr nul", input: "show clock\r\x00show users\r", target: "show clock\r\x00show users\r"},
+		{name: "ctrl-w ctrl-y", input: "reload\x17\x19\r", target: "reload\x17\x19\x15\r", blocked: true},
+		{name: "ctrl-u ctrl-y", input: "reload\x15\x19\r", target: "reload\x15\x19\x15\r", blocked: true},
+		{name: "ctrl-r", input: "\x12rel\r", target: "\x12rel\x15\r", blocked: true},
+		{name: "ctrl-k", input: "reload\x01\x0b\r", target: "reload\x01\x0b\x15\r", blocked: true},
+		{name: "ctrl-v", input: "re\x16load\r", target: "re\x16load\x15\r", blocked: true},
+		{name: "edited then cleared", input: "rel\x19\x15show clock\r", target: "rel\x19\x15show clock\r"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target, client bytes.Buffer
+			f, err := newCommandFilter(rules, &target, &client, zap.NewNop(), &sessionAudit{logger: zap.NewNop()}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < len(tt.input); i++ { // Нажатия приходят по одному байту
+				if _, err := f.Write([]byte{tt.input[i]}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if target.String() != tt.target {
+				t.Errorf("target got %q, want %q", target.String(), tt.target)
+			}
+			if blocked := client.Len() > 0; blocked != tt.blocked {
+				t.Errorf("blocked = %v, want %v (client got %q)", blocked, tt.blocked, client.String())
+			}
+		})
+	}
+}
+
+func TestCommandFilterSplitWrite(t *testing.T) {
+	var target, client bytes.Buffer
+	f, err := newCommandFilter([]api.CommandRule{{Action: api.CommandActionDeny, Pattern: `^reload\b`}}, &target, &client, zap.NewNop(), &sessionAudit{logger: zap.NewNop()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"rel", "oad\x17", "\x19\r"} {
+		if _, err := f.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.HasSuffix(target.String(), "\x15\r") {
+		t.Errorf("command was not blocked, target got %q", target.String())
+	}
+}
+
+// TestCommandFilterSecretPrompt проверяет, что пароль, набранный после приглашения, не проверяется правилами
+// и не попадает в журнал, а следующая команда проверяется как обычно
+func TestCommandFilterSecretPrompt(t *testing.T) {
+	prompts, err := compileSecretPrompts(secretMaskingPrompt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masker := &secretMasker{prompts: prompts}
+	core, logs := observer.New(zap.DebugLevel)
+	var target, client bytes.Buffer
+	logger := zap.New(core)
+	f, err := newCommandFilter([]api.CommandRule{{Action: api.CommandActionAllow, Pattern: `^sudo\b`}}, &target, &client, logger,
+		&sessionAudit{logger: logger}, masker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(input string) { // Как в connectStreams: ввод сначала маскируется для журнала, затем передаётся фильтру
+		masker.maskInput([]byte(input))
+		if _, err := f.Write([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("sudo -i\r")
+	masker.observeOutput([]byte("[sudo] password for admin: "))
+	write("s3cr3t\r")
+	write("reboot\r")
+	if want := "sudo -i\rs3cr3t\rreboot\x15\r"; target.String() != want {
+		t.Errorf("target got %q, want %q", target.String(), want)
+	}
+	for _, entry := range logs.All() {
+		if strings.Contains(fmt.Sprint(entry.ContextMap()), "s3cr3t") {
+			t.Errorf("password logged: %v", entry.ContextMap())
+		}
+	}
+	blocked := logs.FilterMessage("Audit: " + log.AuditCommandBlocked).All()
+	if len(blocked) != 1 || !strings.Contains(fmt.Sprint(blocked[0].ContextMap()), "command:reboot") {
+		t.Errorf("blocked commands %v, want only reboot", blocked)
+	}
+}
+
+func TestCommandFilterAudit(t *testing.T) {
+	rules := []api.CommandRule{
+		{Action: api.CommandActionDeny, Pattern: `^reload\b`},
+		{Action: api.CommandActionConfirm, Pattern: `^write\b`},
+	}
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+	audit := &sessionAudit{logger: logger, event: log.AuditEvent{User: "S-1-5-21-1", MandateID: 7}}
+	var target, client bytes.Buffer
+	f, err := newCommandFilter(rules, &target, &client, logger, audit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("reload\rwrite memory\ry")); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"schema_version": log.AuditSchemaVersion, "event": log.AuditCommandBlocked, "user": "S-1-5-21-1", "mandate_id": 7,
+			"command": "reload", "rule": `^reload\b`, "reason": "denied by rule"},
+		{"schema_version": log.AuditSchemaVersion, "event": log.AuditCommandConfirmed, "user": "S-1-5-21-1", "mandate_id": 7,
+			"command": "write memory", "rule": `^write\b`},
+	}
+	var got []map[string]interface{}
+	for _, entry := range logs.FilterMessageSnippet("Audit: ").All() {
+		got = append(got, entry.ContextMap()["audit"].(map[string]interface{}))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("audit events %v, want %v", got, want)
+	}
+}
@@ -9,7 +9,30 @@ import (
 
 type ConfigStruct struct {
 	Log struct {
-		Level string `yaml:"level"`
+		Level  string `yaml:"level"`
+		Stdout bool   `yaml:"stdout"`
+		Files  struct {
+			Dir        string `yaml:"dir"` // Запись в файлы (отдельный файл для каждой сессии) выключена, если пусто
+			MaxSizeMB  int    `yaml:"maxSizeMB"`
+			MaxBackups int    `yaml:"maxBackups"`
+		}
+		Syslog struct {
+			Network    string `yaml:"network"` // udp, tcp или tls
+			Address    string `yaml:"address"` // Отправка в syslog выключена, если пусто
+			Facility   int    `yaml:"facility"`
+			AppName    string `yaml:"appName"`
+			CACertFile string `yaml:"caCertFile"`
+		}
+		Elasticsearch struct {
+			URL              string `yaml:"url"` // Отправка в Elasticsearch выключена, если пусто
+			Index            string `yaml:"index"`
+			Username         string `yaml:"username"`
+			Password         string `yaml:"password"`
+			BatchSize        int    `yaml:"batchSize"`
+			FlushIntervalSec int    `yaml:"flushIntervalSec"`
+			QueueSize        int    `yaml:"queueSize"`
+			BlockTimeoutMs   int    `yaml:"blockTimeoutMs"`
+		}
 	}
 	API struct {
 		URL             string `yaml:"url"`
@@ -19,10 +42,39 @@ type ConfigStruct struct {
 		Issuer       string `yaml:"issuer"`
 		ClientID     string `yaml:"clientID"`
 		ClientSecret string `yaml:"clientSecret"`
+		DeviceFlow   bool   `yaml:"deviceFlow"`
+	}
+	SSHCA struct {
+		PublicKeyFile string `yaml:"publicKeyFile"`
+	}
+	SecretMasking struct {
+		Mode           string   `yaml:"mode"`           // prompt - маскировать в журнале ввод после приглашения ввода пароля, off - не маскировать
+		PromptPatterns []string `yaml:"promptPatterns"` // Регулярные выражения приглашений ввода пароля
+	}
+	ScreenCapture struct {
+		Enabled     bool `yaml:"enabled"`
+		IntervalSec int  `yaml:"intervalSec"`
+	}
+	Reporting struct {
+		SpoolDir string `yaml:"spoolDir"` // Каталог для событий сессий, которые не удалось сообщить серверу
+	}
+	AuditLog struct {
+		File               string `yaml:"file"`
+		SigningKeyFile     string `yaml:"signingKeyFile"`
+		CheckpointInterval int    `yaml:"checkpointInterval"`
+	}
+	Tracing struct {
+		Endpoint    string  `yaml:"endpoint"` // Адрес коллектора OTLP/HTTP host:port, трассировка выключена, если пусто
+		Insecure    bool    `yaml:"insecure"`
+		SampleRatio float64 `yaml:"sampleRatio"`
 	}
-	BindAddress       string `yaml:"bindAddress"`
-	GuardedNetwork    string `yaml:"guardedNetwork"`
-	ConnectTimeoutSec int    `yaml:"connectTimeout"`
+	Admin struct {
+		BindAddress string `yaml:"bindAddress"` // Адрес для /metrics, /healthz и /readyz, выключено, если пусто
+	}
+	BindAddress        string `yaml:"bindAddress"`
+	GuardedNetwork     string `yaml:"guardedNetwork"`
+	ConnectTimeoutSec  int    `yaml:"connectTimeoutSec"`
+	ShutdownTimeoutSec int    `yaml:"shutdownTimeoutSec"` // Ожидание завершения сессий при остановке прокси
 }
 
 func LoadConfiguration() ConfigStruct {
@@ -34,6 +86,23 @@ func LoadConfiguration() ConfigStruct {
 	pflag.BoolVar(&needHelp, "help", false, "Show available configuration options")
 
 	pflag.StringVar(&config.Log.Level, "log-level", "info", "Logging level")
+	pflag.BoolVar(&config.Log.Stdout, "log-stdout", true, "Write log to standard output")
+	pflag.StringVar(&config.Log.Files.Dir, "log-files-dir", "", "Directory for log files, one file per session (disabled if empty)")
+	pflag.IntVar(&config.Log.Files.MaxSizeMB, "log-files-max-size", 100, "Size of log file that triggers rotation, megabytes")
+	pflag.IntVar(&config.Log.Files.MaxBackups, "log-files-max-backups", 5, "Number of rotated log files to keep")
+	pflag.StringVar(&config.Log.Syslog.Network, "log-syslog-network", "udp", "Transport for syslog messages: udp, tcp, tls")
+	pflag.StringVar(&config.Log.Syslog.Address, "log-syslog-address", "", "Syslog server address host:port (disabled if empty)")
+	pflag.IntVar(&config.Log.Syslog.Facility, "log-syslog-facility", 16, "Syslog facility code (16 is local0)")
+	pflag.StringVar(&config.Log.Syslog.AppName, "log-syslog-app-name", "bastion-proxy", "APP-NAME of syslog messages")
+	pflag.StringVar(&config.Log.Syslog.CACertFile, "log-syslog-ca-cert", "", "Certificate file trusted when connecting to syslog server over TLS")
+	pflag.StringVar(&config.Log.Elasticsearch.URL, "log-es-url", "", "Elasticsearch URL (disabled if empty)")
+	pflag.StringVar(&config.Log.Elasticsearch.Index, "log-es-index", "bastion", "Elasticsearch index name prefix, date is appended")
+	pflag.StringVar(&config.Log.Elasticsearch.Username, "log-es-username", "", "Elasticsearch user name")
+	pflag.StringVar(&config.Log.Elasticsearch.Password, "log-es-password", "", "Elasticsearch password")
+	pflag.IntVar(&config.Log.Elasticsearch.BatchSize, "log-es-batch-size", 500, "Maximum number of records in Elasticsearch bulk request")
+	pflag.IntVar(&config.Log.Elasticsearch.FlushIntervalSec, "log-es-flush-interval", 5, "Interval between Elasticsearch bulk requests, seconds")
+	pflag.IntVar(&config.Log.Elasticsearch.QueueSize, "log-es-queue-size", 10000, "Number of records queued for Elasticsearch")
+	pflag.IntVar(&config.Log.Elasticsearch.BlockTimeoutMs, "log-es-block-timeout", 1000, "Time to wait for space in full Elasticsearch queue before dropping record, milliseconds")
 
 	pflag.StringVar(&config.API.URL, "api-url", "", "Bastion server URL (mandatory)")
 	pflag.StringVar(&config.API.CertificateFile, "api-cert", "", "Certificate file name used for connection to Bastion server (mandatory)")
@@ -41,10 +110,32 @@ func LoadConfiguration() ConfigStruct {
 	pflag.StringVar(&config.OIDC.Issuer, "oidc-issuer", "", "OIDC authorization server URL  (mandatory)")
 	pflag.StringVar(&config.OIDC.ClientID, "oidc-client-id", "", "OIDC client ID  (mandatory)")
 	pflag.StringVar(&config.OIDC.ClientSecret, "oidc-client-secret", "", "OIDC client secret  (mandatory)")
+	pflag.BoolVar(&config.OIDC.DeviceFlow, "oidc-device-flow", false, "Allow direct connections authenticated via OAuth device authorization grant (default false)")
+
+	pflag.StringVar(&config.SSHCA.PublicKeyFile, "ssh-ca-pub-key", "", "Public key of Bastion SSH certificate authority (certificate authentication disabled if empty)")
+
+	pflag.StringVar(&config.SecretMasking.Mode, "secret-masking", secretMaskingPrompt, "Masking of secrets typed by users in session logs: prompt, off")
+	pflag.StringSliceVar(&config.SecretMasking.PromptPatterns, "secret-prompt", nil, "Regular expression of a password prompt in target output (built-in patterns are used if none given)")
+
+	pflag.BoolVar(&config.ScreenCapture.Enabled, "screen-capture", false, "Log terminal screen snapshots of SSH and Telnet sessions (useful for full-screen applications)")
+	pflag.IntVar(&config.ScreenCapture.IntervalSec, "screen-capture-interval", 5, "Interval between terminal screen snapshots, seconds")
+
+	pflag.StringVar(&config.Reporting.SpoolDir, "report-spool-dir", "spool", "Directory for session events not yet delivered to Bastion server")
+
+	pflag.StringVar(&config.AuditLog.File, "audit-log", "", "Tamper-evident hash-chained audit log file (disabled if empty)")
+	pflag.StringVar(&config.AuditLog.SigningKeyFile, "audit-signing-key", "", "Ed25519 private key (PEM) to sign audit log checkpoints with (checkpoints disabled if empty)")
+	pflag.IntVar(&config.AuditLog.CheckpointInterval, "audit-checkpoint-interval", 100, "Number of audit log records between signed checkpoints")
+
+	pflag.StringVar(&config.Tracing.Endpoint, "tracing-endpoint", "", "OTLP/HTTP collector address host:port to export traces to (tracing disabled if empty)")
+	pflag.BoolVar(&config.Tracing.Insecure, "tracing-insecure", false, "Export traces over plain HTTP instead of HTTPS")
+	pflag.Float64Var(&config.Tracing.SampleRatio, "tracing-sample-ratio", 1, "Fraction of traces started by this service to record, 0..1")
+
+	pflag.StringVar(&config.Admin.BindAddress, "admin-address", "", "The IP address and port on which to serve /metrics, /healthz and /readyz (disabled if empty)")
 
 	pflag.StringVar(&config.BindAddress, "bind-address", "0.0.0.0:2200", "The IP address and port on which to listen for HTTPS requests")
 	pflag.StringVar(&config.GuardedNetwork, "network", "", "Network this proxy serves (mandatory)")
 	pflag.IntVar(&config.ConnectTimeoutSec, "connect-timeout", 5, "Timeout connecting to target hosts, seconds")
+	pflag.IntVar(&config.ShutdownTimeoutSec, "shutdown-timeout", 60, "Time to wait for active sessions to finish on SIGTERM or SIGINT before closing them, seconds")
 
 	pflag.Parse()
 	err := viper.BindPFlags(pflag.CommandLine)
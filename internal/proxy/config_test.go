@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestSampleConfiguration проверяет, что каждому параметру примера конфигурации соответствует поле ConfigStruct:
+// viper сопоставляет параметры с полями по именам полей, а не по тегам yaml, и молча пропускает остальные
+func TestSampleConfiguration(t *testing.T) {
+	v := viper.New()
+	v.SetConfigFile("../../configs/bastion-proxy.yml")
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	var config ConfigStruct
+	if err := v.UnmarshalExact(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.ShutdownTimeoutSec != 60 || config.ScreenCapture.IntervalSec != 5 ||
+		config.Log.Elasticsearch.FlushIntervalSec != 5 || config.Log.Elasticsearch.BlockTimeoutMs != 1000 {
+		t.Errorf("sample configuration values not applied: %+v", config)
+	}
+}
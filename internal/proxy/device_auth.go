@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"bastion/internal/auth"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	"go.uber.org/zap"
+)
+
+// deviceCodeLogin подтверждает личность пользователя, подключившегося напрямую без ключа, через Device Authorization
+// Grant: выводит в терминал адрес и код для входа в браузере на любом устройстве и ожидает завершения входа.
+// Возвращает SID пользователя
+func (app *BastionProxy) deviceCodeLogin(clientSession ssh.Session, logger *zap.Logger) (string, error) {
+	if app.oidcClient == nil {
+		return "", errors.New("device authorization grant is disabled")
+	}
+	login, _, _, _, err := auth.ParseDirectTarget(clientSession.User())
+	if err != nil {
+		return "", err
+	}
+	ctx := clientSession.Context()
+	da, err := app.oidcClient.StartDeviceAuthorization(ctx)
+	if err != nil {
+		return "", err
+	}
+	logger.Info("Device authorization started", zap.String("user_login", login))
+	prompt := fmt.Sprintf("Для входа откройте в браузере %s и введите код %s\r\n", da.VerificationURI, da.UserCode)
+	if da.VerificationURIComplete != "" {
+		prompt += fmt.Sprintf("или перейдите по ссылке %s\r\n", da.VerificationURIComplete)
+	}
+	if _, err := io.WriteString(clientSession, prompt+"Ожидание подтверждения входа...\r\n"); err != nil {
+		return "", err
+	}
+
+	token, err := app.oidcClient.PollDeviceToken(ctx, da)
+	if err != nil {
+		return "", err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return "", errors.New("no id_token in device token response")
+	}
+	idToken, err := app.oidcClient.VerifyIDToken(rawIDToken)
+	if err != nil {
+		return "", err
+	}
+	var claims auth.UserClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return "", err
+	}
+	// Вход подтверждён, но другим пользователем: не подменяем запрошенную учётную запись
+	if !strings.EqualFold(strings.SplitN(claims.UPN, "@", 2)[0], login) {
+		return "", fmt.Errorf("authenticated user '%s' does not match requested login '%s'", claims.UPN, login)
+	}
+	return claims.SID, nil
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/log"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// directTCPIPData - данные запроса на открытие канала direct-tcpip (RFC 4254, раздел 7.2)
+type directTCPIPData struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// ForwardingOption включает проброс TCP-портов (каналы direct-tcpip, ssh -L). Проброс доступен только
+// пользователям, аутентифицированным на прокси по сертификату или открытому ключу: мандат берётся из имени
+// пользователя SSH, а список разрешённых целей выдаёт сервер
+func (app *BastionProxy) ForwardingOption() ssh.Option {
+	return func(srv *ssh.Server) error {
+		srv.ChannelHandlers = map[string]ssh.ChannelHandler{
+			"session":      ssh.DefaultSessionHandler,
+			"direct-tcpip": app.directTCPIPHandler,
+		}
+		return nil
+	}
+}
+
+func (app *BastionProxy) directTCPIPHandler(_ *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+	clientAddress := clientIP(conn.RemoteAddr())
+	logger := log.Get().With(zap.String("client", clientAddress), zap.String("channel", "direct-tcpip"))
+	var d directTCPIPData
+	if err := gossh.Unmarshal(newChan.ExtraData(), &d); err != nil {
+		logger.Warn("Malformed direct-tcpip request", zap.String("error", err.Error()))
+		_ = newChan.Reject(gossh.ConnectionFailed, "error parsing forward data")
+		return
+	}
+	target := net.JoinHostPort(d.DestAddr, strconv.Itoa(int(d.DestPort)))
+	logger = logger.With(zap.String("target", target))
+	if !app.sessions.accepting() {
+		logger.Info("Port forwarding refused: proxy is shutting down")
+		_ = newChan.Reject(gossh.ResourceShortage, "proxy is shutting down")
+		return
+	}
+
+	if conn.Permissions == nil || conn.Permissions.Extensions[userSIDExtension] == "" {
+		logger.Warn("Port forwarding denied: user is not authenticated on proxy")
+		_ = newChan.Reject(gossh.Prohibited, "port forwarding requires certificate or public key authentication")
+		return
+	}
+	userSID := conn.Permissions.Extensions[userSIDExtension]
+	authMethod := conn.Permissions.Extensions[authMethodExtension]
+	mandateID, err := strconv.Atoi(conn.Permissions.Extensions[mandateIDExtension])
+	if err != nil {
+		logger.Error(err.Error())
+		_ = newChan.Reject(gossh.Prohibited, "port forwarding is not permitted")
+		return
+	}
+	logger = logger.With(zap.String("user_sid", userSID), zap.String("auth_method", authMethod), zap.Int("mandate_id", mandateID))
+
+	policyCtx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+	policy, err := app.apiClient.ForwardingPolicy(policyCtx, api.ForwardingPolicyRequestDTO{
+		UserName:   userSID,
+		AuthMethod: authMethod,
+		MandateID:  mandateID,
+	})
+	cancel()
+	if err != nil {
+		logger.Warn("Port forwarding denied", zap.String("error", err.Error()))
+		_ = newChan.Reject(gossh.Prohibited, "port forwarding is not permitted")
+		return
+	}
+	if !strings.EqualFold(policy.TargetNetwork, app.config.GuardedNetwork) {
+		logger.Warn("Port forwarding denied: wrong target network", zap.String("target_network", policy.TargetNetwork))
+		_ = newChan.Reject(gossh.Prohibited, "port forwarding is not permitted")
+		return
+	}
+	if !forwardingAllowed(policy.Targets, target) {
+		logger.Warn("Port forwarding denied: target is not in mandate allowlist")
+		_ = newChan.Reject(gossh.Prohibited, "port forwarding to "+target+" is not permitted")
+		return
+	}
+
+	dialer := net.Dialer{Timeout: time.Second * time.Duration(app.config.ConnectTimeoutSec)}
+	targetConn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		logger.Error("Error connecting to forwarding target", zap.String("error", err.Error()))
+		_ = newChan.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		logger.Error(err.Error())
+		_ = targetConn.Close()
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+	forwarded := &forwardedChannel{Channel: ch, target: targetConn}
+	if !app.sessions.add(forwarded, false) { // Прокси начал останавливаться после проверки выше
+		logger.Info("Port forwarding refused: proxy is shutting down")
+		_ = forwarded.Close()
+		return
+	}
+	logger.Info("Port forwarding started", zap.String("origin", net.JoinHostPort(d.OriginAddr, strconv.Itoa(int(d.OriginPort)))))
+
+	go func() {
+		started := time.Now()
+		var sent, received int64
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sent, _ = io.Copy(targetConn, ch)
+			if tcpConn, ok := targetConn.(*net.TCPConn); ok {
+				_ = tcpConn.CloseWrite()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			received, _ = io.Copy(ch, targetConn)
+			_ = ch.CloseWrite()
+		}()
+		wg.Wait()
+		_ = forwarded.Close()
+		app.sessions.remove(forwarded)
+		logger.Info("Port forwarding finished",
+			zap.Int64("bytes_sent", sent),
+			zap.Int64("bytes_received", received),
+			zap.Duration("duration", time.Since(started)))
+	}()
+}
+
+// forwardedChannel - канал проброса порта вместе с соединением с целью. Закрытие канала при остановке прокси
+// закрывает и соединение, иначе копирование данных от цели продолжалось бы, пока цель не закроет соединение
+type forwardedChannel struct {
+	gossh.Channel
+	target net.Conn
+}
+
+func (c *forwardedChannel) Close() error {
+	err := c.Channel.Close()
+	if terr := c.target.Close(); err == nil {
+		err = terr
+	}
+	return err
+}
+
+func forwardingAllowed(allowed []string, target string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(a, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	expect "github.com/google/goexpect"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCommandPrompt = `[>#$%]\s*$`
+	defaultEnablePrompt  = `assword:\s*$`
+	maskedSecret         = "<BASTION-WAS-HERE>"
+)
+
+// Профиль, соответствующий прежнему поведению, используется для Telnet, если на сервере профиль не задан
+var defaultTelnetLoginProfile = api.LoginProfile{
+	Name:           "built-in",
+	UsernamePrompt: "ogin: ",
+	PasswordPrompt: "assword: ",
+	TimeoutSec:     5,
+}
+
+// loginStep - шаг сценария входа: дождаться приглашения prompt и отправить send
+type loginStep struct {
+	name   string
+	prompt string
+	send   string
+}
+
+// loginSteps формирует сценарий входа по профилю. Шаги ввода имени и пароля включаются, только если
+// withCredentials (для SSH аутентификация выполняется протоколом), переход в привилегированный режим -
+// только если задан пароль enablePassword
+func loginSteps(p api.LoginProfile, login, password, enablePassword string, withCredentials bool) []loginStep {
+	commandPrompt := p.CommandPrompt
+	if commandPrompt == "" {
+		commandPrompt = defaultCommandPrompt
+	}
+	var steps []loginStep
+	if withCredentials {
+		if p.UsernamePrompt != "" {
+			steps = append(steps, loginStep{name: "username", prompt: p.UsernamePrompt, send: login})
+		}
+		steps = append(steps, loginStep{name: "password", prompt: p.PasswordPrompt, send: password})
+	}
+	if p.EnableCommand != "" && enablePassword != "" {
+		enablePrompt := p.EnablePrompt
+		if enablePrompt == "" {
+			enablePrompt = defaultEnablePrompt
+		}
+		steps = append(steps,
+			loginStep{name: "enable", prompt: commandPrompt, send: p.EnableCommand},
+			loginStep{name: "enable password", prompt: enablePrompt, send: enablePassword})
+	}
+	for _, c := range p.PostLoginCommands {
+		steps = append(steps, loginStep{name: "post-login command", prompt: commandPrompt, send: c})
+	}
+	return steps
+}
+
+// runLoginScript выполняет сценарий входа: ожидает приглашения в потоке out и отправляет ответы в in.
+// После последнего шага сценарий ещё раз ожидает приглашения командной строки (или признака неудачного входа),
+// чтобы вывод устройства не потерялся, после чего out переключается на чтение сессией.
+// Возвращает вывод устройства, полученный за время выполнения сценария, в котором значения secrets заменены
+// маской. Ошибка возвращается при истечении времени ожидания или совпадении с одним из признаков неудачного входа
+func runLoginScript(in io.WriteCloser, out *streamTap, logger *zap.Logger, profile api.LoginProfile, steps []loginStep, secrets ...string) (string, error) {
+	defer out.detach()
+	var capturedOutput strings.Builder
+	mask := func() string {
+		result := capturedOutput.String()
+		for _, s := range secrets {
+			if s != "" {
+				result = strings.ReplaceAll(result, s, maskedSecret)
+			}
+		}
+		return result
+	}
+	if len(steps) == 0 {
+		return "", nil
+	}
+	timeout := time.Second * time.Duration(profile.TimeoutSec)
+	if timeout <= 0 {
+		timeout = time.Second * time.Duration(defaultTelnetLoginProfile.TimeoutSec)
+	}
+	failureCases := make([]expect.Caser, 0, len(profile.FailurePatterns))
+	for _, f := range profile.FailurePatterns {
+		re, err := regexp.Compile(f)
+		if err != nil {
+			return "", fmt.Errorf("login profile '%s': malformed failure pattern: %w", profile.Name, err)
+		}
+		failureCases = append(failureCases, &expect.Case{R: re, T: expect.OK()})
+	}
+	commandPrompt := profile.CommandPrompt
+	if commandPrompt == "" {
+		commandPrompt = defaultCommandPrompt
+	}
+	// Финальное ожидание без отправки: неудачный вход после ввода пароля определяется по признакам отказа
+	steps = append(steps, loginStep{name: "command", prompt: commandPrompt})
+
+	expecting := true
+	resCh := make(chan error)
+	opts := &expect.GenOptions{
+		In:  in,
+		Out: out.scriptReader(),
+		Wait: func() error {
+			return <-resCh
+		},
+		Close: func() error {
+			close(resCh)
+			return nil
+		},
+		Check: func() bool {
+			return expecting
+		},
+	}
+	expector, _, err := expect.SpawnGeneric(opts, -1)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		expecting = false
+		_ = expector.Close()
+	}()
+
+	for i, step := range steps {
+		last := i == len(steps)-1
+		re, err := regexp.Compile(step.prompt)
+		if err != nil {
+			return mask(), fmt.Errorf("login profile '%s': malformed %s prompt: %w", profile.Name, step.name, err)
+		}
+		// Признаки неудачного входа проверяются раньше приглашения: после отказа устройство обычно
+		// сразу повторяет запрос имени пользователя
+		cases := append(append([]expect.Caser{}, failureCases...), &expect.Case{R: re, T: expect.OK()})
+		output, match, idx, err := expector.ExpectSwitchCase(cases, timeout)
+		capturedOutput.WriteString(output)
+		if err != nil {
+			if last { // Приглашение устройства может не соответствовать шаблону, это не ошибка входа
+				logger.Debug("Command prompt was not recognized", zap.String("profile", profile.Name))
+				return mask(), nil
+			}
+			return mask(), fmt.Errorf("waiting for %s prompt: %w", step.name, err)
+		}
+		if idx < len(failureCases) {
+			return mask(), errors.New("login failed: " + strings.TrimSpace(match[0]))
+		}
+		if last {
+			break
+		}
+		logger.Debug("Login step", zap.String("step", step.name), zap.String("profile", profile.Name))
+		if err := expector.Send(step.send + "\n"); err != nil {
+			return mask(), err
+		}
+	}
+	return mask(), nil
+}
+
+// streamTap читает поток цели в отдельной горутине и позволяет передать его сначала сценарию входа,
+// а затем, без потери уже прочитанных данных, сессии пользователя
+type streamTap struct {
+	chunks   chan tapChunk
+	detached chan struct{}
+	mu       sync.Mutex // Удерживается сценарием входа на время чтения
+	pending  []byte
+	err      error
+}
+
+type tapChunk struct {
+	data []byte
+	err  error
+}
+
+func newStreamTap(src io.Reader) *streamTap {
+	t := &streamTap{chunks: make(chan tapChunk), detached: make(chan struct{})}
+	go func() {
+		for {
+			buffer := make([]byte, 1024)
+			n, err := src.Read(buffer)
+			if n > 0 {
+				t.chunks <- tapChunk{data: buffer[:n]}
+			}
+			if err != nil {
+				t.chunks <- tapChunk{err: err}
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Read читает поток после завершения сценария входа
+func (t *streamTap) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 && t.err == nil {
+		c := <-t.chunks
+		t.pending, t.err = c.data, c.err
+	}
+	if len(t.pending) > 0 {
+		n := copy(p, t.pending)
+		t.pending = t.pending[n:]
+		return n, nil
+	}
+	return 0, t.err
+}
+
+// scriptReader возвращает читатель для сценария входа, который перестаёт получать данные после detach
+func (t *streamTap) scriptReader() io.Reader {
+	return tapScriptReader{t}
+}
+
+// detach завершает чтение сценарием входа; данные, полученные им после этого, возвращаются в поток
+func (t *streamTap) detach() {
+	select {
+	case <-t.detached:
+		return
+	default:
+		close(t.detached)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+}
+
+type tapScriptReader struct {
+	t *streamTap
+}
+
+func (r tapScriptReader) Read(p []byte) (int, error) {
+	t := r.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.detached:
+		return 0, io.EOF
+	default:
+	}
+	if len(t.pending) > 0 {
+		n := copy(p, t.pending)
+		t.pending = t.pending[n:]
+		return n, nil
+	}
+	select {
+	case <-t.detached:
+		return 0, io.EOF
+	case c := <-t.chunks:
+		select {
+		case <-t.detached:
+			t.pending, t.err = c.data, c.err
+			return 0, io.EOF
+		default:
+		}
+		n := copy(p, c.data)
+		t.pending = c.data[n:]
+		return n, c.err
+	}
+}
+
+// writeNopCloser позволяет передать сценарию входа поток, который не должен закрываться по его завершении
+type writeNopCloser struct {
+	io.Writer
+}
+
+func (writeNopCloser) Close() error {
+	return nil
+}
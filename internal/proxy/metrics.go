@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	activeSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bastion_proxy_active_sessions",
+		Help: "Number of sessions connected to targets",
+	}, []string{"network", "protocol"})
+	targetConnectDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bastion_proxy_target_connect_duration_seconds",
+		Help:    "Time to connect to target host including automatic login",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"network", "protocol"})
+	targetConnectFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bastion_proxy_target_connect_failures_total",
+		Help: "Number of failed connections to target hosts",
+	}, []string{"network", "protocol"})
+	bytesRelayed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bastion_proxy_bytes_relayed_total",
+		Help: "Bytes relayed between clients and targets; direction in is from client to target",
+	}, []string{"direction", "protocol"})
+	tokenLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bastion_proxy_token_lookup_duration_seconds",
+		Help:    "Time to get session from Bastion server by token or to create direct session",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "result"})
+)
+
+// Способы получения сессии у сервера (метка method)
+const (
+	tokenLookupToken  = "token"
+	tokenLookupDirect = "direct"
+)
+
+// observeTokenLookup учитывает время запроса сессии у сервера, начатого в момент start
+func observeTokenLookup(method string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	tokenLookupDuration.WithLabelValues(method, result).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"bastion/internal/log"
+	"bastion/internal/tracing"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+const netconfSubsystem = "netconf"
+
+// Операции, разрешённые мандатам только на чтение (с пространством имён операции); остальные операции, в том числе
+// блокировки, завершение чужих сессий и RPC производителей оборудования, для таких мандатов запрещены
+var netconfReadOperations = map[string]string{
+	"get":           "urn:ietf:params:xml:ns:netconf:base:1.0",
+	"get-config":    "urn:ietf:params:xml:ns:netconf:base:1.0",
+	"close-session": "urn:ietf:params:xml:ns:netconf:base:1.0",
+	"get-schema":    "urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring",
+}
+
+type netconfRPC struct {
+	messageID string
+	operation string
+	namespace string
+	datastore string
+}
+
+// readOnly возвращает true, если операция не изменяет состояние цели
+func (rpc netconfRPC) readOnly() bool {
+	namespace, ok := netconfReadOperations[rpc.operation]
+	return ok && namespace == rpc.namespace
+}
+
+// SubsystemOption включает проксирование подсистемы NETCONF
+func (app *BastionProxy) SubsystemOption() ssh.Option {
+	return func(srv *ssh.Server) error {
+		srv.SubsystemHandlers = map[string]ssh.SubsystemHandler{
+			netconfSubsystem: app.NetconfHandler,
+		}
+		return nil
+	}
+}
+
+// NetconfHandler проксирует подсистему NETCONF к цели сессии. Каждый RPC клиента разбирается и записывается
+// в журнал (операция и целевое хранилище конфигурации); для мандатов только на чтение цели передаются только операции
+// чтения, а клиент получает ответ rpc-error с тегом access-denied
+func (app *BastionProxy) NetconfHandler(clientSession ssh.Session) {
+	if !app.sessions.add(clientSession, false) {
+		_ = clientSession.Exit(1)
+		return
+	}
+	defer app.sessions.remove(clientSession)
+	clientAddress := clientIP(clientSession.RemoteAddr())
+	sessionLogger := log.Get().With(zap.String("client", clientAddress), zap.String("token", sessionToken(clientSession.Context())),
+		zap.String("subsystem", netconfSubsystem))
+
+	ctx, span := tracing.Start(clientSession.Context(), "proxy session", attribute.String("client", clientAddress),
+		attribute.String("subsystem", netconfSubsystem))
+	defer span.End()
+	sessionLogger = tracing.WithTraceID(sessionLogger, ctx)
+
+	session, sessionLogger, err := app.resolveSession(ctx, clientSession, sessionLogger, clientAddress)
+	if err != nil {
+		sessionLogger.Error("Error getting session data", zap.String("error", err.Error()))
+		app.newSessionAudit(sessionLogger, clientSession, clientAddress, session).authFailed(err)
+		_ = clientSession.Exit(1)
+		return
+	}
+	audit := app.newSessionAudit(sessionLogger, clientSession, clientAddress, session)
+	if !strings.EqualFold(session.TargetNetwork, app.config.GuardedNetwork) {
+		sessionLogger.Error("Wrong target network", zap.String("target_network", session.TargetNetwork))
+		audit.authFailed(fmt.Errorf("wrong target network '%s'", session.TargetNetwork))
+		_ = clientSession.Exit(1)
+		return
+	}
+	if !strings.EqualFold(session.TargetProtocol, "ssh") {
+		sessionLogger.Error("NETCONF requires SSH target protocol", zap.String("protocol", session.TargetProtocol))
+		audit.sessionEnded(fmt.Errorf("NETCONF requires SSH target protocol"))
+		_ = clientSession.Exit(1)
+		return
+	}
+	sessionLogger = sessionLogger.With(zap.Bool("read_only", session.ReadOnly))
+
+	targetAddress := session.TargetHost + ":" + session.TargetPort
+	_, dialSpan := startDialSpan(ctx, "ssh", targetAddress)
+	target, err := NewSSHSession(sessionLogger, targetAddress, session.TargetLogin, session.TargetPassword, session.TargetPrivKey)
+	tracing.End(dialSpan, err)
+	if err != nil {
+		tracing.SetError(span, err)
+		sessionLogger.Error("Error while connecting to target host", zap.String("error", err.Error()))
+		audit.sessionEnded(err)
+		_ = clientSession.Exit(1)
+		return
+	}
+	defer target.Close()
+	if err := target.StartSubsystem(netconfSubsystem); err != nil {
+		audit.sessionEnded(err)
+		_ = clientSession.Exit(1)
+		return
+	}
+	sessionLogger.Info("NETCONF session started", zap.String("target", targetAddress))
+	audit.targetConnected()
+
+	clientOut := &lockedWriter{w: audit.countOut(clientSession)}
+	targetBase11 := make(chan bool, 1)
+	done := make(chan bool, 2)
+	go func() { // target -> client: разбирается только hello, далее поток копируется без изменений
+		reader := newNetconfReader(target.Stdout())
+		raw, hello, err := reader.ReadMessage()
+		if _, werr := clientOut.Write(raw); err != nil || werr != nil {
+			targetBase11 <- false
+			done <- true
+			return
+		}
+		targetBase11 <- supportsBase11(hello)
+		_, _ = io.Copy(clientOut, reader.r)
+		done <- true
+	}()
+	go func() {
+		err := app.relayNetconfRPCs(sessionLogger, audit.countIn(clientSession), target.Stdin(), clientOut, targetBase11, session.ReadOnly)
+		if err != nil && !errors.Is(err, io.EOF) {
+			sessionLogger.Error(err.Error())
+		}
+		done <- true
+	}()
+	<-done
+	sessionLogger.Info("NETCONF session finished")
+	audit.sessionEnded(nil)
+	_ = clientSession.Exit(0)
+	_ = sessionLogger.Sync()
+}
+
+// relayNetconfRPCs передаёт сообщения клиента цели, записывая в журнал каждый RPC
+func (app *BastionProxy) relayNetconfRPCs(logger *zap.Logger, client io.Reader, target io.Writer, clientOut io.Writer,
+	targetBase11 <-chan bool, readOnly bool) error {
+	reader := newNetconfReader(client)
+	raw, hello, err := reader.ReadMessage()
+	if _, werr := target.Write(raw); err != nil || werr != nil {
+		if err == nil {
+			err = werr
+		}
+		return err
+	}
+	// Кадрирование NETCONF 1.1 используется после обмена hello, если обе стороны его поддерживают
+	reader.chunked = supportsBase11(hello) && <-targetBase11
+	logger.Info("NETCONF hello exchanged", zap.Bool("chunked_framing", reader.chunked))
+	for {
+		raw, payload, err := reader.ReadMessage()
+		if err != nil {
+			return err
+		}
+		rpc, err := parseNetconfRPC(payload)
+		if err != nil {
+			logger.Warn("Unable to parse NETCONF message", zap.String("error", err.Error()))
+			if readOnly {
+				return fmt.Errorf("unparsable NETCONF message in read-only session: %w", err)
+			}
+		}
+		denied := readOnly && !rpc.readOnly()
+		logger.Info("NETCONF RPC",
+			zap.String("message_id", rpc.messageID),
+			zap.String("operation", rpc.operation),
+			zap.String("datastore", rpc.datastore),
+			zap.Bool("denied", denied))
+		if denied {
+			reply := netconfAccessDenied(rpc)
+			if _, err := clientOut.Write(netconfFrame(reply, reader.chunked)); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := target.Write(raw); err != nil {
+			return err
+		}
+	}
+}
+
+// parseNetconfRPC извлекает из сообщения <rpc> идентификатор, имя и пространство имён операции и целевое хранилище конфигурации
+// (элемент <target>, а при его отсутствии <source>)
+func parseNetconfRPC(payload []byte) (netconfRPC, error) {
+	var rpc netconfRPC
+	var source, section string
+	decoder := xml.NewDecoder(bytes.NewReader(payload))
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return rpc, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 1:
+				if t.Name.Local != "rpc" {
+					return rpc, fmt.Errorf("unexpected NETCONF message <%s>", t.Name.Local)
+				}
+				for _, a := range t.Attr {
+					if a.Name.Local == "message-id" {
+						rpc.messageID = a.Value
+					}
+				}
+			case 2:
+				if rpc.operation == "" {
+					rpc.operation = t.Name.Local
+					rpc.namespace = t.Name.Space
+				}
+			case 3:
+				section = t.Name.Local
+			case 4:
+				if section == "target" && rpc.datastore == "" {
+					rpc.datastore = t.Name.Local
+				}
+				if section == "source" && source == "" {
+					source = t.Name.Local
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if rpc.operation == "" {
+		return rpc, errors.New("no operation in NETCONF RPC")
+	}
+	if rpc.datastore == "" {
+		rpc.datastore = source
+	}
+	return rpc, nil
+}
+
+func netconfAccessDenied(rpc netconfRPC) []byte {
+	var messageID bytes.Buffer
+	_ = xml.EscapeText(&messageID, []byte(rpc.messageID))
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>`+
+		`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="%s">`+
+		`<rpc-error><error-type>protocol</error-type><error-tag>access-denied</error-tag>`+
+		`<error-severity>error</error-severity>`+
+		`<error-message>Operation %s is not permitted by read-only mandate</error-message>`+
+		`</rpc-error></rpc-reply>`, messageID.String(), rpc.operation))
+}
+
+// lockedWriter позволяет писать в поток клиента из нескольких горутин
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
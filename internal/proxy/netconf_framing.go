@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Кадрирование сообщений NETCONF поверх SSH (RFC 6242): в NETCONF 1.0 сообщения завершаются последовательностью
+// "]]>]]>", в NETCONF 1.1 (если обе стороны объявили base:1.1 в hello) передаются блоками "\n#<длина>\n<данные>",
+// завершающимися "\n##\n"
+const (
+	netconfEOM             = "]]>]]>"
+	netconfBase11          = "urn:ietf:params:netconf:base:1.1"
+	netconfMaxMessageBytes = 64 * 1024 * 1024
+)
+
+var errNetconfFraming = errors.New("malformed NETCONF framing")
+
+type netconfReader struct {
+	r       *bufio.Reader
+	chunked bool
+}
+
+func newNetconfReader(r io.Reader) *netconfReader {
+	return &netconfReader{r: bufio.NewReader(r)}
+}
+
+// ReadMessage читает очередное сообщение и возвращает его вместе с кадрированием (для пересылки без изменений)
+// и отдельно содержимое (XML-документ)
+func (n *netconfReader) ReadMessage() ([]byte, []byte, error) {
+	if n.chunked {
+		return n.readChunked()
+	}
+	return n.readEOM()
+}
+
+func (n *netconfReader) readEOM() ([]byte, []byte, error) {
+	var raw []byte
+	for {
+		part, err := n.r.ReadSlice('>')
+		raw = append(raw, part...)
+		if len(raw) > netconfMaxMessageBytes {
+			return raw, nil, errNetconfFraming
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return raw, nil, err
+		}
+		if bytes.HasSuffix(raw, []byte(netconfEOM)) {
+			return raw, raw[:len(raw)-len(netconfEOM)], nil
+		}
+	}
+}
+
+func (n *netconfReader) readChunked() ([]byte, []byte, error) {
+	var raw, payload []byte
+	for {
+		header, err := n.r.ReadSlice('\n')
+		raw = append(raw, header...)
+		if err != nil {
+			return raw, nil, err
+		}
+		if len(header) == 1 && len(raw) == 1 { // перевод строки, начинающий заголовок блока
+			header, err = n.r.ReadSlice('\n')
+			raw = append(raw, header...)
+			if err != nil {
+				return raw, nil, err
+			}
+		}
+		switch {
+		case string(header) == "##\n":
+			return raw, payload, nil
+		case len(header) > 2 && header[0] == '#':
+			size, err := strconv.ParseUint(string(header[1:len(header)-1]), 10, 32)
+			if err != nil || size == 0 || len(payload)+int(size) > netconfMaxMessageBytes {
+				return raw, nil, errNetconfFraming
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(n.r, chunk); err != nil {
+				return raw, nil, err
+			}
+			raw = append(raw, chunk...)
+			payload = append(payload, chunk...)
+			// Следующий заголовок должен начинаться с перевода строки
+			b, err := n.r.ReadByte()
+			if err != nil {
+				return raw, nil, err
+			}
+			raw = append(raw, b)
+			if b != '\n' {
+				return raw, nil, errNetconfFraming
+			}
+		default:
+			return raw, nil, errNetconfFraming
+		}
+	}
+}
+
+// netconfFrame кадрирует сообщение для отправки
+func netconfFrame(payload []byte, chunked bool) []byte {
+	if chunked {
+		return []byte(fmt.Sprintf("\n#%d\n%s\n##\n", len(payload), payload))
+	}
+	return append(append([]byte{}, payload...), netconfEOM...)
+}
+
+// supportsBase11 проверяет, объявлена ли в сообщении hello поддержка NETCONF 1.1
+func supportsBase11(hello []byte) bool {
+	var h struct {
+		Capabilities []string `xml:"capabilities>capability"`
+	}
+	if err := xml.Unmarshal(hello, &h); err != nil {
+		return false
+	}
+	for _, c := range h.Capabilities {
+		if strings.TrimSpace(c) == netconfBase11 {
+			return true
+		}
+	}
+	return false
+}
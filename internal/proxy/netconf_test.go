@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const netconfTestHello = `<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><capabilities>` +
+	`<capability>urn:ietf:params:netconf:base:1.0</capability></capabilities></hello>` + netconfEOM
+
+func netconfTestRPC(id, operation string) string {
+	return `<rpc xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="` + id + `">` + operation + `</rpc>` + netconfEOM
+}
+
+func TestRelayNetconfRPCsReadOnly(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		allowed   bool
+	}{
+		{"get", `<get/>`, true},
+		{"get-config", `<get-config><source><running/></source></get-config>`, true},
+		{"get-schema", `<get-schema xmlns="urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring"><identifier>ietf-interfaces</identifier></get-schema>`, true},
+		{"close-session", `<close-session/>`, true},
+		{"edit-config", `<edit-config><target><running/></target><config/></edit-config>`, false},
+		{"lock", `<lock><target><candidate/></target></lock>`, false},
+		{"kill-session", `<kill-session><session-id>4</session-id></kill-session>`, false},
+		{"vendor RPC", `<load-configuration xmlns="http://xml.juniper.net/junos/*/junos"><configuration/></load-configuration>`, false},
+		{"vendor get", `<get xmlns="http://example.com/vendor"><command>reload</command></get>`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rpc := netconfTestRPC("1", tt.operation)
+			var target, clientOut bytes.Buffer
+			targetBase11 := make(chan bool, 1)
+			targetBase11 <- false
+			app := &BastionProxy{}
+			err := app.relayNetconfRPCs(zap.NewNop(), strings.NewReader(netconfTestHello+rpc), &target, &clientOut, targetBase11, true)
+			if !errors.Is(err, io.EOF) {
+				t.Fatal(err)
+			}
+			forwarded := strings.TrimPrefix(target.String(), netconfTestHello)
+			denied := strings.Contains(clientOut.String(), "<error-tag>access-denied</error-tag>")
+			if tt.allowed && (forwarded != rpc || denied) {
+				t.Errorf("read operation was not forwarded to target")
+			}
+			if !tt.allowed && (forwarded != "" || !denied) {
+				t.Errorf("operation forwarded to target %q, reply %q", forwarded, clientOut.String())
+			}
+		})
+	}
+}
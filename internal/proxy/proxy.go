@@ -1,32 +1,55 @@
 package proxy
 
 import (
+	"bastion/internal/api"
+	"bastion/internal/auth"
 	"bastion/internal/log"
+	"bastion/internal/tracing"
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
-	"regexp"
 	"strings"
 	"time"
 
 	"github.com/gliderlabs/ssh"
-	expect "github.com/google/goexpect"
 	"github.com/plyul/telnet"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+const (
+	// apiRequestTimeout ограничивает время запроса к серверу бастиона, чтобы зависший сервер не удерживал сессии
+	apiRequestTimeout = 15 * time.Second
+	// maxTokenPrefaceLength ограничивает длину преамбулы с токеном сессии вместе с переводом строки
+	maxTokenPrefaceLength = 256
+)
+
+// tokenContextKey - ключ контекста соединения, под которым хранится токен сессии из преамбулы
+var tokenContextKey = &struct{ name string }{"session token"}
+
 type proxySessionData struct {
-	logger         *zap.Logger
-	pty            ssh.Pty
-	winCh          <-chan ssh.Window
-	clientStdin    io.Writer
-	clientStdout   io.Reader
-	clientStderr   io.ReadWriter
-	env            []string
-	targetAddress  string
-	targetLogin    string
-	targetPassword string
-	targetPrivKey  string
+	ctx                  context.Context // Контекст трассировки сессии
+	logger               *zap.Logger
+	pty                  ssh.Pty
+	winCh                <-chan ssh.Window
+	clientStdin          io.Writer
+	clientStdout         io.Reader
+	clientStderr         io.ReadWriter
+	env                  []string
+	targetAddress        string
+	targetLogin          string
+	targetPassword       string
+	targetPrivKey        string
+	targetEnablePassword string
+	serial               *api.SerialSettings
+	loginProfile         *api.LoginProfile
+	commandRules         []api.CommandRule
+	masker               *secretMasker
+	audit                *sessionAudit
 }
 
 // /--------\ stdout -> R /--------\ W ->  stdin /--------\
@@ -35,24 +58,36 @@ type proxySessionData struct {
 //
 //	stderr <- W(!)      (!)R <- stderr
 func (app *BastionProxy) SessionHandler(clientSession ssh.Session) {
-	clientAddress := strings.Split(clientSession.RemoteAddr().String(), ":")[0]
-	token := clientSession.User()
+	if !app.sessions.add(clientSession, true) {
+		_, _ = io.WriteString(clientSession, "Бастион перезапускается, подключитесь позже\n")
+		return
+	}
+	defer app.sessions.remove(clientSession)
+	clientAddress := clientIP(clientSession.RemoteAddr())
+	token := sessionToken(clientSession.Context())
 
-	sessionLogger := log.Get().With(zap.String("client", clientAddress), zap.String("token", token))
+	ctx, span := tracing.Start(clientSession.Context(), "proxy session", attribute.String("client", clientAddress))
+	defer span.End()
+	sessionLogger := tracing.WithTraceID(log.Get().With(zap.String("client", clientAddress), zap.String("token", token)), ctx)
 	ptyReq, winCh, isPty := clientSession.Pty()
 	if !isPty {
 		sessionLogger.Error("Client has not requested PTY, will not serve request")
 		return
 	}
 
-	session, err := app.apiClient.GetSession(token)
+	session, sessionLogger, err := app.resolveSession(ctx, clientSession, sessionLogger, clientAddress)
 	if err != nil {
 		sessionLogger.Error("Error getting session data", zap.String("error", err.Error()))
+		tracing.SetError(span, err)
+		app.newSessionAudit(sessionLogger, clientSession, clientAddress, session).authFailed(err)
 		return
 	}
 
 	if !strings.EqualFold(session.TargetNetwork, app.config.GuardedNetwork) {
 		sessionLogger.Error("Wrong target network", zap.String("target_network", session.TargetNetwork))
+		err = fmt.Errorf("wrong target network '%s'", session.TargetNetwork)
+		tracing.SetError(span, err)
+		app.newSessionAudit(sessionLogger, clientSession, clientAddress, session).authFailed(err)
 		return
 	}
 
@@ -61,18 +96,27 @@ func (app *BastionProxy) SessionHandler(clientSession ssh.Session) {
 		sessionLogger.Error(err.Error())
 		return
 	}
+	span.SetAttributes(attribute.String("target_network", session.TargetNetwork), attribute.String("target_protocol", session.TargetProtocol))
+	audit := app.newSessionAudit(sessionLogger, clientSession, clientAddress, session)
 	data := proxySessionData{
-		logger:         sessionLogger,
-		pty:            ptyReq,
-		winCh:          winCh,
-		clientStdin:    clientSession,
-		clientStdout:   clientSession,
-		clientStderr:   clientSession.Stderr(),
-		env:            clientSession.Environ(),
-		targetAddress:  session.TargetHost + ":" + session.TargetPort,
-		targetLogin:    session.TargetLogin,
-		targetPassword: session.TargetPassword,
-		targetPrivKey:  session.TargetPrivKey,
+		ctx:                  ctx,
+		logger:               sessionLogger,
+		pty:                  ptyReq,
+		winCh:                winCh,
+		clientStdin:          audit.countOut(clientSession),
+		clientStdout:         audit.countIn(clientSession),
+		clientStderr:         clientSession.Stderr(),
+		env:                  clientSession.Environ(),
+		targetAddress:        session.TargetHost + ":" + session.TargetPort,
+		targetLogin:          session.TargetLogin,
+		targetPassword:       session.TargetPassword,
+		targetPrivKey:        session.TargetPrivKey,
+		serial:               session.Serial,
+		loginProfile:         session.LoginProfile,
+		targetEnablePassword: session.TargetEnablePassword,
+		commandRules:         session.CommandRules,
+		masker:               app.newSecretMasker(),
+		audit:                audit,
 	}
 
 	switch strings.ToLower(session.TargetProtocol) {
@@ -82,75 +126,222 @@ func (app *BastionProxy) SessionHandler(clientSession ssh.Session) {
 	case "telnet":
 		sessionLogger.Debug("Establishing Telnet session to target host")
 		err = app.proxyToTelnet(data)
+	case "raw":
+		sessionLogger.Debug("Establishing raw TCP session to target host")
+		err = app.proxyToRaw(data)
+	case "rfc2217":
+		sessionLogger.Debug("Establishing RFC 2217 serial port session to target host")
+		err = app.proxyToSerial(data)
 	default:
 		sessionLogger.Error("Unknown protocol", zap.String("protocol", session.TargetProtocol))
-		return
+		err = fmt.Errorf("unknown protocol '%s'", session.TargetProtocol)
 	}
 	if err != nil {
 		sessionLogger.Error("Error while connecting to target host", zap.String("error", err.Error()))
 	}
+	tracing.SetError(span, err)
+	audit.sessionEnded(err)
 	_, _ = io.WriteString(clientSession, "\nДо свидания\n")
 	_ = clientSession.Close()
 	_ = sessionLogger.Sync()
 }
 
+// clientIP возвращает IP-адрес клиента без порта, а для IPv6 - без квадратных скобок и зоны, в виде,
+// который принимает поле типа ip в Elasticsearch
+func clientIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
+
 func (app *BastionProxy) ConnCallback(ctx ssh.Context, conn net.Conn) net.Conn {
 	_ = conn.SetDeadline(time.Now().Add(time.Second * time.Duration(app.config.ConnectTimeoutSec)))
-	return conn
+	return readTokenPreface(ctx, conn)
+}
+
+// prefacedConn - соединение, начало которого уже прочитано в буфер при поиске преамбулы с токеном
+type prefacedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c prefacedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// readTokenPreface читает преамбулу с токеном сессии (см. auth.TokenPreface), если клиент её отправил, и сохраняет
+// токен в контексте соединения. Клиент SSH отправляет свою идентификационную строку, не дожидаясь сервера
+// (RFC 4253, 4.2), поэтому первый байт доступен сразу. Соединение с некорректной преамбулой закрывается
+func readTokenPreface(ctx ssh.Context, conn net.Conn) net.Conn {
+	reader := bufio.NewReaderSize(conn, maxTokenPrefaceLength)
+	first, err := reader.Peek(1)
+	if err != nil || first[0] != auth.TokenPreface[0] {
+		return prefacedConn{Conn: conn, reader: reader}
+	}
+	line, err := reader.ReadSlice('\n')
+	if err != nil || !bytes.HasPrefix(line, []byte(auth.TokenPreface)) {
+		return nil
+	}
+	token := strings.TrimSpace(string(line[len(auth.TokenPreface):]))
+	if token == "" {
+		return nil
+	}
+	ctx.SetValue(tokenContextKey, token)
+	return prefacedConn{Conn: conn, reader: reader}
 }
 
 func (app *BastionProxy) proxyToSSH(sessData proxySessionData) error {
+	_, span := startDialSpan(sessData.ctx, "ssh", sessData.targetAddress)
 	target, err := NewSSHSession(sessData.logger, sessData.targetAddress, sessData.targetLogin, sessData.targetPassword, sessData.targetPrivKey)
+	tracing.End(span, err)
 	if err != nil {
 		sessData.logger.Error(err.Error())
 		return err
 	}
 	defer target.Close()
 
+	screen := app.newScreenLogger(sessData)
+	if screen != nil {
+		defer screen.Close()
+	}
 	go func() { // проксирование запроса "window-change"
 		for win := range sessData.winCh {
 			target.ResizeWindow(win.Height, win.Width)
+			sessData.audit.windowResized(win)
+			if screen != nil {
+				screen.Resize(win.Width, win.Height)
+			}
 		}
 	}()
 
 	done := make(chan bool)
-	go app.connectStreams("target stdin", sessData.logger, sessData.clientStdout, target.Stdin(), done)
-	go app.connectStreams("target stdout", sessData.logger, target.Stdout(), sessData.clientStdin, done)
-	go app.connectStreams("target stderr", sessData.logger, target.Stderr(), sessData.clientStderr, done)
+	go app.connectStreams("target stderr", sessData.logger, sessData.masker.observe(target.Stderr()), sessData.clientStderr, nil, done)
 
 	if err := target.StartShell(sessData.pty.Term, sessData.pty.Window.Height, sessData.pty.Window.Width, sessData.env); err != nil {
 		sessData.logger.Error(err.Error())
 		return err
 	}
+	targetStdout := target.Stdout()
+	if sessData.loginProfile != nil {
+		// Сценарий после входа (переход в привилегированный режим, команды): секреты вводит прокси,
+		// пользователь их не видит
+		tap := newStreamTap(targetStdout)
+		targetStdout = tap
+		steps := loginSteps(*sessData.loginProfile, sessData.targetLogin, sessData.targetPassword, sessData.targetEnablePassword, false)
+		_, span := tracing.Start(sessData.ctx, "target login")
+		output, err := runLoginScript(writeNopCloser{target.Stdin()}, tap, sessData.logger, *sessData.loginProfile, steps,
+			sessData.targetPassword, sessData.targetEnablePassword)
+		tracing.End(span, err)
+		_, _ = io.WriteString(sessData.clientStdin, output)
+		if screen != nil {
+			_, _ = io.WriteString(screen, output)
+		}
+		if err != nil {
+			sessData.logger.Error("Post-login script failed", zap.String("error", err.Error()))
+			_, _ = io.WriteString(sessData.clientStdin, "\r\nАвтоматический переход в привилегированный режим не выполнен\r\n")
+			return err
+		}
+	}
+	targetStdin, err := app.filterCommands(sessData, target.Stdin())
+	if err != nil {
+		return err
+	}
+	sessData.audit.targetConnected()
+	go app.connectStreams("target stdin", sessData.logger, sessData.clientStdout, targetStdin, sessData.masker.maskInput, done)
+	if screen != nil {
+		targetStdout = io.TeeReader(targetStdout, screen)
+	}
+	go app.connectStreams("target stdout", sessData.logger, sessData.masker.observe(targetStdout), sessData.clientStdin, nil, done)
 	<-done
+	if status, ok := target.ExitStatus(time.Second); ok {
+		sessData.audit.exitStatus = &status
+	}
 	return nil
 }
 
 func (app *BastionProxy) proxyToTelnet(sessData proxySessionData) error {
+	_, span := startDialSpan(sessData.ctx, "telnet", sessData.targetAddress)
 	target, err := telnet.Connect(sessData.targetAddress)
+	tracing.End(span, err)
 	if err != nil {
 		sessData.logger.Error(err.Error())
 		return err
 	}
 
+	screen := app.newScreenLogger(sessData)
+	if screen != nil {
+		defer screen.Close()
+	}
 	target.SetWindowSize(sessData.pty.Window.Width, sessData.pty.Window.Height)
 	go func() { // проксирование запроса "window-change"
 		for win := range sessData.winCh {
 			target.SetWindowSize(win.Width, win.Height)
+			sessData.audit.windowResized(win)
+			if screen != nil {
+				screen.Resize(win.Width, win.Height)
+			}
 			sessData.logger.Debug(fmt.Sprintf("Window size changed to (%d, %d)", win.Width, win.Height))
 		}
 	}()
 
-	_, _ = io.WriteString(sessData.clientStdin, app.telnetLoginToTarget(target, sessData.logger, sessData.targetLogin, sessData.targetPassword))
+	tap := newStreamTap(target)
+	_, span = tracing.Start(sessData.ctx, "target login")
+	output, err := app.telnetLoginToTarget(target, tap, sessData)
+	tracing.End(span, err)
+	_, _ = io.WriteString(sessData.clientStdin, output)
+	if screen != nil {
+		_, _ = io.WriteString(screen, output)
+	}
+	if err != nil {
+		sessData.logger.Error("Automatic login failed", zap.String("error", err.Error()))
+		_, _ = io.WriteString(sessData.clientStdin, "\r\nАвтоматический вход на устройство не выполнен\r\n")
+		_ = target.Close()
+		return err
+	}
 
+	targetStdin, err := app.filterCommands(sessData, target)
+	if err != nil {
+		_ = target.Close()
+		return err
+	}
 	done := make(chan bool)
-	go app.connectStreams("target stdin", sessData.logger, sessData.clientStdout, target, done)
-	go app.connectStreams("target stdout", sessData.logger, target, sessData.clientStdin, done)
+	sessData.audit.targetConnected()
+	go app.connectStreams("target stdin", sessData.logger, sessData.clientStdout, targetStdin, sessData.masker.maskInput, done)
+	var targetStdout io.Reader = tap
+	if screen != nil {
+		targetStdout = io.TeeReader(tap, screen)
+	}
+	go app.connectStreams("target stdout", sessData.logger, sessData.masker.observe(targetStdout), sessData.clientStdin, nil, done)
 	<-done
 	return target.Close()
 }
 
-func (app *BastionProxy) connectStreams(id string, logger *zap.Logger, reader io.Reader, writer io.Writer, done chan bool) {
+// startDialSpan начинает span подключения к цели
+func startDialSpan(ctx context.Context, protocol, address string) (context.Context, trace.Span) {
+	return tracing.StartKind(ctx, "dial target", trace.SpanKindClient,
+		attribute.String("target_protocol", protocol), attribute.String("target_address", address))
+}
+
+// newScreenLogger возвращает журнал снимков экрана сессии или nil, если снимки экрана выключены
+func (app *BastionProxy) newScreenLogger(sessData proxySessionData) *log.ScreenLogger {
+	if !app.config.ScreenCapture.Enabled {
+		return nil
+	}
+	interval := time.Second * time.Duration(app.config.ScreenCapture.IntervalSec)
+	if interval <= 0 {
+		interval = time.Second * 5
+	}
+	return log.NewScreenLogger(sessData.logger, "target screen", sessData.pty.Window.Width, sessData.pty.Window.Height, interval)
+}
+
+// connectStreams копирует поток reader в writer, записывая его в журнал. Если задан logView, в журнал записывается
+// результат его применения к прочитанным данным (например, с замаскированными паролями)
+func (app *BastionProxy) connectStreams(id string, logger *zap.Logger, reader io.Reader, writer io.Writer, logView func([]byte) []byte, done chan bool) {
 	logger.Debug("Connecting stream", zap.String("stream_id", id))
 	buffer := make([]byte, 1024)
 	bl := log.NewBufferedLogger(logger, id)
@@ -158,7 +349,11 @@ func (app *BastionProxy) connectStreams(id string, logger *zap.Logger, reader io
 	for {
 		n, err := reader.Read(buffer)
 		if n > 0 {
-			_, blerr := bl.Write(buffer[:n])
+			logged := buffer[:n]
+			if logView != nil {
+				logged = logView(logged)
+			}
+			_, blerr := bl.Write(logged)
 			if blerr != nil {
 				logger.Error(blerr.Error())
 			}
@@ -181,65 +376,28 @@ func (app *BastionProxy) connectStreams(id string, logger *zap.Logger, reader io
 	}
 }
 
-func (app *BastionProxy) telnetLoginToTarget(session *telnet.Connection, logger *zap.Logger, login, password string) string {
-	const expectTimeout = time.Second * 5
-	var capturedOutput string
-
-	userRE := regexp.MustCompile("ogin: ")
-	passRE := regexp.MustCompile("assword: ")
-
-	expecting := true
-	resCh := make(chan error)
-
-	opts := &expect.GenOptions{
-		In:  session,
-		Out: session,
-		Wait: func() error {
-			return <-resCh
-		},
-		Close: func() error {
-			close(resCh)
-			return nil
-		},
-		Check: func() bool {
-			return expecting
-		},
+// filterCommands возвращает поток к цели, проверяющий вводимые команды по правилам мандата, если они заданы
+func (app *BastionProxy) filterCommands(sessData proxySessionData, targetStdin io.Writer) (io.Writer, error) {
+	if len(sessData.commandRules) == 0 {
+		return targetStdin, nil
 	}
-
-	expector, _, err := expect.SpawnGeneric(opts, -1)
-	if err != nil {
-		return capturedOutput
-	}
-
-	output, match, err := expector.Expect(userRE, expectTimeout)
+	filter, err := newCommandFilter(sessData.commandRules, targetStdin, sessData.clientStdin, sessData.logger, sessData.audit, sessData.masker)
 	if err != nil {
-		logger.Error(err.Error())
-		return ""
-	}
-	capturedOutput += output
-	logger.Debug("Login expect", zap.String("output", output), zap.String("match", match[0]))
-	err = expector.Send(login + "\n")
-	if err != nil {
-		logger.Error(err.Error())
-		return ""
+		sessData.logger.Error("Invalid command rules", zap.String("error", err.Error()))
+		_, _ = io.WriteString(sessData.clientStdin, "\r\nПравила фильтрации команд мандата некорректны\r\n")
+		return nil, err
 	}
+	return filter, nil
+}
 
-	output, match, err = expector.Expect(passRE, expectTimeout)
-	if err != nil {
-		logger.Error(err.Error())
-		return ""
+// telnetLoginToTarget выполняет вход на цель по профилю, заданному на сервере (или встроенному профилю по умолчанию).
+// Возвращает вывод цели за время входа, в котором учётные данные заменены маской
+func (app *BastionProxy) telnetLoginToTarget(session *telnet.Connection, output *streamTap, sessData proxySessionData) (string, error) {
+	profile := defaultTelnetLoginProfile
+	if sessData.loginProfile != nil {
+		profile = *sessData.loginProfile
 	}
-	capturedOutput += output + "\n"
-	logger.Debug("Password expect", zap.String("output", output), zap.String("match", match[0]))
-	err = expector.Send(password + "\n")
-	if err != nil {
-		logger.Error(err.Error())
-		return ""
-	}
-
-	expecting = false
-	_ = expector.Close()
-	capturedOutput = strings.ReplaceAll(capturedOutput, login, "<BASTION-WAS-HERE>")
-	capturedOutput = strings.ReplaceAll(capturedOutput, password, "<BASTION-WAS-HERE>")
-	return capturedOutput
+	steps := loginSteps(profile, sessData.targetLogin, sessData.targetPassword, sessData.targetEnablePassword, true)
+	return runLoginScript(session, output, sessData.logger, profile, steps,
+		sessData.targetLogin, sessData.targetPassword, sessData.targetEnablePassword)
 }
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"bastion/internal/auth"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// testContext - контекст соединения с минимальной реализацией значений и имени пользователя
+type testContext struct {
+	ssh.Context
+	user   string
+	values map[interface{}]interface{}
+}
+
+func (ctx *testContext) User() string { return ctx.user }
+
+func (ctx *testContext) Value(key interface{}) interface{} { return ctx.values[key] }
+
+func (ctx *testContext) SetValue(key, value interface{}) { ctx.values[key] = value }
+
+func TestReadTokenPreface(t *testing.T) {
+	cases := []struct {
+		name      string
+		sent      string
+		wantToken string
+		wantData  string
+		closed    bool
+	}{
+		{"no preface", "SSH-2.0-OpenSSH_9.6\r\n", "user", "SSH-2.0-OpenSSH_9.6\r\n", false},
+		{"preface", auth.TokenPreface + "0d9d3f0e\nSSH-2.0-OpenSSH_9.6\r\n", "0d9d3f0e", "SSH-2.0-OpenSSH_9.6\r\n", false},
+		{"empty token", auth.TokenPreface + "\nSSH-2.0-OpenSSH_9.6\r\n", "", "", true},
+		{"malformed preface", "BASTION 0d9d3f0e\nSSH-2.0-OpenSSH_9.6\r\n", "", "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() { _, _ = io.WriteString(client, c.sent) }()
+			ctx := &testContext{user: "user", values: map[interface{}]interface{}{}}
+			conn := readTokenPreface(ctx, server)
+			if c.closed {
+				if conn != nil {
+					t.Error("connection with malformed preface is accepted")
+				}
+				return
+			}
+			if token := sessionToken(ctx); token != c.wantToken {
+				t.Errorf("token %q, want %q", token, c.wantToken)
+			}
+			data := make([]byte, len(c.wantData))
+			if _, err := io.ReadFull(conn, data); err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != c.wantData {
+				t.Errorf("data %q, want %q", data, c.wantData)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 52022}, "192.0.2.10"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 52022}, "2001:db8::10"},
+		{&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 52022, Zone: "eth0"}, "fe80::1"},
+	}
+	for _, c := range cases {
+		if got := clientIP(c.addr); got != c.want {
+			t.Errorf("clientIP(%s) = %q, want %q", c.addr, got, c.want)
+		}
+	}
+}
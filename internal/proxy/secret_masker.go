@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+const (
+	secretMaskingPrompt = "prompt" // Маскировать ввод после приглашения ввода пароля
+	secretMaskingOff    = "off"
+
+	maxPromptTail = 512
+)
+
+var defaultSecretPrompts = []string{
+	`(?i)(password|passphrase|passcode|пароль)[^\r\n]*[:?]\s*$`,
+	`(?i)(secret|verification code|\bpin\b)[^\r\n]*:\s*$`,
+}
+
+// Управляющие последовательности (цвета, позиционирование курсора) в приглашении не должны мешать его распознаванию
+var promptControlSequence = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|[@-Z\\-_])`)
+
+func compileSecretPrompts(mode string, patterns []string) ([]*regexp.Regexp, error) {
+	switch mode {
+	case secretMaskingOff:
+		return nil, nil
+	case secretMaskingPrompt, "":
+	default:
+		return nil, fmt.Errorf("unknown secret masking mode '%s'", mode)
+	}
+	if len(patterns) == 0 {
+		patterns = defaultSecretPrompts
+	}
+	prompts := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("malformed secret prompt pattern: %w", err)
+		}
+		prompts = append(prompts, re)
+	}
+	return prompts, nil
+}
+
+// secretMasker следит за выводом цели и, когда последняя строка вывода совпадает с приглашением ввода пароля,
+// заменяет в журнале ввод пользователя до нажатия Enter маской. В поток к цели ввод передаётся без изменений.
+// Ввод, набранный пользователем до появления приглашения, не маскируется.
+// Нулевой указатель допустим и означает, что маскирование выключено
+type secretMasker struct {
+	prompts []*regexp.Regexp
+
+	mu      sync.Mutex
+	tail    []byte // Вывод цели после последнего перевода строки
+	armed   bool
+	masking bool // Маска уже записана в журнал для текущего ввода
+	entered bool // Ввод после приглашения завершён нажатием Enter и ещё не передан фильтру команд
+}
+
+func (app *BastionProxy) newSecretMasker() *secretMasker {
+	if len(app.secretPrompts) == 0 {
+		return nil
+	}
+	return &secretMasker{prompts: app.secretPrompts}
+}
+
+// observe возвращает читатель вывода цели, передающий прочитанное маскировщику
+func (m *secretMasker) observe(r io.Reader) io.Reader {
+	if m == nil {
+		return r
+	}
+	return maskerObserver{r: r, m: m}
+}
+
+type maskerObserver struct {
+	r io.Reader
+	m *secretMasker
+}
+
+func (o maskerObserver) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	if n > 0 {
+		o.m.observeOutput(p[:n])
+	}
+	return n, err
+}
+
+func (m *secretMasker) observeOutput(p []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := bytes.LastIndexAny(p, "\r\n"); i >= 0 {
+		m.tail = append(m.tail[:0], p[i+1:]...)
+	} else {
+		m.tail = append(m.tail, p...)
+	}
+	if len(m.tail) > maxPromptTail {
+		m.tail = append(m.tail[:0], m.tail[len(m.tail)-maxPromptTail:]...)
+	}
+	if len(m.tail) == 0 {
+		return
+	}
+	line := promptControlSequence.ReplaceAll(m.tail, nil)
+	for _, re := range m.prompts {
+		if re.Match(line) {
+			m.armed = true
+			m.tail = m.tail[:0]
+			return
+		}
+	}
+}
+
+// maskInput возвращает ввод пользователя в том виде, в котором он записывается в журнал
+func (m *secretMasker) maskInput(p []byte) []byte {
+	if m == nil {
+		return p
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.armed {
+		return p
+	}
+	out := make([]byte, 0, len(p)+len(maskedSecret))
+	for i, b := range p {
+		if b == keyCR || b == keyLF || b == keyCtrlC {
+			m.armed = false
+			m.masking = false
+			m.entered = b != keyCtrlC
+			return append(out, p[i:]...)
+		}
+		if !m.masking {
+			out = append(out, maskedSecret...)
+			m.masking = true
+		}
+	}
+	return out
+}
+
+// secretEntered возвращает true, если последнее нажатие Enter завершило ввод после приглашения ввода пароля,
+// и сбрасывает этот признак. Вызывается фильтром команд после maskInput для того же ввода
+func (m *secretMasker) secretEntered() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entered := m.entered
+	m.entered = false
+	return entered
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/tracing"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/plyul/telnet"
+	"go.uber.org/zap"
+)
+
+// Telnet COM Port Control Option (RFC 2217)
+const (
+	comPortOption telnet.OptionCode = 44
+
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+	comPortSetControl  = 5
+
+	comPortServerOffset = 100 // Ответы сервера имеют коды команд клиента, увеличенные на 100
+
+	comPortControlNoFlowControl = 1
+)
+
+var comPortParity = map[string]byte{"none": 1, "odd": 2, "even": 3, "mark": 4, "space": 5}
+
+var comPortStopSize = map[int]byte{1: 1, 2: 2, 15: 3}
+
+// Параметры порта по умолчанию, если для цели они не заданы
+var defaultSerialSettings = api.SerialSettings{BaudRate: 9600, DataBits: 8, Parity: "none", StopBits: 1}
+
+// proxyToRaw соединяет клиента с TCP-портом цели без какого-либо протокола (например, с последовательным портом
+// консольного сервера в режиме raw)
+func (app *BastionProxy) proxyToRaw(sessData proxySessionData) error {
+	_, span := startDialSpan(sessData.ctx, "raw", sessData.targetAddress)
+	target, err := net.DialTimeout("tcp", sessData.targetAddress, time.Second*time.Duration(app.config.ConnectTimeoutSec))
+	tracing.End(span, err)
+	if err != nil {
+		sessData.logger.Error(err.Error())
+		return err
+	}
+	targetStdin, err := app.filterCommands(sessData, target)
+	if err != nil {
+		_ = target.Close()
+		return err
+	}
+	go ignoreWindowChanges(sessData)
+
+	done := make(chan bool)
+	sessData.audit.targetConnected()
+	go app.connectStreams("target stdin", sessData.logger, sessData.clientStdout, targetStdin, sessData.masker.maskInput, done)
+	go app.connectStreams("target stdout", sessData.logger, sessData.masker.observe(target), sessData.clientStdin, nil, done)
+	<-done
+	return target.Close()
+}
+
+// proxyToSerial соединяет клиента с последовательным портом консольного сервера по RFC 2217, предварительно
+// устанавливая параметры порта, заданные для цели
+func (app *BastionProxy) proxyToSerial(sessData proxySessionData) error {
+	_, span := startDialSpan(sessData.ctx, "rfc2217", sessData.targetAddress)
+	target, err := dialTelnet(sessData.targetAddress, time.Second*time.Duration(app.config.ConnectTimeoutSec))
+	tracing.End(span, err)
+	if err != nil {
+		sessData.logger.Error(err.Error())
+		return err
+	}
+	target.AddOption(comPortOption, true, false, func(_ *telnet.Connection, data []byte) error {
+		if command, value, ok := parseComPortReply(data); ok {
+			sessData.logger.Debug("COM port option acknowledged", zap.Int("command", command), zap.Binary("value", value))
+		}
+		return nil
+	}, nil)
+
+	settings := defaultSerialSettings
+	if sessData.serial != nil {
+		settings = *sessData.serial
+	}
+	if err := setComPort(target, settings); err != nil {
+		sessData.logger.Error(err.Error())
+		_ = target.Close()
+		return err
+	}
+	sessData.logger.Info("Serial port configured",
+		zap.Int("baud_rate", settings.BaudRate),
+		zap.Int("data_bits", settings.DataBits),
+		zap.String("parity", settings.Parity),
+		zap.Int("stop_bits", settings.StopBits))
+	targetStdin, err := app.filterCommands(sessData, iacEscaper{target})
+	if err != nil {
+		_ = target.Close()
+		return err
+	}
+	go ignoreWindowChanges(sessData)
+
+	done := make(chan bool)
+	sessData.audit.targetConnected()
+	go app.connectStreams("target stdin", sessData.logger, sessData.clientStdout, targetStdin, sessData.masker.maskInput, done)
+	go app.connectStreams("target stdout", sessData.logger, sessData.masker.observe(target), sessData.clientStdin, nil, done)
+	<-done
+	return target.Close()
+}
+
+// dialTelnet подключается к telnet-серверу не дольше timeout. Библиотека telnet не позволяет задать таймаут
+// подключения или передать ей готовое соединение, поэтому подключение выполняется в отдельной горутине; соединение,
+// установленное после истечения таймаута, закрывается
+func dialTelnet(address string, timeout time.Duration) (*telnet.Connection, error) {
+	type result struct {
+		conn *telnet.Connection
+		err  error
+	}
+	connected := make(chan result, 1)
+	go func() {
+		conn, err := telnet.Connect(address)
+		connected <- result{conn, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-connected:
+		return r.conn, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-connected; r.err == nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("timeout connecting to %s after %s", address, timeout)
+	}
+}
+
+// ignoreWindowChanges вычитывает запросы "window-change": размер окна неприменим к последовательному порту
+func ignoreWindowChanges(sessData proxySessionData) {
+	for win := range sessData.winCh {
+		sessData.audit.windowResized(win)
+		sessData.logger.Debug(fmt.Sprintf("Window size changed to (%d, %d), ignored", win.Width, win.Height))
+	}
+}
+
+func setComPort(target *telnet.Connection, s api.SerialSettings) error {
+	parity, ok := comPortParity[s.Parity]
+	if !ok {
+		return fmt.Errorf("unsupported parity '%s'", s.Parity)
+	}
+	stopSize, ok := comPortStopSize[s.StopBits]
+	if !ok {
+		return fmt.Errorf("unsupported stop bits value %d", s.StopBits)
+	}
+	if s.DataBits < 5 || s.DataBits > 8 {
+		return fmt.Errorf("unsupported data bits value %d", s.DataBits)
+	}
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(s.BaudRate))
+
+	commands := [][]byte{
+		{byte(telnet.IAC), byte(telnet.WILL), byte(comPortOption)},
+		comPortCommand(comPortSetBaudRate, baud...),
+		comPortCommand(comPortSetDataSize, byte(s.DataBits)),
+		comPortCommand(comPortSetParity, parity),
+		comPortCommand(comPortSetStopSize, stopSize),
+		comPortCommand(comPortSetControl, comPortControlNoFlowControl),
+	}
+	for _, c := range commands {
+		if _, err := target.Write(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comPortCommand формирует подкоманду IAC SB COM-PORT-OPTION <команда> <значение> IAC SE
+func comPortCommand(command byte, value ...byte) []byte {
+	result := []byte{byte(telnet.IAC), byte(telnet.SB), byte(comPortOption), command}
+	for _, b := range value {
+		result = append(result, b)
+		if b == byte(telnet.IAC) {
+			result = append(result, b)
+		}
+	}
+	return append(result, byte(telnet.IAC), byte(telnet.SE))
+}
+
+// parseComPortReply разбирает ответ сервера IAC SB COM-PORT-OPTION <команда> <значение> IAC SE. Возвращает false,
+// если подкоманда короче этого обрамления
+func parseComPortReply(data []byte) (int, []byte, bool) {
+	if len(data) < 6 {
+		return 0, nil, false
+	}
+	return int(data[3]) - comPortServerOffset, data[4 : len(data)-2], true
+}
+
+// iacEscaper удваивает байты IAC в данных, передаваемых цели, чтобы двоичные данные не принимались за команды Telnet
+type iacEscaper struct {
+	w io.Writer
+}
+
+func (e iacEscaper) Write(p []byte) (int, error) {
+	escaped := make([]byte, 0, len(p))
+	for _, b := range p {
+		escaped = append(escaped, b)
+		if b == byte(telnet.IAC) {
+			escaped = append(escaped, b)
+		}
+	}
+	if _, err := e.w.Write(escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/plyul/telnet"
+)
+
+func TestParseComPortReply(t *testing.T) {
+	iac, sb, se := byte(telnet.IAC), byte(telnet.SB), byte(telnet.SE)
+	tests := []struct {
+		name    string
+		data    []byte
+		command int
+		value   []byte
+		ok      bool
+	}{
+		{"baud rate", []byte{iac, sb, 44, 101, 0, 0, 0x25, 0x80, iac, se}, comPortSetBaudRate, []byte{0, 0, 0x25, 0x80}, true},
+		{"empty value", []byte{iac, sb, 44, 105, iac, se}, comPortSetControl, []byte{}, true},
+		{"short", []byte{iac, sb, 44, iac, se}, 0, nil, false},
+		{"truncated", []byte{iac, sb, 44}, 0, nil, false},
+	}
+	for _, tt := range tests {
+		command, value, ok := parseComPortReply(tt.data)
+		if ok != tt.ok || command != tt.command || !bytes.Equal(value, tt.value) {
+			t.Errorf("%s: got (%d, %v, %v), want (%d, %v, %v)", tt.name, command, value, ok, tt.command, tt.value, tt.ok)
+		}
+	}
+}
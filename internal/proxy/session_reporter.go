@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/api/client"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	reportQueueSize     = 256
+	reportAttempts      = 3
+	reportRetryDelay    = time.Second // Удваивается после каждой неудачной попытки
+	spoolFlushInterval  = 30 * time.Second
+	spoolFileSuffix     = ".json"
+	spoolTempFilePrefix = ".tmp-"
+)
+
+// sessionReporter сообщает серверу о событиях сессий (подключение к цели, ошибки, завершение) для истории сессий.
+// Сообщения отправляются в фоне, чтобы недоступность сервера не задерживала сессии; неудачная отправка повторяется
+// несколько раз, после чего событие сохраняется в каталог спула и отправляется позже.
+// Сервер обрабатывает повторные сообщения идемпотентно, поэтому событие может быть доставлено более одного раза
+type sessionReporter struct {
+	logger   *zap.Logger
+	send     func(context.Context, api.SessionEventDTO) error
+	spoolDir string
+	queue    chan api.SessionEventDTO
+	seq      uint64 // Изменяется атомарно, делает уникальными имена файлов спула
+	done     chan struct{}
+
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu        sync.Mutex
+	inFlight  *api.SessionEventDTO // Событие, которое отправляется в данный момент
+	abandoned bool                 // Время ожидания отправки при закрытии истекло, события сохраняются в спул
+}
+
+func newSessionReporter(logger *zap.Logger, send func(context.Context, api.SessionEventDTO) error, spoolDir string) (*sessionReporter, error) {
+	if err := os.MkdirAll(spoolDir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating report spool directory: %w", err)
+	}
+	r := &sessionReporter{
+		logger:   logger,
+		send:     send,
+		spoolDir: spoolDir,
+		queue:    make(chan api.SessionEventDTO, reportQueueSize),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	go r.flushPeriodically()
+	return r, nil
+}
+
+// report ставит событие в очередь на отправку. События сессий без токена (до выдачи токена) не сообщаются
+func (r *sessionReporter) report(e api.SessionEventDTO) {
+	if r == nil || e.Token == "" {
+		return
+	}
+	if e.OccurredAt == 0 {
+		e.OccurredAt = time.Now().Unix()
+	}
+	r.closeMu.RLock()
+	defer r.closeMu.RUnlock()
+	if r.closed {
+		r.spool(e)
+		return
+	}
+	select {
+	case r.queue <- e:
+	default:
+		r.logger.Warn("Session event queue is full, spooling event", zap.String("event", e.Event))
+		r.spool(e)
+	}
+}
+
+func (r *sessionReporter) run() {
+	defer close(r.done)
+	for e := range r.queue {
+		if !r.startDelivery(e) {
+			r.spool(e)
+			continue
+		}
+		err := r.deliver(e)
+		if !r.endDelivery() { // Событие уже сохранено в спул при закрытии
+			continue
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, client.ErrRejected):
+			r.logger.Error("Session event rejected by server", zap.String("event", e.Event), zap.String("error", err.Error()))
+		default:
+			r.logger.Warn("Error reporting session event, spooling", zap.String("event", e.Event), zap.String("error", err.Error()))
+			r.spool(e)
+		}
+	}
+}
+
+// close прекращает приём событий в очередь и ожидает отправки очереди не дольше timeout. События, которые
+// не успели отправить, в том числе отправляемое в данный момент, сохраняются в спул и будут отправлены
+// после перезапуска
+func (r *sessionReporter) close(timeout time.Duration) {
+	if r == nil {
+		return
+	}
+	r.closeMu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.closeMu.Unlock()
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		n := 0
+		r.mu.Lock()
+		r.abandoned = true
+		inFlight := r.inFlight
+		r.mu.Unlock()
+		if inFlight != nil {
+			r.spool(*inFlight)
+			n++
+		}
+		for e := range r.queue {
+			r.spool(e)
+			n++
+		}
+		r.logger.Warn("Timeout reporting session events, spooled", zap.Int("events", n))
+	}
+}
+
+// startDelivery отмечает событие как отправляемое. Возвращает false, если время ожидания при закрытии истекло
+// и событие нужно сохранить в спул
+func (r *sessionReporter) startDelivery(e api.SessionEventDTO) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.abandoned {
+		return false
+	}
+	r.inFlight = &e
+	return true
+}
+
+// endDelivery снимает отметку об отправке. Возвращает false, если событие уже сохранено в спул при закрытии
+func (r *sessionReporter) endDelivery() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight = nil
+	return !r.abandoned
+}
+
+// deliver отправляет событие, повторяя попытки с нарастающей задержкой. Отказ сервера не повторяется
+func (r *sessionReporter) deliver(e api.SessionEventDTO) error {
+	delay := reportRetryDelay
+	var err error
+	for attempt := 1; attempt <= reportAttempts; attempt++ {
+		err = r.sendOnce(e)
+		if err == nil || errors.Is(err, client.ErrRejected) {
+			return err
+		}
+		if attempt < reportAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// sendOnce выполняет одну попытку отправки события с ограничением времени
+func (r *sessionReporter) sendOnce(e api.SessionEventDTO) error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiRequestTimeout)
+	defer cancel()
+	return r.send(ctx, e)
+}
+
+// spool сохраняет событие в каталог спула. Файл записывается под временным именем и переименовывается,
+// чтобы отправка спула не прочитала его частично
+func (r *sessionReporter) spool(e api.SessionEventDTO) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		r.logger.Error(err.Error())
+		return
+	}
+	name := fmt.Sprintf("%020d-%06d-%s%s", time.Now().UnixNano(), atomic.AddUint64(&r.seq, 1)%1000000, e.Event, spoolFileSuffix)
+	tmp, err := os.CreateTemp(r.spoolDir, spoolTempFilePrefix+"*")
+	if err != nil {
+		r.logger.Error("Error spooling session event", zap.String("error", err.Error()))
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(r.spoolDir, name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		r.logger.Error("Error spooling session event", zap.String("error", err.Error()))
+	}
+}
+
+func (r *sessionReporter) flushPeriodically() {
+	r.flushSpool()
+	ticker := time.NewTicker(spoolFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.flushSpool()
+	}
+}
+
+// flushSpool отправляет сохранённые события в порядке их возникновения. Отправленные и отклонённые сервером события
+// удаляются из спула; при первой ошибке, которую имеет смысл повторить, отправка откладывается до следующего раза
+func (r *sessionReporter) flushSpool() {
+	entries, err := os.ReadDir(r.spoolDir)
+	if err != nil {
+		r.logger.Error("Error reading report spool", zap.String("error", err.Error()))
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), spoolFileSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(r.spoolDir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			r.logger.Error("Error reading spooled session event", zap.String("file", name), zap.String("error", err.Error()))
+			continue
+		}
+		var e api.SessionEventDTO
+		if err := json.Unmarshal(data, &e); err != nil {
+			r.logger.Error("Malformed spooled session event, removing", zap.String("file", name), zap.String("error", err.Error()))
+			_ = os.Remove(path)
+			continue
+		}
+		err = r.sendOnce(e)
+		if err != nil && !errors.Is(err, client.ErrRejected) {
+			r.logger.Debug("Server still unavailable, keeping spooled session events", zap.String("error", err.Error()))
+			return
+		}
+		if err != nil {
+			r.logger.Error("Spooled session event rejected by server", zap.String("file", name), zap.String("error", err.Error()))
+		}
+		if err := os.Remove(path); err != nil {
+			r.logger.Error("Error removing spooled session event", zap.String("file", name), zap.String("error", err.Error()))
+		}
+	}
+}
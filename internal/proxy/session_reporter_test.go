@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"bastion/internal/api"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestSessionReporterCloseTimeout проверяет, что при истечении времени ожидания закрытия в спул сохраняются
+// и события из очереди, и событие, отправка которого не завершилась
+func TestSessionReporterCloseTimeout(t *testing.T) {
+	dir := t.TempDir()
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	var once sync.Once
+	send := func(_ context.Context, e api.SessionEventDTO) error {
+		if e.Token == "first" {
+			once.Do(func() {
+				close(sending)
+				<-release // Сервер не отвечает
+			})
+		}
+		return errors.New("server unavailable")
+	}
+	r, err := newSessionReporter(zap.NewNop(), send, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.report(api.SessionEventDTO{Token: "first", Event: api.SessionEventStarted})
+	<-sending
+	r.report(api.SessionEventDTO{Token: "second", Event: api.SessionEventStarted})
+	r.close(10 * time.Millisecond)
+	r.report(api.SessionEventDTO{Token: "third", Event: api.SessionEventEnded})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []string
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), spoolFileSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e api.SessionEventDTO
+		if err := json.Unmarshal(data, &e); err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, e.Token)
+	}
+	sort.Strings(tokens)
+	if strings.Join(tokens, ",") != "first,second,third" {
+		t.Errorf("spooled events %v, want first, second and third", tokens)
+	}
+}
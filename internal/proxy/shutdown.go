@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"go.uber.org/zap"
+)
+
+const (
+	sessionCloseTimeout = 10 * time.Second // Ожидание завершения сессий, закрытых прокси при остановке
+	reportCloseTimeout  = 10 * time.Second
+)
+
+// sessionRegistry учитывает активные сессии клиентов и каналы проброса портов, чтобы при остановке прокси
+// предупредить пользователей, дождаться завершения сессий и закрыть оставшиеся
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[io.WriteCloser]bool // Значение - можно ли писать в сессию сообщения для пользователя
+	draining bool
+	wg       sync.WaitGroup
+	closing  atomic.Bool // Сессии закрываются прокси по истечении времени ожидания
+}
+
+// add регистрирует сессию (ssh.Session или канал SSH). Возвращает false, если прокси останавливается
+// и новые сессии не принимаются
+func (r *sessionRegistry) add(s io.WriteCloser, interactive bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.draining {
+		return false
+	}
+	if r.sessions == nil {
+		r.sessions = map[io.WriteCloser]bool{}
+	}
+	r.sessions[s] = interactive
+	r.wg.Add(1)
+	return true
+}
+
+// accepting возвращает false, если прокси останавливается и новые сессии не принимаются
+func (r *sessionRegistry) accepting() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.draining
+}
+
+func (r *sessionRegistry) remove(s io.WriteCloser) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sessions[s]; ok {
+		delete(r.sessions, s)
+		r.wg.Done()
+	}
+}
+
+// drain запрещает новые сессии и возвращает канал, который закрывается после завершения всех сессий
+func (r *sessionRegistry) drain() <-chan struct{} {
+	r.mu.Lock()
+	r.draining = true
+	r.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// broadcast выводит сообщение пользователям интерактивных сессий и, если close, закрывает все сессии
+func (r *sessionRegistry) broadcast(message string, close bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for s, interactive := range r.sessions {
+		if interactive {
+			_, _ = io.WriteString(s, message)
+		}
+		if close {
+			_ = s.Close()
+		}
+	}
+	return len(r.sessions)
+}
+
+// shutdown останавливает прокси: прекращает приём подключений, предупреждает пользователей и ожидает завершения
+// сессий не дольше настроенного времени, после чего закрывает оставшиеся сессии и соединения. События сессий,
+// которые не удалось сообщить серверу, сохраняются в спул
+func (app *BastionProxy) shutdown(server *ssh.Server) {
+	app.listening.Store(false)
+	timeout := time.Duration(app.config.ShutdownTimeoutSec) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- server.Shutdown(ctx) }()
+
+	sessionsDone := app.sessions.drain()
+	active := app.sessions.broadcast(fmt.Sprintf("\r\n*** Бастион перезапускается. Сессия будет закрыта через %d с ***\r\n", app.config.ShutdownTimeoutSec), false)
+	app.logger.Info("Waiting for active sessions to finish", zap.Int("sessions", active), zap.Duration("timeout", timeout))
+	select {
+	case <-sessionsDone:
+		app.logger.Info("All sessions finished")
+	case <-ctx.Done():
+		app.sessions.closing.Store(true)
+		active = app.sessions.broadcast("\r\n*** Бастион перезапускается. Сессия закрыта ***\r\n", true)
+		app.logger.Warn("Shutdown timeout, closing active sessions", zap.Int("sessions", active))
+		select {
+		case <-sessionsDone:
+		case <-time.After(sessionCloseTimeout):
+			app.logger.Error("Sessions did not finish after closing")
+		}
+	}
+	cancel()
+	<-shutdownDone
+	if err := server.Close(); err != nil {
+		app.logger.Error(err.Error())
+	}
+	app.reporter.close(reportCloseTimeout)
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type fakeSession struct {
+	bytes.Buffer
+	registry *sessionRegistry
+	closed   bool
+}
+
+func (s *fakeSession) Close() error {
+	s.closed = true
+	go s.registry.remove(s) // Обработчик сессии завершается после закрытия
+	return nil
+}
+
+func TestSessionRegistryDrain(t *testing.T) {
+	r := &sessionRegistry{}
+	interactive := &fakeSession{registry: r}
+	forwarded := &fakeSession{registry: r}
+	if !r.add(interactive, true) || !r.add(forwarded, false) {
+		t.Fatal("sessions not accepted before shutdown")
+	}
+	done := r.drain()
+	if r.accepting() || r.add(&fakeSession{registry: r}, false) {
+		t.Error("new sessions accepted while draining")
+	}
+	if n := r.broadcast("bye", false); n != 2 {
+		t.Errorf("broadcast reached %d sessions, want 2", n)
+	}
+	if interactive.String() != "bye" || forwarded.Len() != 0 {
+		t.Errorf("message written to interactive %q, forwarded %q; want only interactive", interactive.String(), forwarded.String())
+	}
+	select {
+	case <-done:
+		t.Fatal("drain finished with active sessions")
+	default:
+	}
+	r.broadcast("", true)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not finish after closing sessions")
+	}
+	if !interactive.closed || !forwarded.closed {
+		t.Error("sessions were not closed")
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"errors"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
 	"io"
@@ -110,6 +111,14 @@ func (sess BastionSSHSession) StartShell(term string, h int, w int, environment
 	return nil
 }
 
+func (sess BastionSSHSession) StartSubsystem(name string) error {
+	if err := sess.session.RequestSubsystem(name); err != nil {
+		sess.logger.Error("Error requesting \"subsystem\"", zap.String("subsystem", name))
+		return err
+	}
+	return nil
+}
+
 func (sess BastionSSHSession) SetEnvironment(envs []string) {
 	for _, env := range envs {
 		kv := strings.Split(env, "=")
@@ -130,6 +139,28 @@ func (sess BastionSSHSession) ResizeWindow(h, w int) {
 	}
 }
 
+// ExitStatus ожидает не дольше timeout завершения оболочки на цели и возвращает её код завершения.
+// Возвращает false, если код не получен (например, соединение прервано или оболочка ещё работает)
+func (sess BastionSSHSession) ExitStatus(timeout time.Duration) (int, bool) {
+	result := make(chan error, 1)
+	go func() {
+		result <- sess.session.Wait()
+	}()
+	select {
+	case err := <-result:
+		if err == nil {
+			return 0, true
+		}
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitStatus(), true
+		}
+		return 0, false
+	case <-time.After(timeout):
+		return 0, false
+	}
+}
+
 func (sess BastionSSHSession) Close() {
 	err := sess.session.Close()
 	if err != nil {
@@ -0,0 +1,266 @@
+package search
+
+import (
+	"bastion/internal/api"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Потоки сессии, которые записывает в журнал прокси (stream_id)
+var (
+	inputStreams  = []string{"target stdin"}
+	outputStreams = []string{"target stdout", "target stderr", "target screen"}
+)
+
+// Config задаёт Elasticsearch (или совместимый с ним сервер), в который попадает журнал прокси.
+// Index может быть шаблоном, например bastion-*
+type Config struct {
+	URL      string
+	Index    string
+	Username string
+	Password string
+}
+
+// Client ищет строки сессий в журнале прокси, проиндексированном в Elasticsearch
+type Client struct {
+	config     Config
+	httpClient *http.Client
+}
+
+func New(c Config) *Client {
+	c.URL = strings.TrimRight(c.URL, "/")
+	return &Client{config: c, httpClient: &http.Client{Timeout: requestTimeout}}
+}
+
+type esQuery map[string]interface{}
+
+type esHit struct {
+	Source struct {
+		Token    string `json:"token"`
+		StreamID string `json:"stream_id"`
+		Message  string `json:"message"`
+	} `json:"_source"`
+	Sort      []json.Number       `json:"sort"`
+	Highlight map[string][]string `json:"highlight"`
+}
+
+type esSearchResponse struct {
+	Hits struct {
+		Hits []esHit `json:"hits"`
+	} `json:"hits"`
+}
+
+func (h esHit) line() api.SessionLine {
+	l := api.SessionLine{StreamID: h.Source.StreamID, Message: h.Source.Message}
+	if len(h.Sort) > 0 {
+		l.Time, _ = h.Sort[0].Int64()
+	}
+	return l
+}
+
+// Search находит строки сессий, содержащие фразу q.Text, начиная с самых новых, и группирует их по сессиям
+func (c *Client) Search(q api.SessionSearchQuery) ([]api.SessionSearchResult, error) {
+	filter := []esQuery{
+		{"exists": esQuery{"field": "token"}},
+	}
+	switch q.Stream {
+	case api.SearchStreamInput:
+		filter = append(filter, esQuery{"terms": esQuery{"stream_id": inputStreams}})
+	case api.SearchStreamOutput:
+		filter = append(filter, esQuery{"terms": esQuery{"stream_id": outputStreams}})
+	default:
+		filter = append(filter, esQuery{"terms": esQuery{"stream_id": append(append([]string(nil), inputStreams...), outputStreams...)}})
+	}
+	if timeRange := timeRange(q.From*1000, q.To*1000); timeRange != nil {
+		filter = append(filter, timeRange)
+	}
+	query := esQuery{
+		"size": q.Limit,
+		"sort": []esQuery{{"time": "desc"}},
+		"query": esQuery{"bool": esQuery{
+			"must":   esQuery{"match_phrase": esQuery{"message": q.Text}},
+			"filter": filter,
+		}},
+		"highlight": esQuery{
+			"pre_tags":  []string{api.SearchHighlightStart},
+			"post_tags": []string{api.SearchHighlightEnd},
+			"fields":    esQuery{"message": esQuery{"number_of_fragments": 0}},
+		},
+	}
+	var response esSearchResponse
+	if err := c.post("/"+c.config.Index+"/_search", "application/json", query, &response); err != nil {
+		return nil, err
+	}
+
+	results := []api.SessionSearchResult{}
+	sessions := map[string]int{}
+	for _, hit := range response.Hits.Hits {
+		m := api.SessionSearchMatch{SessionLine: hit.line(), Highlight: hit.Source.Message}
+		if fragments := hit.Highlight["message"]; len(fragments) > 0 {
+			m.Highlight = fragments[0]
+		}
+		i, ok := sessions[hit.Source.Token]
+		if !ok {
+			i = len(results)
+			sessions[hit.Source.Token] = i
+			results = append(results, api.SessionSearchResult{Token: hit.Source.Token})
+		}
+		results[i].Matches = append(results[i].Matches, m)
+	}
+	if q.Context > 0 && len(results) > 0 {
+		if err := c.addContext(results, q.Context); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// addContext дополняет найденные строки соседними строками той же сессии одним запросом Multi Search API
+func (c *Client) addContext(results []api.SessionSearchResult, size int) error {
+	var body bytes.Buffer
+	header, _ := json.Marshal(esQuery{"index": c.config.Index})
+	for _, r := range results {
+		for _, m := range r.Matches {
+			for _, q := range []esQuery{
+				contextQuery(r.Token, timeRange(0, m.Time), "desc", size),
+				contextQuery(r.Token, esQuery{"range": esQuery{"time": esQuery{"gt": m.Time, "format": "epoch_millis"}}}, "asc", size),
+			} {
+				data, err := json.Marshal(q)
+				if err != nil {
+					return err
+				}
+				body.Write(header)
+				body.WriteByte('\n')
+				body.Write(data)
+				body.WriteByte('\n')
+			}
+		}
+	}
+	var response struct {
+		Responses []esSearchResponse `json:"responses"`
+	}
+	if err := c.post("/_msearch", "application/x-ndjson", body.Bytes(), &response); err != nil {
+		return err
+	}
+	n := 0
+	for i := range results {
+		for j := range results[i].Matches {
+			if n+1 >= len(response.Responses) {
+				return fmt.Errorf("multi search returned %d responses", len(response.Responses))
+			}
+			m := &results[i].Matches[j]
+			before := response.Responses[n].Hits.Hits
+			m.Before = make([]api.SessionLine, len(before))
+			for k, hit := range before {
+				m.Before[len(before)-1-k] = hit.line()
+			}
+			m.After = []api.SessionLine{}
+			for _, hit := range response.Responses[n+1].Hits.Hits {
+				m.After = append(m.After, hit.line())
+			}
+			n += 2
+		}
+	}
+	return nil
+}
+
+func contextQuery(token string, timeFilter esQuery, order string, size int) esQuery {
+	return esQuery{
+		"size": size,
+		"sort": []esQuery{{"time": order}},
+		"query": esQuery{"bool": esQuery{"filter": []esQuery{
+			{"term": esQuery{"token": token}},
+			{"exists": esQuery{"field": "stream_id"}},
+			timeFilter,
+		}}},
+	}
+}
+
+// Transcript возвращает строки сессии в порядке записи, начиная с момента from (миллисекунды Unix).
+// Первые skip строк пропускаются: так следующая страница продолжается с последней строки предыдущей,
+// даже если несколько строк записаны в одну миллисекунду
+func (c *Client) Transcript(token string, from int64, skip, limit int) ([]api.SessionLine, error) {
+	filter := []esQuery{
+		{"term": esQuery{"token": token}},
+		{"exists": esQuery{"field": "stream_id"}},
+	}
+	if from > 0 {
+		filter = append(filter, esQuery{"range": esQuery{"time": esQuery{"gte": from, "format": "epoch_millis"}}})
+	}
+	query := esQuery{
+		"from":  skip,
+		"size":  limit,
+		"sort":  []esQuery{{"time": "asc"}},
+		"query": esQuery{"bool": esQuery{"filter": filter}},
+	}
+	var response esSearchResponse
+	if err := c.post("/"+c.config.Index+"/_search", "application/json", query, &response); err != nil {
+		return nil, err
+	}
+	lines := make([]api.SessionLine, 0, len(response.Hits.Hits))
+	for _, hit := range response.Hits.Hits {
+		lines = append(lines, hit.line())
+	}
+	return lines, nil
+}
+
+// timeRange возвращает фильтр по времени (миллисекунды Unix, to не включается) или nil, если границы не заданы
+func timeRange(from, to int64) esQuery {
+	if from <= 0 && to <= 0 {
+		return nil
+	}
+	r := esQuery{"format": "epoch_millis"}
+	if from > 0 {
+		r["gte"] = from
+	}
+	if to > 0 {
+		r["lt"] = to
+	}
+	return esQuery{"range": esQuery{"time": r}}
+}
+
+// post отправляет запрос (req - готовое тело []byte или значение для кодирования в JSON) и декодирует ответ в resp
+func (c *Client) post(path, contentType string, req interface{}, resp interface{}) error {
+	body, ok := req.([]byte)
+	if !ok {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequest(http.MethodPost, c.config.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	if c.config.Username != "" {
+		request.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("search backend responded with status %d: %s", response.StatusCode, truncate(data, 512))
+	}
+	return json.Unmarshal(data, resp)
+}
+
+func truncate(data []byte, n int) string {
+	if len(data) > n {
+		data = data[:n]
+	}
+	return string(data)
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// runAdminListener обслуживает метрики Prometheus (/metrics) на отдельном адресе address, чтобы они не были
+// доступны на публичном адресе рядом с API пользователей
+func (app *BastionServer) runAdminListener(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	app.logger.Info("Admin listener listening", zap.String("address", address))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		app.logger.Error("Admin listener failed", zap.Error(err))
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"bastion/internal/auth"
 	"bastion/internal/datastore"
 	"bastion/internal/log"
+	"bastion/internal/search"
+	"bastion/internal/tracing"
 	"context"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/coreos/go-oidc"
 	"github.com/gorilla/sessions"
@@ -22,14 +27,17 @@ type BastionServer struct {
 	config     ConfigStruct
 	oidcClient *auth.OIDCClient
 	keySet     oidc.KeySet
+	sshCA      *auth.SSHCertificateAuthority
 	web        *echo.Echo
 	templates  *template.Template
 	sessions   sessions.Store
 	logger     *zap.Logger
+	search     *search.Client // nil, если поиск по тексту сессий не настроен
 }
 
 const (
 	requestLoggerContextKey = "requestLogger"
+	shutdownTimeout         = 10 * time.Second // Время на завершение обрабатываемых запросов при остановке
 )
 
 func New() BastionServer {
@@ -40,6 +48,23 @@ func New() BastionServer {
 		fmt.Printf("Error initializing logging: %s\n", err)
 		os.Exit(1)
 	}
+	appLogger, err = log.InitAuditChain(log.AuditChainConfig{
+		File:               config.AuditLog.File,
+		SigningKeyFile:     config.AuditLog.SigningKeyFile,
+		CheckpointInterval: config.AuditLog.CheckpointInterval,
+	})
+	if err != nil {
+		appLogger.Fatal(err.Error())
+	}
+
+	err = tracing.Init("bastion-server", tracing.Config{
+		Endpoint:    config.Tracing.Endpoint,
+		Insecure:    config.Tracing.Insecure,
+		SampleRatio: config.Tracing.SampleRatio,
+	})
+	if err != nil {
+		appLogger.Fatal(err.Error())
+	}
 
 	datastore.Configure(datastore.Config{
 		Logger:         appLogger,
@@ -70,6 +95,24 @@ func New() BastionServer {
 	// TODO jwks_uri нужно автоматически получать из discovery (.well-known/openid-configuration)
 	app.keySet = oidc.NewRemoteKeySet(context.Background(), "https://idp.example.com/discovery/keys")
 
+	if app.config.SSHCA.KeyFile != "" {
+		app.sshCA, err = auth.NewSSHCertificateAuthority(app.config.SSHCA.KeyFile, time.Second*time.Duration(app.config.SSHCA.CertificateTTLSeconds))
+		if err != nil {
+			appLogger.Fatal(err.Error())
+		}
+		appLogger.Info("SSH certificate authority enabled", zap.String("ca_public_key", app.sshCA.PublicKey()))
+	}
+
+	if app.config.Search.URL != "" {
+		app.search = search.New(search.Config{
+			URL:      app.config.Search.URL,
+			Index:    app.config.Search.Index,
+			Username: app.config.Search.Username,
+			Password: app.config.Search.Password,
+		})
+		appLogger.Info("Session search enabled", zap.String("index", app.config.Search.Index))
+	}
+
 	app.web.HideBanner = true
 	app.web.Debug = true
 	app.web.Renderer = &app
@@ -83,12 +126,17 @@ func New() BastionServer {
 	}))
 	app.web.Pre(middleware.RemoveTrailingSlash())
 	app.web.Pre(app.XRequestIDMiddleware)
+	app.web.Use(MetricsMiddleware)
+	app.web.Use(app.TracingMiddleware)
 
 	app.web.Static("/", config.Web.StaticContentDir)
 	app.web.GET("/", func(context echo.Context) error {
 		return context.Redirect(http.StatusMovedPermanently, "/app/main")
 	})
 
+	app.web.GET("/healthz", app.healthzHandler)
+	app.web.GET("/readyz", app.readyzHandler)
+
 	front := app.web.Group("/app")
 	front.Use(app.OIDCMiddleware)
 	front.GET("/main", app.indexHandler)
@@ -103,18 +151,60 @@ func New() BastionServer {
 
 	api.POST("/sessions", app.createSessionHandler)
 	api.GET("/sessions/:token", app.readSessionHandler)
+	api.POST("/sessionevents", app.sessionEventHandler)
+	api.GET("/history", app.historyHandler)
+	api.GET("/search", app.searchHandler)
+	api.GET("/transcripts/:token", app.transcriptHandler)
 	// api.DELETE("/sessions/:token", app.DeleteSessionHandler)
 
 	api.POST("/sessiontemplates", app.createSessionTemplateHandler)
 	api.DELETE("/sessiontemplates/:id", app.deleteSessionTemplateHandler)
 
+	api.POST("/sshcerts", app.createSSHCertificateHandler)
+	api.GET("/sshconfig", app.sshConfigHandler)
+	api.POST("/directsessions", app.createDirectSessionHandler)
+	api.POST("/forwardingpolicies", app.forwardingPolicyHandler)
+
+	api.POST("/sshkeys", app.createSSHKeyHandler)
+	api.DELETE("/sshkeys/:id", app.deleteSSHKeyHandler)
+	api.POST("/keyauth", app.publicKeyAuthHandler)
+
+	api.POST("/mfa/enroll", app.enrollMFAHandler)
+	api.POST("/mfa/confirm", app.confirmMFAHandler)
+	api.DELETE("/mfa/:user", app.resetMFAHandler)
+
 	app.initSessionStore()
 	return app
 }
 
-func (app *BastionServer) Run() {
+// Run обслуживает запросы до получения SIGINT или SIGTERM либо до ошибки сервера. Возвращает ошибку сервера;
+// в обоих случаях после Run нужно вызвать Shutdown, чтобы записать завершающую контрольную точку журнала аудита
+func (app *BastionServer) Run() error {
+	if app.config.Admin.BindAddress != "" {
+		go app.runAdminListener(app.config.Admin.BindAddress)
+	}
 	app.logger.Info("Bastion server listening", zap.String("address", app.config.BindAddress))
-	app.web.Logger.Fatal(app.web.StartTLS(app.config.BindAddress, app.config.TLS.CertificateFile, app.config.TLS.KeyFile))
+	served := make(chan error, 1)
+	go func() {
+		served <- app.web.StartTLS(app.config.BindAddress, app.config.TLS.CertificateFile, app.config.TLS.KeyFile)
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-served:
+		app.logger.Error(err.Error())
+		return err
+	case sig := <-signals:
+		// Повторный сигнал завершает процесс немедленно
+		signal.Stop(signals)
+		app.logger.Info("Shutting down", zap.String("signal", sig.String()))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := app.web.Shutdown(ctx); err != nil {
+		app.logger.Error("Error waiting for requests to finish", zap.String("error", err.Error()))
+	}
+	return nil
 }
 
 func (app *BastionServer) Shutdown() {
@@ -122,6 +212,12 @@ func (app *BastionServer) Shutdown() {
 		app.logger.Error(err.Error())
 	}
 	app.logger.Info("Shutdown")
+	if err := tracing.Shutdown(); err != nil {
+		app.logger.Error(err.Error())
+	}
+	if err := log.CloseAuditChain(); err != nil {
+		app.logger.Error(err.Error())
+	}
 	_ = app.logger.Sync()
 }
 
@@ -23,6 +23,7 @@ type ConfigStruct struct {
 		RedirectURL                  string
 		SessionTTLSeconds            int
 		AllowedConfidentialClientIDs []string `yaml:"AllowedConfidentialClientIDs,flow"`
+		AllowedPublicClientIDs       []string `yaml:"AllowedPublicClientIDs,flow"`
 	}
 	Web struct {
 		StaticContentDir string
@@ -32,6 +33,33 @@ type ConfigStruct struct {
 	Datastore struct {
 		DataSourceName string
 	}
+	MFA struct {
+		Issuer string
+	}
+	SSHCA struct {
+		KeyFile               string
+		CertificateTTLSeconds int
+	}
+	Search struct {
+		URL      string
+		Index    string
+		Username string
+		Password string
+	}
+	AuditLog struct {
+		File               string
+		SigningKeyFile     string
+		CheckpointInterval int
+	}
+	Tracing struct {
+		Endpoint    string
+		Insecure    bool
+		SampleRatio float64
+	}
+	Admin struct {
+		BindAddress string // Адрес для /metrics, выключено, если пусто
+	}
+	AdminSIDs   []string `yaml:"AdminSIDs,flow"`
 	BindAddress string
 }
 
@@ -55,6 +83,7 @@ func LoadConfiguration() ConfigStruct {
 	pflag.StringVar(&config.OIDC.RedirectURL, "oidc-redirect-url", "", "OIDC redirect URL (mandatory)")
 	pflag.IntVar(&config.OIDC.SessionTTLSeconds, "oidc-session-ttl", 3600, "Duration of session, in seconds")
 	pflag.StringArrayVar(&config.OIDC.AllowedConfidentialClientIDs, "oidc-allowed-client-id", nil, "Allowed confidential client (mandatory)")
+	pflag.StringArrayVar(&config.OIDC.AllowedPublicClientIDs, "oidc-allowed-public-client-id", nil, "Public client (e.g. command line client) allowed to present users' ID tokens")
 
 	pflag.StringVar(&config.Web.StaticContentDir, "web-static", "", "Path to static web content for web frontend (mandatory)")
 	pflag.StringVar(&config.Web.TemplatesDir, "web-templates", "", "Path to HTML template files (mandatory)")
@@ -62,6 +91,28 @@ func LoadConfiguration() ConfigStruct {
 
 	pflag.StringVar(&config.Datastore.DataSourceName, "datastore-dsn", "", "Data Source Name to connect to (mandatory)")
 
+	pflag.StringVar(&config.MFA.Issuer, "mfa-issuer", "Bastion", "Issuer name shown in TOTP authenticator applications")
+
+	pflag.StringVar(&config.SSHCA.KeyFile, "ssh-ca-key-file", "", "Private key of SSH certificate authority issuing user certificates (CA mode disabled if empty)")
+	pflag.IntVar(&config.SSHCA.CertificateTTLSeconds, "ssh-ca-cert-ttl", 3600, "Validity period of issued SSH user certificates, in seconds")
+
+	pflag.StringVar(&config.Search.URL, "search-es-url", "", "URL of Elasticsearch with indexed session logs (session search disabled if empty)")
+	pflag.StringVar(&config.Search.Index, "search-es-index", "bastion-*", "Elasticsearch index (pattern) with session logs")
+	pflag.StringVar(&config.Search.Username, "search-es-username", "", "Elasticsearch user name")
+	pflag.StringVar(&config.Search.Password, "search-es-password", "", "Elasticsearch password")
+
+	pflag.StringVar(&config.AuditLog.File, "audit-log", "", "Tamper-evident hash-chained audit log file (disabled if empty)")
+	pflag.StringVar(&config.AuditLog.SigningKeyFile, "audit-signing-key", "", "Ed25519 private key (PEM) to sign audit log checkpoints with (checkpoints disabled if empty)")
+	pflag.IntVar(&config.AuditLog.CheckpointInterval, "audit-checkpoint-interval", 100, "Number of audit log records between signed checkpoints")
+
+	pflag.StringVar(&config.Tracing.Endpoint, "tracing-endpoint", "", "OTLP/HTTP collector address host:port to export traces to (tracing disabled if empty)")
+	pflag.BoolVar(&config.Tracing.Insecure, "tracing-insecure", false, "Export traces over plain HTTP instead of HTTPS")
+	pflag.Float64Var(&config.Tracing.SampleRatio, "tracing-sample-ratio", 1, "Fraction of traces started by this service to record, 0..1")
+
+	pflag.StringArrayVar(&config.AdminSIDs, "admin-sid", nil, "SID of user allowed to perform administrative actions (e.g. reset second factor)")
+
+	pflag.StringVar(&config.Admin.BindAddress, "admin-address", "", "The IP address and port on which to serve /metrics (disabled if empty)")
+
 	pflag.StringVar(&config.BindAddress, "bind-address", "0.0.0.0:1443", "The IP address and port on which to listen for HTTPS requests")
 
 	pflag.Parse()
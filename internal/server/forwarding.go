@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/datastore"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// forwardingPolicyHandler возвращает прокси сеть мандата и список целей, к которым пользователю разрешён проброс
+// TCP-портов. Доступно только конфиденциальным клиентам (прокси). Проброс работает без интерактивного терминала,
+// поэтому для мандатов, требующих второй фактор, он разрешён только при аутентификации по сертификату
+// (код проверен при его выпуске); в остальных случаях возвращается 428 Precondition Required
+func (app *BastionServer) forwardingPolicyHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	clientID, ok := context.Get("ClientID").(string)
+	if !ok {
+		rl.Warn("Forwarding policies can be requested by confidential clients only")
+		return context.NoContent(http.StatusForbidden)
+	}
+	var req api.ForwardingPolicyRequestDTO
+	if err := context.Bind(&req); err != nil {
+		rl.Warn(err.Error())
+		return context.NoContent(http.StatusBadRequest)
+	}
+	rl = rl.With(zap.String("client_id", clientID), zap.String("user_sid", req.UserName),
+		zap.String("auth_method", req.AuthMethod), zap.Int("mandate_id", req.MandateID))
+	if err := checkMandate(context.Request().Context(), req.UserName, req.MandateID); err != nil {
+		rl.Warn(err.Error())
+		return context.NoContent(http.StatusForbidden)
+	}
+	if req.AuthMethod != api.AuthMethodCertificate {
+		requiresMFA, err := datastore.MandateRequiresMFA(context.Request().Context(), req.MandateID)
+		if err != nil {
+			rl.Error(err.Error())
+			return context.NoContent(http.StatusInternalServerError)
+		}
+		if requiresMFA {
+			rl.Warn("Port forwarding by mandate requiring second factor is allowed with SSH certificate only")
+			return context.NoContent(http.StatusPreconditionRequired)
+		}
+	}
+	network, err := datastore.NetworkByMandateID(context.Request().Context(), req.MandateID)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	targets, err := datastore.MandateForwardTargets(context.Request().Context(), req.MandateID)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	return context.JSON(http.StatusOK, api.ForwardingPolicyDTO{TargetNetwork: network.Name, Targets: targets})
+}
@@ -3,6 +3,9 @@ package server
 import (
 	"bastion/internal/api"
 	"bastion/internal/datastore"
+	"bastion/internal/log"
+	"context"
+	"database/sql"
 	"errors"
 	"github.com/labstack/echo/v4"
 	uuid "github.com/satori/go.uuid"
@@ -31,12 +34,14 @@ func (app *BastionServer) authCallback(context echo.Context) error {
 	if stateTokenExpected != stateTokenGiven {
 		err := errors.New("state verification failed")
 		rl.Error(err.Error())
+		oidcFailures.WithLabelValues(oidcFailureState).Inc()
 		return context.NoContent(http.StatusInternalServerError)
 	}
 
 	token, err := app.oidcClient.FetchToken(params.Get("code"), rl)
 	if err != nil {
 		rl.Error(err.Error())
+		oidcFailures.WithLabelValues(oidcFailureCodeExchange).Inc()
 		return context.NoContent(http.StatusInternalServerError)
 	}
 	err = app.saveTokenToSession(token, context)
@@ -44,6 +49,9 @@ func (app *BastionServer) authCallback(context echo.Context) error {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
 	}
+	if rawIDToken, ok := token.Extra("id_token").(string); ok {
+		app.updateUserLogin(context.Request().Context(), rawIDToken, rl)
+	}
 	return context.Redirect(http.StatusFound, "/app/main")
 }
 
@@ -69,17 +77,17 @@ func (app *BastionServer) indexHandler(context echo.Context) error {
 	data := indexTemplateData{}
 	data.DisplayName = context.Get("DisplayName").(string)
 	data.Email = context.Get("Email").(string)
-	data.Mandates, err = datastore.Mandates(userName)
+	data.Mandates, err = datastore.Mandates(context.Request().Context(), userName)
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
 	}
-	data.Protocols, err = datastore.Protocols()
+	data.Protocols, err = datastore.Protocols(context.Request().Context())
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
 	}
-	data.Networks, err = datastore.Networks()
+	data.Networks, err = datastore.Networks(context.Request().Context())
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
@@ -100,6 +108,9 @@ func (app *BastionServer) createSessionHandler(context echo.Context) error {
 		return context.NoContent(http.StatusInternalServerError)
 	}
 	for param := range params {
+		if param == "mfa_code" {
+			continue
+		}
 		rl.Debug("CreateSession data dump", zap.String("key", param), zap.String("value", params.Get(param)))
 	}
 
@@ -124,12 +135,23 @@ func (app *BastionServer) createSessionHandler(context echo.Context) error {
 			rl.Error(err.Error())
 			return context.NoContent(http.StatusInternalServerError)
 		}
-		err = checkMandate(userName, mandateID)
+		err = checkMandate(context.Request().Context(), userName, mandateID)
 		if err != nil {
 			rl.Error(err.Error())
+			log.Audit(rl, log.AuditEvent{Event: log.AuditAuthFailed, User: userName, ClientIP: context.RealIP(), MandateID: mandateID, Error: err.Error()})
 			return context.NoContent(http.StatusInternalServerError)
 		}
-		targetNetwork, err = datastore.NetworkByMandateID(mandateID)
+		err = checkSecondFactor(context.Request().Context(), userName, mandateID, params.Get("mfa_code"))
+		if errors.Is(err, errSecondFactorRequired) || errors.Is(err, errSecondFactorInvalid) {
+			rl.Warn(err.Error(), zap.Int("mandate_id", mandateID))
+			log.Audit(rl, log.AuditEvent{Event: log.AuditAuthFailed, User: userName, ClientIP: context.RealIP(), MandateID: mandateID, Error: err.Error()})
+			return context.NoContent(http.StatusForbidden)
+		}
+		if err != nil {
+			rl.Error(err.Error())
+			return context.NoContent(http.StatusInternalServerError)
+		}
+		targetNetwork, err = datastore.NetworkByMandateID(context.Request().Context(), mandateID)
 		if err != nil {
 			rl.Error(err.Error())
 			return context.NoContent(http.StatusInternalServerError)
@@ -140,7 +162,7 @@ func (app *BastionServer) createSessionHandler(context echo.Context) error {
 			rl.Error(err.Error())
 			return context.NoContent(http.StatusInternalServerError)
 		}
-		targetNetwork, err = datastore.NetworkByID(networkID)
+		targetNetwork, err = datastore.NetworkByID(context.Request().Context(), networkID)
 		if err != nil {
 			rl.Error(err.Error())
 			return context.NoContent(http.StatusInternalServerError)
@@ -167,11 +189,27 @@ func (app *BastionServer) createSessionHandler(context echo.Context) error {
 
 	session.CustomTargetPrivKey = normalizeKey(session.CustomTargetPrivKey)
 	sessionToken := uuid.NewV4().String()
-	err = datastore.CreateSession(sessionToken, session)
+	err = datastore.CreateSession(context.Request().Context(), sessionToken, session)
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
 	}
+	err = datastore.CreateSessionHistory(context.Request().Context(), sessionToken, session, targetNetwork.ID, "")
+	if err != nil {
+		rl.Error(err.Error())
+		_ = datastore.DeleteSession(context.Request().Context(), sessionToken) // Сессия без записи в истории не выдаётся
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	sessionsCreated.WithLabelValues(sessionKindWeb).Inc()
+	log.Audit(rl, log.AuditEvent{
+		Event:         log.AuditTokenIssued,
+		User:          userName,
+		ClientIP:      session.OriginIP,
+		MandateID:     mandateID,
+		TargetNetwork: targetNetwork.Name,
+		TargetHost:    session.TargetHost,
+		TargetPort:    strconv.Itoa(session.TargetPort),
+	})
 
 	return context.JSON(http.StatusOK, api.SessionLocatorDTO{
 		Token:        sessionToken,
@@ -184,17 +222,34 @@ func (app *BastionServer) createSessionHandler(context echo.Context) error {
 func (app *BastionServer) readSessionHandler(context echo.Context) error {
 	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
 	token := context.Param("token")
-	session, err := datastore.Session(token)
+	session, err := datastore.Session(context.Request().Context(), token)
 	if err != nil {
 		rl.Error(err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Audit(rl, log.AuditEvent{Event: log.AuditAuthFailed, Reason: "unknown session token"})
+		}
 		return context.NoContent(http.StatusInternalServerError)
 	}
+	e := auditSessionEvent(log.AuditTokenRedeemed, session)
+	// Пользователь и мандат сессии хранятся в её записи истории
+	record, err := datastore.SessionHistoryByToken(context.Request().Context(), token)
+	if err != nil {
+		rl.Error(err.Error())
+	}
+	e.User, e.AuthMethod, e.MandateID = record.UserName, record.AuthMethod, record.MandateID
+	log.Audit(rl, e)
+	clientID, _ := context.Get("ClientID").(string)
+	err = datastore.RedeemSessionHistory(context.Request().Context(), token, clientID)
+	if err != nil {
+		rl.Error(err.Error())
+	}
+	session.Token = token
 	err = context.JSON(http.StatusOK, session)
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
 	}
-	err = datastore.DeleteSession(token) // Одноразовый доступ к сессии по токену
+	err = datastore.DeleteSession(context.Request().Context(), token) // Одноразовый доступ к сессии по токену
 	if err != nil {
 		rl.Error(err.Error())
 		// Удалить хоть и не получилось, но ответ мы сформировали и нужно его отправить, поэтому не возвращаемся с ошибкой
@@ -239,7 +294,7 @@ func (app *BastionServer) createSessionTemplateHandler(context echo.Context) err
 			rl.Error(err.Error())
 			return context.NoContent(http.StatusInternalServerError)
 		}
-		err = checkMandate(userName, mandateID)
+		err = checkMandate(context.Request().Context(), userName, mandateID)
 		if err != nil {
 			rl.Error(err.Error())
 			return context.NoContent(http.StatusInternalServerError)
@@ -269,7 +324,7 @@ func (app *BastionServer) createSessionTemplateHandler(context echo.Context) err
 		CustomTargetPrivKey:   params.Get("custom_key"),
 	}
 
-	err = datastore.CreateSessionTemplate(userName, st)
+	err = datastore.CreateSessionTemplate(context.Request().Context(), userName, st)
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
@@ -289,7 +344,7 @@ func (app *BastionServer) deleteSessionTemplateHandler(context echo.Context) err
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
 	}
-	err = datastore.DeleteSessionTemplate(userName, id)
+	err = datastore.DeleteSessionTemplate(context.Request().Context(), userName, id)
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
@@ -306,17 +361,32 @@ func (app *BastionServer) readUserData(context echo.Context) error {
 	}
 	var err error
 	data := api.ReadUserDTO{}
-	data.User, err = datastore.User(userName)
+	data.User, err = datastore.User(context.Request().Context(), userName)
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
 	}
-	data.Mandates, err = datastore.Mandates(userName)
+	_, data.MFAEnabled, err = datastore.UserTOTP(context.Request().Context(), userName)
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
 	}
-	data.SessionTemplates, err = datastore.SessionTemlates(userName)
+	data.SSHKeys, err = datastore.UserSSHKeys(context.Request().Context(), userName)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	data.Protocols, err = datastore.Protocols(context.Request().Context())
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	data.Mandates, err = datastore.Mandates(context.Request().Context(), userName)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	data.SessionTemplates, err = datastore.SessionTemlates(context.Request().Context(), userName)
 	if err != nil {
 		rl.Error(err.Error())
 		return context.NoContent(http.StatusInternalServerError)
@@ -324,8 +394,8 @@ func (app *BastionServer) readUserData(context echo.Context) error {
 	return context.JSON(http.StatusOK, data)
 }
 
-func checkMandate(userName string, givenMandateID int) error {
-	userAssignedMandates, err := datastore.Mandates(userName)
+func checkMandate(ctx context.Context, userName string, givenMandateID int) error {
+	userAssignedMandates, err := datastore.Mandates(ctx, userName)
 	if err != nil {
 		return err
 	}
@@ -351,3 +421,15 @@ func normalizeKey(key string) string {
 	}
 	return ""
 }
+
+// auditSessionEvent возвращает событие аудита с данными клиента и цели сессии
+func auditSessionEvent(event string, session api.ReadSessionDTO) log.AuditEvent {
+	return log.AuditEvent{
+		Event:          event,
+		ClientIP:       session.OriginIP,
+		TargetNetwork:  session.TargetNetwork,
+		TargetProtocol: session.TargetProtocol,
+		TargetHost:     session.TargetHost,
+		TargetPort:     session.TargetPort,
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/datastore"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const readinessCheckTimeout = 5 * time.Second
+
+// healthzHandler сообщает, что процесс сервера работает и обслуживает запросы
+func (app *BastionServer) healthzHandler(context echo.Context) error {
+	return context.String(http.StatusOK, api.HealthOK)
+}
+
+// readyzHandler проверяет зависимости, без которых сервер не может выдавать сессии: БД и сервер авторизации.
+// Если хотя бы одна проверка не пройдена, возвращается 503
+func (app *BastionServer) readyzHandler(ctx echo.Context) error {
+	rl := ctx.Get(requestLoggerContextKey).(*zap.Logger)
+	health := api.HealthDTO{Status: api.HealthOK, Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			rl.Warn("Readiness check failed", zap.String("check", name), zap.Error(err))
+			health.Status = api.HealthUnavailable
+			health.Checks[name] = err.Error()
+			return
+		}
+		health.Checks[name] = api.HealthOK
+	}
+	dbCtx, cancel := context.WithTimeout(ctx.Request().Context(), readinessCheckTimeout)
+	defer cancel()
+	check("datastore", datastore.Ping(dbCtx))
+	oidcCtx, cancel := context.WithTimeout(ctx.Request().Context(), readinessCheckTimeout)
+	defer cancel()
+	check("oidc", app.oidcClient.CheckProvider(oidcCtx))
+
+	if health.Status != api.HealthOK {
+		return ctx.JSON(http.StatusServiceUnavailable, health)
+	}
+	return ctx.JSON(http.StatusOK, health)
+}
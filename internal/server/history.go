@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/datastore"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// historyHandler возвращает историю сессий. Параметры запроса user, network, host, from и to (секунды Unix)
+// и limit задают фильтр. Администраторы видят сессии всех пользователей, остальные - только свои
+func (app *BastionServer) historyHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	filter := api.SessionHistoryFilter{
+		UserName:      context.QueryParam("user"),
+		TargetNetwork: context.QueryParam("network"),
+		TargetHost:    context.QueryParam("host"),
+		Limit:         defaultHistoryLimit,
+	}
+	if !app.isAdmin(userName) {
+		if filter.UserName != "" && filter.UserName != userName {
+			rl.Warn("Session history of another user requested", zap.String("requested_user", filter.UserName))
+			return context.NoContent(http.StatusForbidden)
+		}
+		filter.UserName = userName
+	}
+	for param, value := range map[string]*int64{"from": &filter.From, "to": &filter.To} {
+		if !parseInt64Param(context, param, value, 0, 1<<40) {
+			rl.Warn("Malformed time range", zap.String(param, context.QueryParam(param)))
+			return context.NoContent(http.StatusBadRequest)
+		}
+	}
+	if !parseIntParam(context, "limit", &filter.Limit, 1, maxHistoryLimit) {
+		rl.Warn("Malformed limit", zap.String("limit", context.QueryParam("limit")))
+		return context.NoContent(http.StatusBadRequest)
+	}
+	records, err := datastore.SessionHistory(context.Request().Context(), filter)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	return context.JSON(http.StatusOK, records)
+}
+
+// sessionEventHandler принимает от прокси сообщения о начале, завершении сессий и ошибках в них.
+// Доступно только конфиденциальным клиентам; прокси может сообщать только о сессиях, выданных ему
+func (app *BastionServer) sessionEventHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	clientID, ok := context.Get("ClientID").(string)
+	if !ok {
+		rl.Warn("Session events can be reported by confidential clients only")
+		return context.NoContent(http.StatusForbidden)
+	}
+	var event api.SessionEventDTO
+	if err := context.Bind(&event); err != nil {
+		rl.Warn(err.Error())
+		return context.NoContent(http.StatusBadRequest)
+	}
+	rl = rl.With(zap.String("client_id", clientID), zap.String("event", event.Event))
+	switch event.Event {
+	case api.SessionEventStarted, api.SessionEventEnded, api.SessionEventError:
+	default:
+		rl.Warn("Unknown session event")
+		return context.NoContent(http.StatusBadRequest)
+	}
+	if event.Token == "" || event.OccurredAt <= 0 {
+		rl.Warn("Malformed session event")
+		return context.NoContent(http.StatusBadRequest)
+	}
+	err := datastore.UpdateSessionHistory(context.Request().Context(), clientID, event)
+	if errors.Is(err, sql.ErrNoRows) {
+		rl.Warn("Session was not issued to this client", zap.String("token", event.Token))
+		return context.NoContent(http.StatusNotFound)
+	}
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	return context.NoContent(http.StatusOK)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bastion_server_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+	sessionsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bastion_server_sessions_created_total",
+		Help: "Number of sessions issued",
+	}, []string{"kind"})
+	oidcFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bastion_server_oidc_failures_total",
+		Help: "Number of failed OIDC authentications and authorization code exchanges",
+	}, []string{"reason"})
+)
+
+// Виды выдаваемых сессий (метка kind)
+const (
+	sessionKindWeb    = "web"
+	sessionKindDirect = "direct"
+)
+
+// Причины отказа в аутентификации OIDC (метка reason)
+const (
+	oidcFailureAccessToken  = "access_token"
+	oidcFailureIDToken      = "id_token"
+	oidcFailureState        = "state"
+	oidcFailureCodeExchange = "code_exchange"
+)
+
+// MetricsMiddleware измеряет длительность обработки запросов. Запросы группируются по шаблону маршрута,
+// а не по пути, чтобы токены и идентификаторы в пути не порождали новые ряды
+func MetricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		start := time.Now()
+		err := next(context)
+		code := context.Response().Status
+		if err != nil {
+			code = http.StatusInternalServerError
+			if he, ok := err.(*echo.HTTPError); ok {
+				code = he.Code
+			}
+		}
+		route := context.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.WithLabelValues(context.Request().Method, route, strconv.Itoa(code)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/auth"
+	"bastion/internal/datastore"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var (
+	errSecondFactorRequired = errors.New("second factor is required for this mandate")
+	errSecondFactorInvalid  = errors.New("second factor verification failed")
+)
+
+// enrollMFAHandler начинает регистрацию второго фактора: генерирует секрет TOTP и возвращает его
+// пользователю. Второй фактор включается только после подтверждения кодом (см. confirmMFAHandler)
+func (app *BastionServer) enrollMFAHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	_, enabled, err := datastore.UserTOTP(context.Request().Context(), userName)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	if enabled { // Заменить действующий второй фактор можно только через сброс администратором
+		rl.Warn("Second factor is already enabled, refusing to re-enroll")
+		return context.NoContent(http.StatusConflict)
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	err = datastore.StartTOTPEnrollment(context.Request().Context(), userName, secret)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	account, ok := context.Get("Email").(string)
+	if !ok || account == "" {
+		account = userName
+	}
+	return context.JSON(http.StatusOK, api.TOTPEnrollmentDTO{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(app.config.MFA.Issuer, account, secret),
+	})
+}
+
+// confirmMFAHandler завершает регистрацию второго фактора по первому корректному коду TOTP
+// и выдаёт пользователю новый набор кодов восстановления
+func (app *BastionServer) confirmMFAHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	params, err := context.FormParams()
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	secret, enabled, err := datastore.UserTOTP(context.Request().Context(), userName)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	if secret == "" || enabled {
+		rl.Warn("No pending second factor enrollment")
+		return context.NoContent(http.StatusConflict)
+	}
+	step, valid := auth.ValidateTOTP(secret, params.Get("code"), time.Now())
+	if !valid {
+		rl.Warn(errSecondFactorInvalid.Error())
+		return context.NoContent(http.StatusForbidden)
+	}
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(c))
+	}
+	err = datastore.ConfirmTOTPEnrollment(context.Request().Context(), userName, hashes)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	// Шаг отмечается после включения второго фактора (учитываются только шаги включённого TOTP), чтобы код
+	// подтверждения нельзя было предъявить повторно в пределах его окна действия
+	accepted, err := datastore.UseTOTPStep(context.Request().Context(), userName, step)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	if !accepted {
+		rl.Warn(errSecondFactorInvalid.Error())
+		return context.NoContent(http.StatusForbidden)
+	}
+	rl.Info("Second factor enabled", zap.String("user_sid", userName))
+	return context.JSON(http.StatusOK, api.RecoveryCodesDTO{RecoveryCodes: codes})
+}
+
+// resetMFAHandler удаляет второй фактор указанного пользователя (например, при утере устройства
+// и всех кодов восстановления). Доступно только администраторам
+func (app *BastionServer) resetMFAHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	if !app.isAdmin(userName) {
+		rl.Warn("User is not allowed to reset second factor", zap.String("user_sid", userName))
+		return context.NoContent(http.StatusForbidden)
+	}
+	target := context.Param("user")
+	err := datastore.ResetMFA(context.Request().Context(), target)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	rl.Info("Second factor reset by administrator", zap.String("user_sid", target), zap.String("admin_sid", userName))
+	return context.NoContent(http.StatusOK)
+}
+
+// checkSecondFactor проверяет, требует ли мандат второй фактор, и если да, то проверяет переданный
+// пользователем код TOTP или код восстановления
+func checkSecondFactor(ctx context.Context, userName string, mandateID int, code string) error {
+	requiresMFA, err := datastore.MandateRequiresMFA(ctx, mandateID)
+	if err != nil {
+		return err
+	}
+	if !requiresMFA {
+		return nil
+	}
+	return verifySecondFactor(ctx, userName, code)
+}
+
+// verifySecondFactor проверяет код TOTP или код восстановления пользователя
+func verifySecondFactor(ctx context.Context, userName, code string) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return errSecondFactorRequired
+	}
+	secret, enabled, err := datastore.UserTOTP(ctx, userName)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return errSecondFactorRequired
+	}
+	if step, valid := auth.ValidateTOTP(secret, code, time.Now()); valid {
+		accepted, err := datastore.UseTOTPStep(ctx, userName, step)
+		if err != nil {
+			return err
+		}
+		if !accepted {
+			return errSecondFactorInvalid
+		}
+		return nil
+	}
+	accepted, err := datastore.UseRecoveryCode(ctx, userName, auth.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return errSecondFactorInvalid
+	}
+	return nil
+}
+
+func (app *BastionServer) isAdmin(userName string) bool {
+	for _, sid := range app.config.AdminSIDs {
+		if sid == userName {
+			return true
+		}
+	}
+	return false
+}
@@ -22,6 +22,7 @@ func (app *BastionServer) OIDCMiddleware(next echo.HandlerFunc) echo.HandlerFunc
 		rl := ctx.Get(requestLoggerContextKey).(*zap.Logger)
 		pv, err := app.accessTokenPresentAndValid(ctx)
 		if err != nil {
+			oidcFailures.WithLabelValues(oidcFailureAccessToken).Inc()
 			return ctx.NoContent(http.StatusUnauthorized)
 		}
 		if pv {
@@ -35,6 +36,7 @@ func (app *BastionServer) OIDCMiddleware(next echo.HandlerFunc) echo.HandlerFunc
 		idToken, err := app.oidcClient.VerifyIDToken(rt)
 		if err != nil {
 			rl.Warn(err.Error())
+			oidcFailures.WithLabelValues(oidcFailureIDToken).Inc()
 			return app.redirectToAuthorizationServer(ctx)
 		}
 		err = app.setClaimsInContext(idToken, ctx)
@@ -102,6 +104,10 @@ func (app *BastionServer) accessTokenPresentAndValid(ctx echo.Context) (bool, er
 		return true, errors.New("unauthorized")
 	}
 	accessToken := cast.ToStringMap(v)
+	if _, isAppToken := accessToken["appid"]; !isAppToken {
+		// Не токен доступа приложения, а ID Token пользователя, полученный публичным клиентом (командной строкой)
+		return true, app.setBearerIDTokenClaims(ctx, token)
+	}
 	appID := cast.ToString(accessToken["appid"])
 	for i, ac := range app.config.OIDC.AllowedConfidentialClientIDs {
 		if ac == appID {
@@ -122,5 +128,25 @@ func (app *BastionServer) accessTokenPresentAndValid(ctx echo.Context) (bool, er
 		rl.Error("Access token expired")
 		return true, errors.New("unauthorized")
 	}
+	ctx.Set("ClientID", appID)
 	return true, nil
 }
+
+// setBearerIDTokenClaims проверяет ID Token, предъявленный в заголовке Authorization разрешённым публичным клиентом,
+// и сохраняет его клеймы в контекст текущего запроса
+func (app *BastionServer) setBearerIDTokenClaims(ctx echo.Context, rawIDToken string) error {
+	rl := ctx.Get(requestLoggerContextKey).(*zap.Logger)
+	if len(app.config.OIDC.AllowedPublicClientIDs) == 0 {
+		rl.Error("Bearer token is not an application access token and no public clients are allowed")
+		return errors.New("unauthorized")
+	}
+	idToken, err := app.oidcClient.VerifyIDTokenForClients(rawIDToken, app.config.OIDC.AllowedPublicClientIDs)
+	if err != nil {
+		rl.Error(err.Error())
+		return errors.New("unauthorized")
+	}
+	if err := app.setClaimsInContext(idToken, ctx); err != nil {
+		return errors.New("unauthorized")
+	}
+	return nil
+}
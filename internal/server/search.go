@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/datastore"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSearchLimit     = 50
+	maxSearchLimit         = 500
+	defaultSearchContext   = 2
+	maxSearchContext       = 10
+	defaultTranscriptLimit = 500
+	maxTranscriptLimit     = 5000
+)
+
+// searchHandler ищет фразу q в тексте сессий (ввод пользователей и вывод целей). Параметры запроса: stream
+// (input или output), from и to (секунды Unix), limit (число найденных строк) и context (число соседних строк).
+// Результаты сгруппированы по сессиям и дополнены записями истории сессий. Доступно только администраторам
+func (app *BastionServer) searchHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	if !app.isAdmin(userName) {
+		rl.Warn("User is not allowed to search sessions", zap.String("user_sid", userName))
+		return context.NoContent(http.StatusForbidden)
+	}
+	if app.search == nil {
+		rl.Warn("Session search is not configured")
+		return context.NoContent(http.StatusNotImplemented)
+	}
+	q := api.SessionSearchQuery{
+		Text:    context.QueryParam("q"),
+		Stream:  context.QueryParam("stream"),
+		Limit:   defaultSearchLimit,
+		Context: defaultSearchContext,
+	}
+	if q.Text == "" {
+		rl.Warn("Empty search query")
+		return context.NoContent(http.StatusBadRequest)
+	}
+	if q.Stream != "" && q.Stream != api.SearchStreamInput && q.Stream != api.SearchStreamOutput {
+		rl.Warn("Unknown session stream", zap.String("stream", q.Stream))
+		return context.NoContent(http.StatusBadRequest)
+	}
+	for param, value := range map[string]*int64{"from": &q.From, "to": &q.To} {
+		if !parseInt64Param(context, param, value, 0, 1<<40) {
+			rl.Warn("Malformed time range", zap.String(param, context.QueryParam(param)))
+			return context.NoContent(http.StatusBadRequest)
+		}
+	}
+	if !parseIntParam(context, "limit", &q.Limit, 1, maxSearchLimit) ||
+		!parseIntParam(context, "context", &q.Context, 0, maxSearchContext) {
+		rl.Warn("Malformed search limits")
+		return context.NoContent(http.StatusBadRequest)
+	}
+
+	rl.Info("Session search", zap.String("query", q.Text), zap.String("stream", q.Stream))
+	results, err := app.search.Search(q)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusBadGateway)
+	}
+	for i := range results {
+		record, err := datastore.SessionHistoryByToken(context.Request().Context(), results[i].Token)
+		switch {
+		case err == nil:
+			results[i].Session = &record
+		case errors.Is(err, sql.ErrNoRows):
+		default:
+			rl.Error(err.Error())
+			return context.NoContent(http.StatusInternalServerError)
+		}
+	}
+	return context.JSON(http.StatusOK, results)
+}
+
+// transcriptHandler возвращает строки сессии в порядке записи, начиная с момента from (миллисекунды Unix);
+// skip и limit задают страницу. Администраторы видят все сессии, остальные - только свои
+func (app *BastionServer) transcriptHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	if app.search == nil {
+		rl.Warn("Session search is not configured")
+		return context.NoContent(http.StatusNotImplemented)
+	}
+	token := context.Param("token")
+	if !app.isAdmin(userName) {
+		record, err := datastore.SessionHistoryByToken(context.Request().Context(), token)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			rl.Error(err.Error())
+			return context.NoContent(http.StatusInternalServerError)
+		}
+		if err != nil || record.UserName != userName {
+			rl.Warn("Transcript of another user's session requested")
+			return context.NoContent(http.StatusForbidden)
+		}
+	}
+	var from int64
+	skip, limit := 0, defaultTranscriptLimit
+	if !parseInt64Param(context, "from", &from, 0, 1<<50) ||
+		!parseIntParam(context, "skip", &skip, 0, maxTranscriptLimit) ||
+		!parseIntParam(context, "limit", &limit, 1, maxTranscriptLimit) {
+		rl.Warn("Malformed transcript range")
+		return context.NoContent(http.StatusBadRequest)
+	}
+	lines, err := app.search.Transcript(token, from, skip, limit)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusBadGateway)
+	}
+	return context.JSON(http.StatusOK, lines)
+}
+
+// parseIntParam читает необязательный целочисленный параметр запроса в диапазоне [lo, hi]
+func parseIntParam(context echo.Context, name string, value *int, lo, hi int) bool {
+	s := context.QueryParam(name)
+	if s == "" {
+		return true
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		return false
+	}
+	*value = v
+	return true
+}
+
+func parseInt64Param(context echo.Context, name string, value *int64, lo, hi int64) bool {
+	s := context.QueryParam(name)
+	if s == "" {
+		return true
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < lo || v > hi {
+		return false
+	}
+	*value = v
+	return true
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/datastore"
+	"bastion/internal/log"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// createSSHCertificateHandler подписывает открытый ключ пользователя сертификатом УЦ бастиона.
+// Мандаты, требующие второй фактор, попадают в сертификат только при предъявлении действующего кода
+func (app *BastionServer) createSSHCertificateHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	if app.sshCA == nil {
+		rl.Warn("SSH certificate authority is not configured")
+		return context.NoContent(http.StatusNotImplemented)
+	}
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	params, err := context.FormParams()
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(params.Get("public_key")))
+	if err != nil {
+		rl.Warn(err.Error())
+		return context.NoContent(http.StatusBadRequest)
+	}
+	if _, isCert := publicKey.(*ssh.Certificate); isCert {
+		rl.Warn("Certificate given instead of public key")
+		return context.NoContent(http.StatusBadRequest)
+	}
+
+	mandates, err := datastore.Mandates(context.Request().Context(), userName)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	mfaVerified := false
+	mandateIDs := make([]int, 0, len(mandates))
+	for _, m := range mandates {
+		if m.RequiresMFA && !mfaVerified {
+			if strings.TrimSpace(params.Get("mfa_code")) == "" {
+				continue
+			}
+			err = verifySecondFactor(context.Request().Context(), userName, params.Get("mfa_code"))
+			if errors.Is(err, errSecondFactorRequired) || errors.Is(err, errSecondFactorInvalid) {
+				rl.Warn(err.Error())
+				return context.NoContent(http.StatusForbidden)
+			}
+			if err != nil {
+				rl.Error(err.Error())
+				return context.NoContent(http.StatusInternalServerError)
+			}
+			mfaVerified = true
+		}
+		mandateIDs = append(mandateIDs, m.ID)
+	}
+
+	cert, err := app.sshCA.IssueUserCertificate(publicKey, userName, mandateIDs)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusForbidden)
+	}
+	rl.Info("SSH certificate issued",
+		zap.String("user_sid", userName),
+		zap.Uint64("serial", cert.Serial),
+		zap.Strings("principals", cert.ValidPrincipals))
+	return context.JSON(http.StatusOK, api.SSHCertificateDTO{
+		Certificate: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		ValidBefore: int64(cert.ValidBefore),
+	})
+}
+
+// createDirectSessionHandler создаёт и сразу же выдаёт прокси сессию для пользователя, аутентифицированного
+// непосредственно на прокси. Доступно только конфиденциальным клиентам (прокси).
+// Для сертификатов второй фактор проверяется при выпуске сертификата, для остальных способов аутентификации
+// прокси должен передать код: при его отсутствии или неверном коде возвращается 428 Precondition Required
+func (app *BastionServer) createDirectSessionHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	clientID, ok := context.Get("ClientID").(string)
+	if !ok {
+		rl.Warn("Direct sessions can be created by confidential clients only")
+		return context.NoContent(http.StatusForbidden)
+	}
+	var req api.CreateDirectSessionDTO
+	if err := context.Bind(&req); err != nil {
+		rl.Warn(err.Error())
+		return context.NoContent(http.StatusBadRequest)
+	}
+	rl = rl.With(zap.String("client_id", clientID), zap.String("user_sid", req.UserName), zap.String("auth_method", req.AuthMethod))
+	if err := checkMandate(context.Request().Context(), req.UserName, req.MandateID); err != nil {
+		rl.Warn(err.Error(), zap.Int("mandate_id", req.MandateID))
+		log.Audit(rl, log.AuditEvent{Event: log.AuditAuthFailed, User: req.UserName, AuthMethod: req.AuthMethod,
+			ClientIP: req.OriginIP, MandateID: req.MandateID, Error: err.Error()})
+		return context.NoContent(http.StatusForbidden)
+	}
+	if req.AuthMethod != api.AuthMethodCertificate {
+		err := checkSecondFactor(context.Request().Context(), req.UserName, req.MandateID, req.MFACode)
+		if errors.Is(err, errSecondFactorRequired) || errors.Is(err, errSecondFactorInvalid) {
+			rl.Warn(err.Error(), zap.Int("mandate_id", req.MandateID))
+			if errors.Is(err, errSecondFactorInvalid) { // Запрос кода без него - обычный шаг входа, а не отказ
+				log.Audit(rl, log.AuditEvent{Event: log.AuditAuthFailed, User: req.UserName, AuthMethod: req.AuthMethod,
+					ClientIP: req.OriginIP, MandateID: req.MandateID, Error: err.Error()})
+			}
+			return context.NoContent(http.StatusPreconditionRequired)
+		}
+		if err != nil {
+			rl.Error(err.Error())
+			return context.NoContent(http.StatusInternalServerError)
+		}
+	}
+	protocol, err := protocolForPort(context.Request().Context(), req.TargetPort)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	targetPort := req.TargetPort
+	if targetPort == 0 {
+		targetPort = protocol.DefaultPort
+	}
+
+	network, err := datastore.NetworkByMandateID(context.Request().Context(), req.MandateID)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+
+	sessionToken := uuid.NewV4().String()
+	dto := api.CreateSessionDTO{
+		OriginIP:         req.OriginIP,
+		UserName:         req.UserName,
+		TargetProtocolID: protocol.ID,
+		TargetHost:       req.TargetHost,
+		TargetPort:       targetPort,
+		AccessType:       api.AccessTypeMandate,
+		MandateID:        req.MandateID,
+	}
+	err = datastore.CreateSession(context.Request().Context(), sessionToken, dto)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	err = datastore.CreateSessionHistory(context.Request().Context(), sessionToken, dto, network.ID, req.AuthMethod)
+	if err != nil {
+		rl.Error(err.Error())
+		_ = datastore.DeleteSession(context.Request().Context(), sessionToken)
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	sessionsCreated.WithLabelValues(sessionKindDirect).Inc()
+	session, err := datastore.Session(context.Request().Context(), sessionToken)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	err = datastore.DeleteSession(context.Request().Context(), sessionToken)
+	if err != nil {
+		rl.Error(err.Error())
+	}
+	err = datastore.RedeemSessionHistory(context.Request().Context(), sessionToken, clientID)
+	if err != nil {
+		rl.Error(err.Error())
+	}
+	session.Token = sessionToken
+	// Прямая сессия выдаётся и используется прокси в одном запросе
+	for _, event := range []string{log.AuditTokenIssued, log.AuditTokenRedeemed} {
+		e := auditSessionEvent(event, session)
+		e.User, e.AuthMethod, e.MandateID = req.UserName, req.AuthMethod, req.MandateID
+		log.Audit(rl, e)
+	}
+	return context.JSON(http.StatusOK, session)
+}
+
+// protocolForPort выбирает протокол доступа по номеру порта: протокол, для которого порт является портом
+// по умолчанию, иначе SSH
+func protocolForPort(ctx context.Context, port int) (api.Protocol, error) {
+	protocols, err := datastore.Protocols(ctx)
+	if err != nil {
+		return api.Protocol{}, err
+	}
+	var result api.Protocol
+	for _, p := range protocols {
+		if port != 0 && p.DefaultPort == port {
+			return p, nil
+		}
+		if strings.EqualFold(p.Name, "ssh") {
+			result = p
+		}
+	}
+	if result.ID == 0 {
+		return result, errors.New("no SSH protocol in datastore")
+	}
+	return result, nil
+}
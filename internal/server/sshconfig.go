@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/datastore"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSSHConfigCommand      = "bastion"
+	defaultSSHConfigIdentityFile = "~/.ssh/id_ed25519"
+	sshConfigTokenUser           = "bastion" // Имя пользователя SSH в режиме токенов: токен передаётся в преамбуле
+)
+
+// sshConfigHandler формирует фрагмент ~/.ssh/config, превращающий шаблоны сессий пользователя в псевдонимы OpenSSH
+// вида <шаблон>.<сеть>. ProxyCommand вызывает командную строку бастиона, которая соединяет ssh с прокси сети.
+// Если на сервере настроен УЦ SSH, командная строка при необходимости выпускает свежий сертификат, иначе создаёт
+// сессию по шаблону и передаёт прокси её одноразовый токен.
+// Параметры запроса identity_file и certificate_file задают пути на машине пользователя (используются только
+// с сертификатами), command (повторяется) - исполняемый файл командной строки и её параметры по одному значению
+// на слово: сервер сам берёт их в кавычки оболочки. servicepoint=true выбирает внешнюю точку подключения сети
+// вместо endpoint. Значения, которые могли бы добавить в файл собственные директивы (переводы строк, кавычки, '#'),
+// отклоняются, а шаблоны с такими значениями пропускаются
+func (app *BastionServer) sshConfigHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	command := context.QueryParams()["command"]
+	if len(command) == 0 {
+		command = []string{defaultSSHConfigCommand}
+	}
+	commandLine, err := sshConfigCommandLine(command)
+	if err != nil {
+		rl.Warn("Invalid command parameter", zap.Strings("command", command), zap.String("error", err.Error()))
+		return context.NoContent(http.StatusBadRequest)
+	}
+	identityFile := context.QueryParam("identity_file")
+	if identityFile == "" {
+		identityFile = defaultSSHConfigIdentityFile
+	}
+	certificateFile := context.QueryParam("certificate_file")
+	if certificateFile == "" {
+		certificateFile = identityFile + "-cert.pub"
+	}
+	if !sshConfigPathValid(identityFile) || !sshConfigPathValid(certificateFile) {
+		rl.Warn("Invalid identity or certificate file parameter",
+			zap.String("identity_file", identityFile), zap.String("certificate_file", certificateFile))
+		return context.NoContent(http.StatusBadRequest)
+	}
+	useServicepoint := context.QueryParam("servicepoint") == "true"
+	useCertificates := app.sshCA != nil
+
+	templates, err := datastore.SessionTemlates(context.Request().Context(), userName)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Bastion session templates, generated for %s\n", sshConfigComment(userName))
+	networks := map[int]api.Network{}
+	for _, st := range templates {
+		if st.MandateID == 0 && useCertificates {
+			fmt.Fprintf(&b, "\n# %s: custom access templates are not available via ssh config, use '%s connect %d'\n",
+				sshConfigComment(st.Name), commandLine, st.ID)
+			continue
+		}
+		// Сеть шаблона с мандатом определяется мандатом, шаблона с произвольным доступом указана в нём явно
+		// (кэшируется под отрицательным идентификатором)
+		networkKey := st.MandateID
+		if st.MandateID == 0 {
+			networkKey = -st.CustomTargetNetworkID
+		}
+		network, ok := networks[networkKey]
+		if !ok {
+			if st.MandateID != 0 {
+				network, err = datastore.NetworkByMandateID(context.Request().Context(), st.MandateID)
+			} else {
+				network, err = datastore.NetworkByID(context.Request().Context(), st.CustomTargetNetworkID)
+			}
+			if err != nil {
+				rl.Error(err.Error(), zap.Int("template_id", st.ID))
+				return context.NoContent(http.StatusInternalServerError)
+			}
+			networks[networkKey] = network
+		}
+		address := network.Endpoint
+		if useServicepoint {
+			address = network.Servicepoint
+		}
+		proxyHost, proxyPort, err := net.SplitHostPort(address)
+		if err != nil {
+			rl.Error(err.Error(), zap.String("network", network.Name))
+			return context.NoContent(http.StatusInternalServerError)
+		}
+		if (useCertificates && !sshConfigWordValid(st.TargetHost)) || !sshConfigWordValid(proxyHost) || !sshConfigWordValid(proxyPort) {
+			rl.Warn("Session template has values not allowed in ssh config, skipping", zap.Int("template_id", st.ID))
+			fmt.Fprintf(&b, "\n# %s: target or proxy address is not valid for ssh config, use '%s connect %d'\n",
+				sshConfigComment(st.Name), commandLine, st.ID)
+			continue
+		}
+		fmt.Fprintf(&b, "\n# %s\n", sshConfigComment(st.Name))
+		fmt.Fprintf(&b, "Host %s.%s\n", sshConfigAlias(st.Name), sshConfigAlias(network.Name))
+		fmt.Fprintf(&b, "    HostName %s\n", proxyHost)
+		fmt.Fprintf(&b, "    Port %s\n", proxyPort)
+		if useCertificates {
+			fmt.Fprintf(&b, "    User %d+%s:%d\n", st.MandateID, st.TargetHost, st.TargetPort)
+			fmt.Fprintf(&b, "    IdentityFile %s\n", sshConfigQuote(identityFile))
+			fmt.Fprintf(&b, "    CertificateFile %s\n", sshConfigQuote(certificateFile))
+			fmt.Fprintf(&b, "    IdentitiesOnly yes\n")
+			fmt.Fprintf(&b, "    ProxyCommand %s proxy-command %%h %%p %d\n", commandLine, st.MandateID)
+		} else {
+			fmt.Fprintf(&b, "    User %s\n", sshConfigTokenUser)
+			fmt.Fprintf(&b, "    PreferredAuthentications keyboard-interactive\n")
+			fmt.Fprintf(&b, "    ProxyCommand %s proxy-token %%h %%p %d\n", commandLine, st.ID)
+		}
+	}
+	return context.String(http.StatusOK, b.String())
+}
+
+// sshConfigCommandLine собирает команду запуска командной строки для ProxyCommand из отдельных слов.
+// ProxyCommand выполняется оболочкой, поэтому слова, содержащие что-либо кроме безопасных символов, берутся
+// в двойные кавычки, а '%' удваивается, так как ssh раскрывает в ProxyCommand %-токены. Управляющие символы
+// (в том числе переводы строк) не допускаются
+func sshConfigCommandLine(words []string) (string, error) {
+	if words[0] == "" {
+		return "", errors.New("empty executable")
+	}
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if strings.IndexFunc(word, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+			return "", fmt.Errorf("control character in '%s'", sshConfigComment(word))
+		}
+		quoted = append(quoted, strings.ReplaceAll(sshConfigShellQuote(word), "%", "%%"))
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// sshConfigShellQuote берёт слово в двойные кавычки оболочки, если в нём есть символы кроме безопасных.
+// Внутри кавычек экранируются '"', '$', '`' и обратная косая черта перед ними или в конце слова: остальные
+// обратные косые черты оболочка оставляет как есть, поэтому пути Windows не меняются
+func sshConfigShellQuote(word string) string {
+	if word != "" && strings.IndexFunc(word, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_./:=+,@-", r))
+	}) < 0 {
+		return word
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; c {
+		case '"', '$', '`':
+			b.WriteByte('\\')
+		case '\\':
+			if i+1 == len(word) || strings.IndexByte("\"$`\\", word[i+1]) >= 0 {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteByte(word[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// sshConfigAlias приводит имя к виду, допустимому в шаблоне Host: строчные буквы, цифры, '-', '_' и '.'
+func sshConfigAlias(name string) string {
+	alias := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '-'
+		}
+	}, strings.TrimSpace(name))
+	if alias == "" {
+		return "unnamed"
+	}
+	return alias
+}
+
+// sshConfigComment заменяет управляющие символы пробелами, чтобы значение не вышло за пределы строки комментария
+func sshConfigComment(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// sshConfigWordValid проверяет, что значение можно записать в директиву без кавычек: в нём нет пробелов,
+// управляющих символов, кавычек и начала комментария
+func sshConfigWordValid(value string) bool {
+	return value != "" && strings.IndexFunc(value, func(r rune) bool {
+		return r <= 0x20 || r == 0x7f || r == '"' || r == '\'' || r == '#' || r == '\\'
+	}) < 0
+}
+
+// sshConfigPathValid проверяет путь к файлу на машине пользователя: пробелы допускаются (путь берётся в кавычки),
+// управляющие символы, кавычки и '#' - нет
+func sshConfigPathValid(value string) bool {
+	return strings.IndexFunc(value, func(r rune) bool {
+		return r < 0x20 || r == 0x7f || r == '"' || r == '#'
+	}) < 0
+}
+
+// sshConfigQuote берёт в кавычки путь с пробелами. Путь должен быть предварительно проверен sshConfigPathValid
+func sshConfigQuote(value string) string {
+	if strings.ContainsAny(value, " \t") {
+		return `"` + value + `"`
+	}
+	return value
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSSHConfigCommandLine проверяет, что оболочка, выполняющая ProxyCommand, получает ровно те слова,
+// которые передала командная строка бастиона
+func TestSSHConfigCommandLine(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+	cases := [][]string{
+		{"bastion"},
+		{"/opt/bastion/bin/bastion", "--server-url=https://bastion.example.com"},
+		{"/home/user/My Tools/bastion", "--config=/home/user/.config/bastion/a b.yml"},
+		{`C:\Program Files\Bastion\bastion.exe`, `--identity-file=C:\Users\user\.ssh\id_ed25519`},
+		{"/tmp/bastion", `--ssh-command=ssh -o "ProxyJump none"`, "--x=it's", "--y=$HOME`id`", `--z=a\`, "--w=100%"},
+		{"/tmp/bastion", "--empty=", "", "~/bastion", "#not-a-comment"},
+	}
+	for _, words := range cases {
+		line, err := sshConfigCommandLine(words)
+		if err != nil {
+			t.Errorf("%q: %s", words, err)
+			continue
+		}
+		// ssh раскрывает %-токены перед запуском ProxyCommand
+		expanded := strings.ReplaceAll(line, "%%", "%")
+		out, err := exec.Command(sh, "-c", `for w in `+expanded+`; do printf '%s\0' "$w"; done`).Output()
+		if err != nil {
+			t.Errorf("%q: %s", words, err)
+			continue
+		}
+		got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+		if !reflect.DeepEqual(got, words) {
+			t.Errorf("%s: got %q, want %q", line, got, words)
+		}
+	}
+}
+
+func TestSSHConfigCommandLineRejectsControlCharacters(t *testing.T) {
+	for _, words := range [][]string{
+		{""},
+		{"bastion", "--x=a\nProxyCommand evil"},
+		{"bastion\r"},
+	} {
+		if line, err := sshConfigCommandLine(words); err == nil {
+			t.Errorf("%q accepted as %s", words, line)
+		}
+	}
+}
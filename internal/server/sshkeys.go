@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bastion/internal/api"
+	"bastion/internal/auth"
+	"bastion/internal/datastore"
+	"bastion/internal/log"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+func (app *BastionServer) createSSHKeyHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	params, err := context.FormParams()
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	publicKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(params.Get("public_key")))
+	if err != nil {
+		rl.Warn(err.Error())
+		return context.NoContent(http.StatusBadRequest)
+	}
+	if _, isCert := publicKey.(*ssh.Certificate); isCert {
+		rl.Warn("Certificate given instead of public key")
+		return context.NoContent(http.StatusBadRequest)
+	}
+	name := params.Get("name")
+	if name == "" {
+		name = comment
+	}
+	key := api.SSHKey{
+		Name:        name,
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))),
+	}
+	err = datastore.CreateUserSSHKey(context.Request().Context(), userName, key)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	return context.NoContent(http.StatusOK)
+}
+
+func (app *BastionServer) deleteSSHKeyHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	userName, ok := context.Get("SID").(string)
+	if !ok {
+		rl.Error("unable to get SID from request context")
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	id, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	err = datastore.DeleteUserSSHKey(context.Request().Context(), userName, id)
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	return context.NoContent(http.StatusOK)
+}
+
+// publicKeyAuthHandler проверяет, зарегистрирован ли предъявленный прокси открытый ключ у пользователя.
+// Возвращает данные пользователя (его SID нужен прокси для создания сессии). Доступно только конфиденциальным клиентам
+func (app *BastionServer) publicKeyAuthHandler(context echo.Context) error {
+	rl := context.Get(requestLoggerContextKey).(*zap.Logger)
+	if _, ok := context.Get("ClientID").(string); !ok {
+		rl.Warn("Public key authentication can be requested by confidential clients only")
+		return context.NoContent(http.StatusForbidden)
+	}
+	var req api.PublicKeyAuthDTO
+	if err := context.Bind(&req); err != nil {
+		rl.Warn(err.Error())
+		return context.NoContent(http.StatusBadRequest)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		rl.Warn(err.Error())
+		return context.NoContent(http.StatusBadRequest)
+	}
+	user, found, err := datastore.UserSSHKeyExists(context.Request().Context(), req.UserLogin, ssh.FingerprintSHA256(publicKey))
+	if err != nil {
+		rl.Error(err.Error())
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	if !found {
+		rl.Warn("Public key is not registered for user", zap.String("user_login", req.UserLogin))
+		log.Audit(rl, log.AuditEvent{Event: log.AuditAuthFailed, User: req.UserLogin, AuthMethod: api.AuthMethodPublicKey,
+			Reason: "public key is not registered"})
+		return context.NoContent(http.StatusForbidden)
+	}
+	return context.JSON(http.StatusOK, user)
+}
+
+// updateUserLogin сохраняет короткое имя пользователя (локальную часть UPN), которое используется
+// при прямом подключении к прокси
+func (app *BastionServer) updateUserLogin(ctx context.Context, rawIDToken string, rl *zap.Logger) {
+	idToken, err := app.oidcClient.VerifyIDToken(rawIDToken)
+	if err != nil {
+		rl.Warn(err.Error())
+		return
+	}
+	var claims auth.UserClaims
+	if err := idToken.Claims(&claims); err != nil {
+		rl.Warn(err.Error())
+		return
+	}
+	login := strings.ToLower(strings.SplitN(claims.UPN, "@", 2)[0])
+	if claims.SID == "" || login == "" {
+		return
+	}
+	if err := datastore.UpdateUserLogin(ctx, claims.SID, login); err != nil {
+		rl.Warn(err.Error())
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bastion/internal/tracing"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// TracingMiddleware начинает span для каждого запроса, продолжая трассу из заголовков запроса (например, трассу
+// прокси), и дополняет логгер запроса идентификатором трассы. Должен выполняться после XRequestIDMiddleware
+func (app *BastionServer) TracingMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		req := context.Request()
+		route := context.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := tracing.Extract(req.Context(), req.Header)
+		ctx, span := tracing.StartKind(ctx, req.Method+" "+route, trace.SpanKindServer,
+			semconv.HTTPMethod(req.Method),
+			semconv.HTTPRoute(route),
+			tracing.RequestIDKey.String(req.Header.Get(echo.HeaderXRequestID)))
+		defer span.End()
+		context.SetRequest(req.WithContext(ctx))
+		if rl, ok := context.Get(requestLoggerContextKey).(*zap.Logger); ok {
+			context.Set(requestLoggerContextKey, tracing.WithTraceID(rl, ctx))
+		}
+
+		err := next(context)
+		status := context.Response().Status
+		if err != nil {
+			status = http.StatusInternalServerError
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			}
+		}
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if err != nil {
+			span.RecordError(err)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		return err
+	}
+}
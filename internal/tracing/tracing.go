@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	tracerName      = "bastion"
+	shutdownTimeout = 10 * time.Second
+)
+
+// RequestIDKey - атрибут span с идентификатором запроса из заголовка X-Request-ID
+const RequestIDKey = attribute.Key("bastion.request_id")
+
+// Config задаёт экспорт трассировки по OTLP/HTTP. Endpoint - адрес коллектора host:port, трассировка выключена,
+// если он пуст. SampleRatio - доля записываемых трасс, начатых в этом процессе (0..1); для продолжения трассы
+// другого процесса решение принимает тот, кто её начал
+type Config struct {
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+var (
+	mu       sync.Mutex
+	provider *sdktrace.TracerProvider
+)
+
+// Init настраивает глобальный TracerProvider и распространение контекста трассировки в заголовках W3C Trace Context.
+// Заголовки передаются дальше, даже если экспорт выключен
+func Init(serviceName string, c Config) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if c.Endpoint == "" {
+		return nil
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("trace sample ratio %v out of range 0-1", c.SampleRatio)
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint)}
+	if c.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Shutdown отправляет накопленные span и останавливает экспорт
+func Shutdown() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := provider.Shutdown(ctx)
+	provider = nil
+	return err
+}
+
+// Start начинает span с именем name, дочерний к span в ctx
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// StartKind начинает span заданного вида (например, trace.SpanKindClient для запросов к другому сервису)
+func StartKind(ctx context.Context, name string, kind trace.SpanKind, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attributes...))
+}
+
+// End завершает span, отмечая в нём ошибку err, если она есть
+func End(span trace.Span, err error) {
+	SetError(span, err)
+	span.End()
+}
+
+// SetError отмечает в span ошибку err, если она есть
+func SetError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// Inject добавляет в заголовки исходящего запроса контекст трассировки из ctx
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract возвращает ctx, дополненный контекстом трассировки из заголовков входящего запроса
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// WithTraceID добавляет к логгеру идентификатор трассы из ctx, чтобы записи журнала можно было сопоставить с трассой
+func WithTraceID(logger *zap.Logger, ctx context.Context) *zap.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return logger
+	}
+	return logger.With(zap.String("trace_id", sc.TraceID().String()))
+}
@@ -24,6 +24,10 @@ $("#custom").change(function() {
 });
 
 $("#createSessionButton").click(function () {
+    createSession("");
+});
+
+function createSession(mfaCode) {
     $.post("/api/sessions", {
         hostname:          $("#hostname").val(),
         port:              $("#port").val(),
@@ -34,14 +38,22 @@ $("#createSessionButton").click(function () {
         custom_login:      $("#customLogin").val(),
         custom_password:   $("#customPassword").val(),
         custom_key:        $("#customKey").val(),
+        mfa_code:          mfaCode,
     })
         .done(function( data ) {
             window.open("ssh://" + data.token + "@" + data.servicepoint,"_self")
         })
-        .fail(function() {
+        .fail(function(xhr) {
+            if (xhr.status === 403) {
+                let code = prompt("Мандат требует второй фактор. Введите одноразовый код или код восстановления:");
+                if (code) {
+                    createSession(code);
+                }
+                return;
+            }
             alert("Произошла ошибка. Сессия не создана");
         });
-});
+}
 
 $("#saveSessionButton").click(function () {
     let hostname = $("#hostname").val();
@@ -143,6 +155,195 @@ function fillConnectionParametersForm(sessionID) {
     });
 }
 
+$("#sshKeyButton").click(function () {
+    let keys = userDataCache.ssh_keys.map(function (k) { return k.name + " (" + k.fingerprint + ")"; }).join("\n");
+    let publicKey = prompt("Зарегистрированные ключи:\n" + (keys || "нет") +
+        "\n\nПрямое подключение: ssh " + userDataCache.user.login + "%<мандат>%<хост>@<прокси>" +
+        "\n\nВставьте открытый ключ для регистрации:");
+    if (!publicKey) {
+        return;
+    }
+    $.post("/api/sshkeys", { public_key: publicKey })
+        .done(function() {
+            refreshUserData();
+        })
+        .fail(function() {
+            alert("Произошла ошибка. Ключ не сохранён");
+        });
+});
+
+$("#mfaButton").click(function () {
+    if (userDataCache.mfa_enabled) {
+        alert("Второй фактор уже подключён. Для замены обратитесь к администратору");
+        return;
+    }
+    $.post("/api/mfa/enroll")
+        .done(function(enrollment) {
+            let code = prompt("Добавьте секрет в приложение-аутентификатор:\n" + enrollment.secret +
+                "\n\n" + enrollment.provisioning_uri + "\n\nи введите показанный код:");
+            if (!code) {
+                return;
+            }
+            $.post("/api/mfa/confirm", { code: code })
+                .done(function(data) {
+                    userDataCache.mfa_enabled = true;
+                    alert("Второй фактор подключён. Сохраните коды восстановления:\n\n" + data.recovery_codes.join("\n"));
+                })
+                .fail(function() {
+                    alert("Неверный код. Второй фактор не подключён");
+                });
+        })
+        .fail(function() {
+            alert("Произошла ошибка. Второй фактор не подключён");
+        });
+});
+
+$("#searchButton").click(function () {
+    $("#searchArea").toggle();
+    $("#searchText").focus();
+});
+
+$("#searchText").keypress(function (e) {
+    if (e.which === 13) {
+        searchSessions();
+    }
+});
+
+$("#searchSubmitButton").click(function () {
+    searchSessions();
+});
+
+function escapeHtml(text) {
+    return $("<div>").text(text).html();
+}
+
+function formatTime(ms) {
+    return new Date(ms).toLocaleString();
+}
+
+// dateParam переводит значение поля даты в секунды Unix: начало этих (или, если nextDay, следующих) суток по местному времени
+function dateParam(value, nextDay) {
+    if (!value) {
+        return "";
+    }
+    let d = new Date(value + "T00:00:00");
+    if (nextDay) {
+        d.setDate(d.getDate() + 1);
+    }
+    return Math.floor(d.getTime() / 1000);
+}
+
+function sessionLineHtml(line, cls, html) {
+    let streamCls = line.stream_id === "target stdin" ? " inputLine" : "";
+    return "<div class='sessionLine " + cls + streamCls + "'>" +
+        "<span class='lineTime'>" + formatTime(line.time) + "</span>" +
+        (html !== undefined ? html : escapeHtml(line.message)) +
+        "</div>";
+}
+
+function searchSessions() {
+    let text = $("#searchText").val();
+    if (text === "") {
+        return;
+    }
+    let results = $("#searchResults");
+    results.text("Поиск...");
+    $.get("/api/search", {
+        q:      text,
+        stream: $("#searchStream").val(),
+        from:   dateParam($("#searchFrom").val(), false),
+        to:     dateParam($("#searchTo").val(), true),
+    })
+        .done(function (data) {
+            results.empty();
+            if (data.length === 0) {
+                results.text("Ничего не найдено");
+                return;
+            }
+            data.forEach(function (r) {
+                let title = r.token;
+                if (r.session) {
+                    title = r.session.user_name + " → " + r.session.target_host + ":" + r.session.target_port +
+                        " (" + r.session.target_network + ", " + r.session.target_protocol + "), " +
+                        formatTime(r.session.created_at * 1000);
+                }
+                let block = $("<div class='searchSession'></div>");
+                block.append($("<div></div>").text(title));
+                r.matches.forEach(function (m) {
+                    let match = $("<div class='searchMatch'></div>");
+                    (m.before || []).forEach(function (l) { match.append(sessionLineHtml(l, "contextLine")); });
+                    let highlight = escapeHtml(m.highlight).replace(/\u0001/g, "<mark>").replace(/\u0002/g, "</mark>");
+                    match.append(sessionLineHtml(m, "", highlight));
+                    (m.after || []).forEach(function (l) { match.append(sessionLineHtml(l, "contextLine")); });
+                    let jump = $("<a href='#'>Перейти к записи сессии</a>");
+                    jump.click(function () {
+                        openTranscript(r.token, title, m.time);
+                        return false;
+                    });
+                    match.append(jump);
+                    block.append(match);
+                });
+                results.append(block);
+            });
+        })
+        .fail(function (xhr) {
+            if (xhr.status === 403) {
+                results.text("Поиск доступен только администраторам");
+            } else if (xhr.status === 501) {
+                results.text("Поиск не настроен");
+            } else {
+                results.text("Произошла ошибка поиска");
+            }
+        });
+}
+
+// Запись сессии загружается страницами начиная с некоторого времени до найденной строки. Следующая страница
+// начинается со времени последней загруженной строки, уже показанные строки с этим временем пропускаются
+const transcriptLeadMs = 60000;
+const transcriptPageSize = 500;
+var transcript = {};
+
+function openTranscript(token, title, time) {
+    transcript = { token: token, at: time, from: Math.max(time - transcriptLeadMs, 0), skip: 0, scrolled: false };
+    $("#transcriptTitle").text("Запись сессии: " + title);
+    $("#transcriptLines").empty();
+    $("#transcript").show();
+    loadTranscript();
+}
+
+$("#transcriptMoreButton").click(function () {
+    loadTranscript();
+});
+
+function loadTranscript() {
+    $.get("/api/transcripts/" + encodeURIComponent(transcript.token), {
+        from:  transcript.from,
+        skip:  transcript.skip,
+        limit: transcriptPageSize,
+    })
+        .done(function (lines) {
+            let container = $("#transcriptLines");
+            lines.forEach(function (l) {
+                let line = $(sessionLineHtml(l, l.time === transcript.at ? "currentLine" : ""));
+                container.append(line);
+                if (l.time === transcript.at && !transcript.scrolled) {
+                    transcript.scrolled = true;
+                    container.scrollTop(container.scrollTop() + line.position().top - container.height() / 2);
+                }
+                if (l.time === transcript.from) {
+                    transcript.skip++;
+                } else {
+                    transcript.from = l.time;
+                    transcript.skip = 1;
+                }
+            });
+            $("#transcriptMoreButton").toggle(lines.length === transcriptPageSize);
+        })
+        .fail(function () {
+            alert("Произошла ошибка. Запись сессии не загружена");
+        });
+}
+
 $("#logoffButton").click(function () {
     alert("Разлогинивание ещё не запилили!")
 });